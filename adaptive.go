@@ -0,0 +1,170 @@
+package main
+
+import (
+	"sync"
+	"time"
+)
+
+const (
+	// DefaultAdaptiveIncrease is the qps an AdaptiveRule gains per second of healthy responses at its
+	// limit
+	DefaultAdaptiveIncrease = 1.0
+
+	// DefaultAdaptiveDecrease is the factor an AdaptiveRule's qps is multiplied by on an unhealthy
+	// response
+	DefaultAdaptiveDecrease = 0.5
+)
+
+// AdaptiveRule adjusts the qps of a base rule from the health of the responses reported to Feedback,
+// tightening the quota when a backend slows down. It follows additive increase and multiplicative
+// decrease: every healthy response raises the qps by the increase divided by the current qps, so a
+// rule used at its limit gains about the increase each second, while every failed or slow response
+// multiplies it by the decrease. The qps is clamped to between the minimum and maximum given to
+// NewAdaptiveRule and the window of the base rule is kept. A new qps takes effect on the base rule the
+// next time it is refilled before a use, keeping the fraction of its tokens which is available. The
+// base rule belongs to the AdaptiveRule and must not be added to a manager on its own.
+type AdaptiveRule struct {
+	base       *Rule
+	updateRate time.Duration
+	applied    float64 // qps the base rule was last resized to
+
+	mu       sync.Mutex // guards the fields below, which Feedback changes outside the manager's locks
+	rate     float64
+	minRate  float64
+	maxRate  float64
+	increase float64
+	decrease float64
+	latency  time.Duration // slowest response which is healthy, any if zero
+}
+
+// NewAdaptiveRule creates a rule which adapts the qps of base between minQPS and maxQPS, starting from
+// the qps of base. Responses slower than latency are unhealthy unless it is zero. A non-positive
+// minQPS is raised to 1 so the rule can always recover, and a maxQPS below minQPS is raised to it.
+func NewAdaptiveRule(base *Rule, minQPS, maxQPS float64, latency time.Duration) *AdaptiveRule {
+	if minQPS <= 0 {
+		minQPS = 1
+	}
+	maxQPS = max(maxQPS, minQPS)
+	return &AdaptiveRule{
+		base:       base,
+		updateRate: UpdateRate,
+		applied:    base.rate,
+		rate:       min(max(base.rate, minQPS), maxQPS),
+		minRate:    minQPS,
+		maxRate:    maxQPS,
+		increase:   DefaultAdaptiveIncrease,
+		decrease:   DefaultAdaptiveDecrease,
+		latency:    latency,
+	}
+}
+
+// Factors sets the qps gained per second of healthy responses at the limit and the factor the qps is
+// multiplied by on an unhealthy response, ignoring a non-positive increase or a decrease outside of
+// (0, 1), and returns the adaptive rule
+func (ar *AdaptiveRule) Factors(increase, decrease float64) *AdaptiveRule {
+	ar.mu.Lock()
+	if increase > 0 {
+		ar.increase = increase
+	}
+	if decrease > 0 && decrease < 1 {
+		ar.decrease = decrease
+	}
+	ar.mu.Unlock()
+	return ar
+}
+
+// Feedback reports the outcome of a request which used the rule's tokens, adjusting its qps. It is
+// safe to call concurrently and while the rule is added to a manager.
+func (ar *AdaptiveRule) Feedback(success bool, latency time.Duration) {
+	ar.mu.Lock()
+	if success && (ar.latency <= 0 || latency <= ar.latency) {
+		ar.rate += ar.increase / ar.rate
+	} else {
+		ar.rate *= ar.decrease
+	}
+	ar.rate = min(max(ar.rate, ar.minRate), ar.maxRate)
+	ar.mu.Unlock()
+}
+
+// QPS returns the queries per second the rule is adapting to, which the base rule enforces from its
+// next refill
+func (ar *AdaptiveRule) QPS() float64 {
+	ar.mu.Lock()
+	rate := ar.rate
+	ar.mu.Unlock()
+	return rate
+}
+
+// Base returns the rule whose qps is adapted
+func (ar *AdaptiveRule) Base() *Rule {
+	return ar.base
+}
+
+// UseTokens uses n tokens from the base rule if it has n available
+func (ar *AdaptiveRule) UseTokens(n int) bool {
+	return ar.base.UseTokens(n)
+}
+
+// Borrow uses n tokens from the base rule whether or not they are available
+func (ar *AdaptiveRule) Borrow(n int) {
+	ar.base.Borrow(n)
+}
+
+// ReturnTokens gives back n tokens to the base rule
+func (ar *AdaptiveRule) ReturnTokens(n int) {
+	ar.base.ReturnTokens(n)
+}
+
+// SetTokens sets the tokens available on the base rule
+func (ar *AdaptiveRule) SetTokens(n int) {
+	ar.base.SetTokens(n)
+}
+
+// Full returns whether refilling would add nothing to the base rule
+func (ar *AdaptiveRule) Full() bool {
+	return ar.base.Full()
+}
+
+// Remaining returns the tokens available on the base rule
+func (ar *AdaptiveRule) Remaining() int {
+	return ar.base.Remaining()
+}
+
+// Max returns the most tokens the base rule can hold at its current qps
+func (ar *AdaptiveRule) Max() int {
+	return ar.base.Max()
+}
+
+// Cost returns the tokens used by UseToken on the base rule
+func (ar *AdaptiveRule) Cost() int {
+	return ar.base.Cost()
+}
+
+// AddToken refills the base rule
+func (ar *AdaptiveRule) AddToken() {
+	ar.base.AddToken()
+}
+
+// Refill brings the base rule up to date as of now at the qps it has enforced so far, then resizes it
+// to the qps adapted to since
+func (ar *AdaptiveRule) Refill(now time.Time) {
+	ar.base.Refill(now)
+	rate := ar.QPS()
+	if rate != ar.applied {
+		ar.base.resize(rate, ar.base.window, ar.updateRate)
+		ar.applied = rate
+	}
+}
+
+// SetSchedule configures the base rule for the manager's refill schedule and resizes it to the qps
+// adapted to so far
+func (ar *AdaptiveRule) SetSchedule(sch Schedule) {
+	ar.updateRate = sch.UpdateRate
+	ar.base.SetSchedule(sch)
+	ar.Refill(sch.Now)
+}
+
+// RetryAfter returns how long until n tokens are available on the base rule at its current qps
+func (ar *AdaptiveRule) RetryAfter(n int, sch Schedule) (time.Duration, Limiter, bool) {
+	return ar.base.RetryAfter(n, sch)
+}
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestAdaptiveRule(t *testing.T) {
+	m := NewManager()
+	ar := NewAdaptiveRule(NewRule(10, time.Second), 1, 20, 100*time.Millisecond)
+	m.AddRule("backend", ar)
+
+	ar.Feedback(false, 0)
+	if qps := ar.QPS(); qps != 5 {
+		t.Fatalf("Expected an error to halve the qps to 5 but got %v", qps)
+	}
+	if err := m.UseToken("backend"); err != nil {
+		t.Fatalf("Did not expect an error using a token, %v", err)
+	}
+	if remaining, _ := m.Remaining("backend"); remaining != 4 || ar.Max() != 5 {
+		t.Fatalf("Expected the base rule to be resized to 5 tokens with 4 left but got %d of %d", remaining, ar.Max())
+	}
+
+	ar.Feedback(true, time.Second)
+	if qps := ar.QPS(); qps != 2.5 {
+		t.Fatalf("Expected a slow response to halve the qps to 2.5 but got %v", qps)
+	}
+	for i := 0; i < 5; i++ {
+		ar.Feedback(false, 0)
+	}
+	if qps := ar.QPS(); qps != 1 {
+		t.Fatalf("Expected the qps to be clamped to 1 but got %v", qps)
+	}
+
+	ar.Feedback(true, 50*time.Millisecond)
+	if qps := ar.QPS(); qps != 2 {
+		t.Fatalf("Expected a healthy response to raise the qps to 2 but got %v", qps)
+	}
+	for i := 0; i < 1000; i++ {
+		ar.Feedback(true, 0)
+	}
+	if qps := ar.QPS(); qps != 20 {
+		t.Fatalf("Expected the qps to be clamped to 20 but got %v", qps)
+	}
+}
+
+func TestAdaptiveRuleFactors(t *testing.T) {
+	ar := NewAdaptiveRule(NewRule(10, time.Second), 0, 5, 0).Factors(10, 0.9)
+	if qps := ar.QPS(); qps != 5 {
+		t.Fatalf("Expected the starting qps to be clamped to 5 but got %v", qps)
+	}
+	ar.Feedback(false, 0)
+	if qps := ar.QPS(); qps != 4.5 {
+		t.Fatalf("Expected an error to cut the qps to 4.5 but got %v", qps)
+	}
+	ar.Feedback(true, time.Hour)
+	if qps := ar.QPS(); qps != 5 {
+		t.Fatalf("Expected any latency to be healthy without a threshold but got %v", qps)
+	}
+
+	ar.Factors(-1, 2)
+	ar.Feedback(false, 0)
+	if qps := ar.QPS(); qps != 4.5 {
+		t.Fatalf("Expected invalid factors to be ignored but got %v", qps)
+	}
+}
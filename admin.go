@@ -0,0 +1,159 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+)
+
+// KeyedAdmin serves a JSON API for inspecting and managing the rules of a manager without a restart.
+// Its Handler serves these endpoints, with keys path escaped and parsed as by LoadConfig:
+//
+//	GET    /rules               stats of every rule by key, as by StatsAll
+//	GET    /rules/{key}         the rule for key, as by Describe
+//	GET    /rules/{key}/stats   the stats of the rule for key
+//	PUT    /rules/{key}         add or update the rule for key from a rule encoded as by Rule.MarshalJSON
+//	DELETE /rules/{key}         remove the rule for key
+//	POST   /rules/{key}/reset   refill the rule for key, as by Reset
+//	POST   /rules/{key}/enable  enable the rule for key
+//	POST   /rules/{key}/disable disable the rule for key
+//
+// Keys without a rule receive a 404 Not Found and invalid keys or rules a 400 Bad Request.
+type KeyedAdmin[K comparable] struct {
+	Manager *KeyedManager[K]
+
+	// Auth wraps the endpoints which change rules, such as to check the caller's credentials, and
+	// responds itself to requests it refuses. Those endpoints receive a 403 Forbidden if it is nil, so
+	// the API is read only unless it is set.
+	Auth func(http.Handler) http.Handler
+}
+
+// Admin is a KeyedAdmin for a Manager keyed by strings
+type Admin = KeyedAdmin[string]
+
+// Handler returns the handler serving the admin API at the root of its paths. It can be mounted under
+// a prefix with http.StripPrefix, such as mux.Handle("/quota/", http.StripPrefix("/quota", h)).
+func (a *KeyedAdmin[K]) Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /rules", a.list)
+	mux.HandleFunc("GET /rules/{key}", a.describe)
+	mux.HandleFunc("GET /rules/{key}/stats", a.stats)
+	mux.Handle("PUT /rules/{key}", a.guard(a.put))
+	mux.Handle("DELETE /rules/{key}", a.guard(a.update(a.Manager.RemoveRule)))
+	mux.Handle("POST /rules/{key}/reset", a.guard(a.update(a.Manager.Reset)))
+	mux.Handle("POST /rules/{key}/enable", a.guard(a.update(a.Manager.Enable)))
+	mux.Handle("POST /rules/{key}/disable", a.guard(a.update(a.Manager.Disable)))
+	return mux
+}
+
+// guard wraps an endpoint which changes rules with Auth, refusing every request if it is nil
+func (a *KeyedAdmin[K]) guard(h http.HandlerFunc) http.Handler {
+	if a.Auth == nil {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			http.Error(w, "admin API is read only without an Auth hook", http.StatusForbidden)
+		})
+	}
+	return a.Auth(h)
+}
+
+func (a *KeyedAdmin[K]) list(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, a.Manager.StatsAll())
+}
+
+func (a *KeyedAdmin[K]) describe(w http.ResponseWriter, r *http.Request) {
+	key, ok := a.key(w, r)
+	if !ok {
+		return
+	}
+	info, err := a.Manager.Describe(key)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, info)
+}
+
+func (a *KeyedAdmin[K]) stats(w http.ResponseWriter, r *http.Request) {
+	key, ok := a.key(w, r)
+	if !ok {
+		return
+	}
+	stats, err := a.Manager.Stats(key)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, stats)
+}
+
+// put adds the rule in the request body for a key, responding with 201 Created, or updates the key's
+// rule as by UpdateRule
+func (a *KeyedAdmin[K]) put(w http.ResponseWriter, r *http.Request) {
+	key, ok := a.key(w, r)
+	if !ok {
+		return
+	}
+	rule := &Rule{}
+	if err := json.NewDecoder(r.Body).Decode(rule); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	status := http.StatusOK
+	if err := a.Manager.UpdateRule(key, rule); errors.Is(err, ErrRuleDoesNotExist) {
+		a.Manager.AddRule(key, rule)
+		status = http.StatusCreated
+	}
+	info, err := a.Manager.Describe(key)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	writeJSON(w, status, info)
+}
+
+// update returns an endpoint applying change to the rule for the key in the path, responding with 204
+// No Content
+func (a *KeyedAdmin[K]) update(change func(key K) error) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		key, ok := a.key(w, r)
+		if !ok {
+			return
+		}
+		if err := change(key); err != nil {
+			writeError(w, err)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// key parses the key in the path of a request, responding with 400 Bad Request if it is invalid
+func (a *KeyedAdmin[K]) key(w http.ResponseWriter, r *http.Request) (K, bool) {
+	key, err := parseKey[K](r.PathValue("key"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return key, false
+	}
+	return key, true
+}
+
+// writeError responds with the status for a manager error, 404 Not Found for keys without a rule
+func writeError(w http.ResponseWriter, err error) {
+	status := http.StatusInternalServerError
+	if errors.Is(err, ErrRuleDoesNotExist) {
+		status = http.StatusNotFound
+	}
+	http.Error(w, err.Error(), status)
+}
+
+// writeJSON responds with v encoded as JSON
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	data, err := json.Marshal(v)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	w.Write(data)
+}
@@ -0,0 +1,107 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// allowAdmin is an Auth hook letting requests with the admin token through
+func allowAdmin(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer admin" {
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+func TestAdmin(t *testing.T) {
+	m := NewManager()
+	m.AddRule("user1", NewRule(1, 5*time.Second))
+	m.AddRule("/search", NewRule(1, 1*time.Second))
+	m.UseToken("user1")
+	mux := http.NewServeMux()
+	mux.Handle("/quota/", http.StripPrefix("/quota", (&Admin{Manager: m, Auth: allowAdmin}).Handler()))
+
+	serve := func(method, path, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		req.Header.Set("Authorization", "Bearer admin")
+		rec := httptest.NewRecorder()
+		mux.ServeHTTP(rec, req)
+		return rec
+	}
+
+	for _, tc := range []struct {
+		method, path, body string
+		code               int
+	}{
+		{http.MethodGet, "/quota/rules/user1", "", http.StatusOK},
+		{http.MethodGet, "/quota/rules/user2/stats", "", http.StatusNotFound},
+		{http.MethodGet, "/quota/rules/%2Fsearch/stats", "", http.StatusOK},
+		{http.MethodPut, "/quota/rules/user2", `{"qps": 2, "window": "1s"}`, http.StatusCreated},
+		{http.MethodPut, "/quota/rules/user2", `{"qps": 3, "window": "1s"}`, http.StatusOK},
+		{http.MethodPut, "/quota/rules/user3", `{"qps": 0, "window": "1s"}`, http.StatusBadRequest},
+		{http.MethodPost, "/quota/rules/user1/reset", "", http.StatusNoContent},
+		{http.MethodPost, "/quota/rules/user1/disable", "", http.StatusNoContent},
+		{http.MethodPost, "/quota/rules/user3/enable", "", http.StatusNotFound},
+		{http.MethodDelete, "/quota/rules/user2", "", http.StatusNoContent},
+		{http.MethodDelete, "/quota/rules/user2", "", http.StatusNotFound},
+		{http.MethodDelete, "/quota/rules/%2Fsearch", "", http.StatusNoContent},
+	} {
+		if rec := serve(tc.method, tc.path, tc.body); rec.Code != tc.code {
+			t.Fatalf("Expected status %d for %s %s but got %d: %s", tc.code, tc.method, tc.path, rec.Code, rec.Body)
+		}
+	}
+
+	if remaining, _ := m.Remaining("user1"); remaining != 5 {
+		t.Fatalf("Expected user1 to be reset to 5 tokens but got %d", remaining)
+	}
+	if m.UseTokens("user1", 100) != nil {
+		t.Fatalf("Expected user1 to be disabled")
+	}
+	if m.Has("user2") {
+		t.Fatalf("Expected user2 to be removed")
+	}
+
+	var all map[string]RuleStats
+	if err := json.NewDecoder(serve(http.MethodGet, "/quota/rules", "").Body).Decode(&all); err != nil {
+		t.Fatalf("Did not expect an error decoding the rules, %v", err)
+	}
+	if len(all) != 1 || all["user1"].Max != 5 {
+		t.Fatalf("Expected only user1 with a max of 5 but got %+v", all)
+	}
+}
+
+func TestAdminAuth(t *testing.T) {
+	m := NewManager()
+	m.AddRule("user1", NewRule(1, 1*time.Second))
+
+	for _, tc := range []struct {
+		auth func(http.Handler) http.Handler
+		code int
+	}{
+		{nil, http.StatusForbidden},
+		{allowAdmin, http.StatusUnauthorized},
+	} {
+		h := (&Admin{Manager: m, Auth: tc.auth}).Handler()
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, httptest.NewRequest(http.MethodDelete, "/rules/user1", nil))
+		if rec.Code != tc.code {
+			t.Fatalf("Expected status %d removing a rule but got %d", tc.code, rec.Code)
+		}
+
+		rec = httptest.NewRecorder()
+		h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/rules/user1/stats", nil))
+		if rec.Code != http.StatusOK {
+			t.Fatalf("Did not expect reading stats to need authorization but got %d", rec.Code)
+		}
+	}
+	if !m.Has("user1") {
+		t.Fatalf("Did not expect an unauthorized request to remove user1")
+	}
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"time"
+)
+
+// NewAlignedRule creates a rule allowing limit queries per period which resets to limit at boundaries
+// aligned to the wall clock in loc, such as at the top of every hour or at midnight, rather than
+// refilling continuously. Periods under a day are aligned to multiples of the period since midnight and
+// periods of a day or more are rounded down to whole days which always start at midnight, so a daily
+// window spanning a daylight saving transition lasts 23 or 25 hours. A nil loc uses local time.
+// Options such as WithRollover may carry unused tokens into the next period as with other rules.
+func NewAlignedRule(limit int, period time.Duration, loc *time.Location, opts ...RuleOption) *Rule {
+	if loc == nil {
+		loc = time.Local
+	}
+	var rate float64
+	if period > 0 {
+		rate = float64(limit) / period.Seconds()
+	}
+	opts = append([]RuleOption{WithRollover(0), withAlignment(loc)}, opts...)
+	return NewRuleRate(rate, period, append(opts, withBurst(limit))...)
+}
+
+// withAlignment aligns the windows of a rule which rolls over to the wall clock in loc
+func withAlignment(loc *time.Location) RuleOption {
+	return func(r *Rule) {
+		r.align = loc
+	}
+}
+
+// days returns the whole days in the rule's window, or zero if the window is under a day
+func (r *Rule) days() int {
+	return int(r.window / (24 * time.Hour))
+}
+
+// windowFor returns the start of the window containing t, which is t itself for rules whose windows
+// are not aligned
+func (r *Rule) windowFor(t time.Time) time.Time {
+	if r.align == nil || r.window <= 0 {
+		return t
+	}
+	local := t.In(r.align)
+	if days := r.days(); days > 0 {
+		year, month, day := local.Date()
+		epochDays := time.Date(year, month, day, 0, 0, 0, 0, time.UTC).Unix() / (24 * 60 * 60)
+		day -= int((epochDays%int64(days) + int64(days)) % int64(days))
+		return time.Date(year, month, day, 0, 0, 0, 0, r.align)
+	}
+	_, offset := local.Zone()
+	since := (time.Duration(t.UnixNano()) + time.Duration(offset)*time.Second) % r.window
+	if since < 0 {
+		since += r.window
+	}
+	return t.Add(-since)
+}
+
+// nextWindow returns the start of the window following the one starting at start
+func (r *Rule) nextWindow(start time.Time) time.Time {
+	if r.align == nil {
+		return start.Add(r.window)
+	}
+	if days := r.days(); days > 0 {
+		year, month, day := start.In(r.align).Date()
+		return time.Date(year, month, day+days, 0, 0, 0, 0, r.align)
+	}
+	// a change of zone offset may move the boundary, but never to or before start
+	if next := r.windowFor(start.Add(r.window)); next.After(start) {
+		return next
+	}
+	return start.Add(r.window)
+}
+
+// rollOverAligned starts a new window for every aligned window boundary passed since the current
+// window started
+func (r *Rule) rollOverAligned(now time.Time) {
+	for next := r.nextWindow(r.windowStart); !next.After(now); next = r.nextWindow(next) {
+		r.windowStart = next
+		count := r.carry(r.tokens())
+		if count == r.tokens() {
+			r.windowStart = r.windowFor(now)
+			return
+		}
+		r.setCount(count)
+	}
+}
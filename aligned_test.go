@@ -0,0 +1,87 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestAlignedRule(t *testing.T) {
+	clock := newFakeClock()
+	m := NewManagerLazy(WithClock(clock))
+	clock.Advance(30 * time.Minute)
+
+	user := "user1"
+	m.AddRule(user, NewAlignedRule(3, time.Hour, time.UTC))
+	if err := m.UseTokens(user, 3); err != nil {
+		t.Fatalf("Did not expect an error using the hour's quota, %v", err)
+	}
+	err := m.UseToken(user)
+	var qerr *QuotaExceededError
+	if !errors.As(err, &qerr) || qerr.RetryAfter != 30*time.Minute {
+		t.Fatalf("Expected %v retrying at the top of the hour but got %v", ErrQuotaExceeded, err)
+	}
+
+	clock.Advance(29 * time.Minute)
+	if remaining, _ := m.Remaining(user); remaining != 0 {
+		t.Fatalf("Did not expect tokens to trickle in before the hour but got %d", remaining)
+	}
+	clock.Advance(time.Minute)
+	if remaining, _ := m.Remaining(user); remaining != 3 {
+		t.Fatalf("Expected the quota to reset at the top of the hour but got %d", remaining)
+	}
+}
+
+func TestAlignedRuleWindows(t *testing.T) {
+	newYork, err := time.LoadLocation("America/New_York")
+	if err != nil {
+		t.Skipf("time zone data unavailable, %v", err)
+	}
+	kolkata := time.FixedZone("IST", 5*60*60+30*60)
+	daily := NewAlignedRule(10, 24*time.Hour, newYork)
+	hourly := NewAlignedRule(10, time.Hour, newYork)
+
+	testData := []struct {
+		r          *Rule
+		at         time.Time
+		start      time.Time
+		windowSize time.Duration
+	}{
+		// daylight saving starts and ends, shortening and lengthening the day
+		{daily, time.Date(2024, 3, 10, 12, 0, 0, 0, newYork), time.Date(2024, 3, 10, 0, 0, 0, 0, newYork), 23 * time.Hour},
+		{daily, time.Date(2024, 11, 3, 12, 0, 0, 0, newYork), time.Date(2024, 11, 3, 0, 0, 0, 0, newYork), 25 * time.Hour},
+		// the repeated hour when daylight saving ends is its own window
+		{hourly, time.Date(2024, 11, 3, 6, 30, 0, 0, time.UTC), time.Date(2024, 11, 3, 6, 0, 0, 0, time.UTC), time.Hour},
+		{NewAlignedRule(10, time.Hour, kolkata), time.Date(2024, 1, 1, 10, 45, 0, 0, kolkata), time.Date(2024, 1, 1, 10, 0, 0, 0, kolkata), time.Hour},
+		{NewAlignedRule(10, 48*time.Hour, time.UTC), time.Date(1970, 1, 4, 12, 0, 0, 0, time.UTC), time.Date(1970, 1, 3, 0, 0, 0, 0, time.UTC), 48 * time.Hour},
+	}
+	for _, tc := range testData {
+		start := tc.r.windowFor(tc.at)
+		if !start.Equal(tc.start) {
+			t.Fatalf("Expected the window containing %v to start at %v but got %v", tc.at, tc.start, start)
+		}
+		if size := tc.r.nextWindow(start).Sub(start); size != tc.windowSize {
+			t.Fatalf("Expected the window starting at %v to last %v but got %v", start, tc.windowSize, size)
+		}
+	}
+}
+
+func TestAlignedRuleJSON(t *testing.T) {
+	data, err := json.Marshal(NewAlignedRule(5, time.Hour, time.UTC))
+	if err != nil {
+		t.Fatalf("Did not expect an error marshaling an aligned rule, %v", err)
+	}
+	var r Rule
+	if err := json.Unmarshal(data, &r); err != nil {
+		t.Fatalf("Did not expect an error unmarshaling an aligned rule, %v", err)
+	}
+	if r.align != time.UTC || !r.rollover || r.Max() != 5 {
+		t.Fatalf("Expected the aligned rule to round trip but got %s from %s", r.String(), data)
+	}
+
+	data = []byte(`{"qps": 1, "window": "1h", "rollover": true, "align": "Nowhere/Special"}`)
+	if err := json.Unmarshal(data, &r); !errors.Is(err, ErrInvalidRule) {
+		t.Fatalf("Expected %v for an unknown location but got %v", ErrInvalidRule, err)
+	}
+}
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"fmt"
+	"time"
+)
+
+// Backend stores token buckets outside of the manager so that managers in several processes can
+// enforce the same quota. Implementations must be safe for concurrent use.
+type Backend interface {
+	// UseTokens refills the bucket for key at rate tokens per second up to burst tokens as of now and
+	// uses n tokens if they are all available, atomically. If they are not it returns how long until
+	// they are expected to be, or false for ok with a zero delay if they never will be.
+	UseTokens(key string, rate float64, burst, n int, now time.Time) (ok bool, retryAfter time.Duration, err error)
+}
+
+// FailurePolicy decides whether token uses are allowed while a backend cannot be reached
+type FailurePolicy int
+
+const (
+	// FailClosed denies token uses which the backend could not decide, returning an error wrapping
+	// ErrBackendUnavailable
+	FailClosed FailurePolicy = iota
+
+	// FailOpen allows token uses which the backend could not decide
+	FailOpen
+)
+
+// WithBackend has a manager use tokens for its *Rule rules from a shared backend rather than in
+// memory, using the rule's rate and burst. The policy decides what happens when the backend returns an
+// error. Only UseToken, UseTokens and WaitToken consult the backend, while other limiters and methods
+// such as Remaining and Reserve continue to use the manager's own rules. Keys which are not strings
+// are stored in the backend under their formatting with %+v.
+func WithBackend(b Backend, policy FailurePolicy) Option {
+	return func(cfg *config) {
+		cfg.backend = b
+		cfg.backendPolicy = policy
+	}
+}
+
+// keyString returns a key as a string, such as the key a backend stores its bucket under or an expvar
+// label. Keys which are not strings are formatted with their field values, so distinct keys must
+// format differently.
+func keyString[K comparable](key K) string {
+	if s, ok := any(key).(string); ok {
+		return s
+	}
+	return fmt.Sprintf("%+v", key)
+}
+
+// useBackend uses n tokens for the entry of a key from the backend. The shard must not be locked as
+// the backend may make network requests.
+func (m *KeyedManager[K]) useBackend(s *shard[K], e *entry[K], key K, rate float64, burst, n int) error {
+	now := m.clock.Now()
+	ok, retryAfter, err := m.backend.UseTokens(keyString(key), rate, burst, n, now)
+	if err != nil {
+		if m.backendPolicy == FailOpen {
+			ok = true
+			m.failOpenAllows.Add(1)
+		} else {
+			return fmt.Errorf("%w: %v", ErrBackendUnavailable, err)
+		}
+	}
+
+	s.Lock()
+	if ok {
+		e.allowed.Add(1)
+	} else {
+		e.denied.Add(1)
+	}
+	rule := e.rule
+	s.Unlock()
+	m.publish(key, ok, -1, now)
+	if ok {
+		return nil
+	}
+
+	return m.deny(key, &KeyedQuotaExceededError[K]{Key: key, Rule: rule, RetryAfter: retryAfter})
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"errors"
+	"sync"
+	"testing"
+	"time"
+)
+
+// sharedBackend is an in-memory Backend which several managers can share
+type sharedBackend struct {
+	sync.Mutex
+	tokens map[string]int
+	err    error
+}
+
+func (b *sharedBackend) UseTokens(key string, rate float64, burst, n int, now time.Time) (bool, time.Duration, error) {
+	b.Lock()
+	defer b.Unlock()
+	if b.err != nil {
+		return false, 0, b.err
+	}
+	tokens, exists := b.tokens[key]
+	if !exists {
+		tokens = burst
+	}
+	if tokens < n {
+		return false, time.Duration(float64(n-tokens) / rate * float64(time.Second)), nil
+	}
+	b.tokens[key] = tokens - n
+	return true, 0, nil
+}
+
+func TestBackendShared(t *testing.T) {
+	b := &sharedBackend{tokens: make(map[string]int)}
+	m1 := NewManager(WithBackend(b, FailClosed))
+	m2 := NewManager(WithBackend(b, FailClosed))
+
+	user := "user1"
+	m1.AddRule(user, NewRule(2, 3*time.Second))
+	m2.AddRule(user, NewRule(2, 3*time.Second))
+	for i := 0; i < 3; i++ {
+		if err := m1.UseToken(user); err != nil {
+			t.Fatalf("Did not expect an error on valid user, %v", err)
+		}
+		if err := m2.UseToken(user); err != nil {
+			t.Fatalf("Did not expect an error on valid user, %v", err)
+		}
+	}
+
+	err := m2.UseToken(user)
+	var qerr *QuotaExceededError
+	if !errors.As(err, &qerr) || qerr.RetryAfter != 500*time.Millisecond {
+		t.Fatalf("Expected %v retrying after 500ms across both managers but got %v", ErrQuotaExceeded, err)
+	}
+	if stats, _ := m2.Stats(user); stats.Allowed != 3 || stats.Denied != 1 {
+		t.Fatalf("Expected 3 allowed and 1 denied but got %+v", stats)
+	}
+
+	// other limiters stay in memory
+	m1.AddRule("user2", NewSlidingWindowRule(1, time.Second))
+	if err := m1.UseToken("user2"); err != nil {
+		t.Fatalf("Did not expect an error on an in-memory rule, %v", err)
+	}
+}
+
+func TestBackendFailurePolicy(t *testing.T) {
+	b := &sharedBackend{tokens: make(map[string]int), err: errors.New("connection refused")}
+
+	open := NewManager(WithBackend(b, FailOpen))
+	open.AddRule("user1", NewRule(1, 1*time.Second))
+	for i := 0; i < 3; i++ {
+		if err := open.UseToken("user1"); err != nil {
+			t.Fatalf("Expected a fail open backend to allow tokens but got %v", err)
+		}
+	}
+
+	closed := NewManager(WithBackend(b, FailClosed))
+	closed.AddRule("user1", NewRule(1, 1*time.Second))
+	if err := closed.UseToken("user1"); !errors.Is(err, ErrBackendUnavailable) {
+		t.Fatalf("Expected %v from a fail closed backend but got %v", ErrBackendUnavailable, err)
+	}
+	if err := closed.UseToken("user2"); !errors.Is(err, ErrRuleDoesNotExist) {
+		t.Fatalf("Expected %v for a missing rule but got %v", ErrRuleDoesNotExist, err)
+	}
+}
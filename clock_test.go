@@ -0,0 +1,9 @@
+package main
+
+import (
+	"time"
+)
+
+func newFakeClock() *FakeClock {
+	return NewFakeClock(time.Unix(0, 0))
+}
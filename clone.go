@@ -0,0 +1,93 @@
+package main
+
+import (
+	"container/list"
+)
+
+// Clone returns an independent copy of the rule, including its tokens and refill state, such as to
+// experiment with a rule's state without affecting it. Calling Clone on a rule added to a manager is
+// only safe through the manager's Clone.
+func (r *Rule) Clone() *Rule {
+	c := &Rule{
+		rate:        r.rate,
+		window:      r.window,
+		maxQueries:  r.maxQueries,
+		burst:       r.burst,
+		addTokens:   r.addTokens,
+		accrued:     r.accrued,
+		lazy:        r.lazy,
+		lastRefill:  r.lastRefill,
+		cost:        r.cost,
+		costFunc:    r.costFunc,
+		rollover:    r.rollover,
+		maxCarry:    r.maxCarry,
+		windowStart: r.windowStart,
+		align:       r.align,
+		softLimit:   r.softLimit,
+		softCrossed: r.softCrossed,
+		warmup:      r.warmup,
+		warmupLeft:  r.warmupLeft,
+		interval:    r.interval,
+		every:       r.every,
+		queued:      r.queued,
+		elapsed:     r.elapsed,
+		overdraft:   r.overdraft,
+	}
+	c.setCount(r.tokens())
+	return c
+}
+
+// Clone returns a new manager with the same options and a copy of every registered *Rule, with its
+// tokens and stats, keyed and hashed afresh, such as to try out changes on a fork of live state and
+// discard it. Each shard is copied under its read lock, one shard at a time. Other limiters are left
+// out, as are reservations, which keep holding tokens from this manager's rules. The clone shares the
+// manager's callbacks, clock and backend but nothing else, copying any global limit. It starts without
+// running, closing or draining, whatever the state of this manager, so Run must be called for it to
+// refill.
+func (m *KeyedManager[K]) Clone() *KeyedManager[K] {
+	m.Lock()
+	cfg := m.config
+	onExceeded, onSoftLimit := m.onExceeded, m.onSoftLimit
+	defaultRule, finest := m.defaultRule, m.finest
+	m.Unlock()
+
+	if cfg.global != nil {
+		m.globalMu.Lock()
+		cfg.global = cfg.global.Clone()
+		m.globalMu.Unlock()
+	}
+	c := NewKeyedManagerWithShards[K](len(m.shards), func(c *config) {
+		*c = cfg
+	})
+	c.onExceeded, c.onSoftLimit, c.finest = onExceeded, onSoftLimit, finest
+	if defaultRule != nil {
+		c.defaultRule = defaultRule.Clone()
+	}
+	c.lists.Store(m.lists.Load())
+	for i, s := range m.shards {
+		if s.lru != nil {
+			c.shards[i].lru, c.shards[i].capacity = list.New(), s.capacity
+		}
+	}
+
+	for _, s := range m.shards {
+		s.RLock()
+		for _, e := range s.rules {
+			for ; e != nil; e = e.next {
+				r, ok := e.rule.(*Rule)
+				if !ok {
+					continue
+				}
+				h := c.hash(e.key)
+				cs := c.shard(h)
+				ce, _ := cs.set(h, e.key, r.Clone())
+				ce.allowed.Store(e.allowed.Load())
+				ce.denied.Store(e.denied.Load())
+				ce.parent, ce.hasParent, ce.disabled = e.parent, e.hasParent, e.disabled
+				cs.touch(ce, e.lastAccess)
+			}
+		}
+		s.RUnlock()
+	}
+	return c
+}
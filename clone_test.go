@@ -0,0 +1,56 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestRuleClone(t *testing.T) {
+	r := NewRule(1, 5*time.Second, WithCost(2), WithRollover(1))
+	r.UseTokens(3)
+
+	c := r.Clone()
+	if c.tokens() != 2 || c.Cost() != 2 || !c.rollover || c.maxCarry != 1 {
+		t.Fatalf("Expected the clone to copy the rule but got %s", c.String())
+	}
+	c.UseTokens(2)
+	if r.tokens() != 2 {
+		t.Fatalf("Did not expect using the clone to change the rule but got %d tokens", r.tokens())
+	}
+}
+
+func TestManagerClone(t *testing.T) {
+	clock := newFakeClock()
+	m := NewManager(WithClock(clock))
+	m.Run()
+	defer m.Stop()
+	m.AddRule("user1", NewRule(1, 5*time.Second))
+	m.AddRule("user2", NewSlidingWindowRule(1, time.Second))
+	m.SetDefaultRule(NewRule(2, time.Second))
+	m.UseTokens("user1", 3)
+	m.UseToken("user1")
+
+	c := m.Clone()
+	if c.Len() != 1 {
+		t.Fatalf("Expected only the *Rule to be cloned but got %d rules", c.Len())
+	}
+	if stats, _ := c.Stats("user1"); stats.Allowed != 2 || stats.Current != 1 {
+		t.Fatalf("Expected the clone to copy the stats and tokens of user1 but got %+v", stats)
+	}
+	c.UseToken("user1")
+	if remaining, _ := m.Remaining("user1"); remaining != 1 {
+		t.Fatalf("Did not expect using the clone to change the manager but got %d tokens", remaining)
+	}
+	if remaining, _ := c.Remaining("user3"); remaining != 0 {
+		t.Fatalf("Did not expect a rule for user3 before it is used but got %d tokens", remaining)
+	}
+	if err := c.UseToken("user3"); err != nil {
+		t.Fatalf("Expected the clone to create rules from the default rule, %v", err)
+	}
+
+	clock.Advance(UpdateRate)
+	time.Sleep(10 * time.Millisecond)
+	if remaining, _ := c.Remaining("user1"); remaining != 0 {
+		t.Fatalf("Did not expect the clone to refill before it is run but got %d tokens", remaining)
+	}
+}
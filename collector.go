@@ -0,0 +1,96 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// LabelFunc maps a rule key to the value of its "key" label. Returning false drops the key from the
+// exported metrics, and keys mapping to the same label are summed, which keeps cardinality bounded.
+type LabelFunc func(key string) (string, bool)
+
+// KeyedCollector exports the usage of a KeyedManager's rules as Prometheus metrics. Allowed and denied
+// counts are exported as counters, so resetting stats on the manager will appear as a counter reset.
+type KeyedCollector[K comparable] struct {
+	m         *KeyedManager[K]
+	labelFunc func(key K) (string, bool)
+
+	current *prometheus.Desc
+	max     *prometheus.Desc
+	allowed *prometheus.Desc
+	denied  *prometheus.Desc
+}
+
+// Collector is a KeyedCollector for a Manager keyed by strings
+type Collector = KeyedCollector[string]
+
+// NewCollector creates a collector for a manager with metrics under the given namespace. A nil
+// labelFunc labels each metric with its rule key, formatted with %v if it is not a string.
+func NewCollector[K comparable](m *KeyedManager[K], namespace string, labelFunc func(key K) (string, bool)) *KeyedCollector[K] {
+	if labelFunc == nil {
+		labelFunc = func(key K) (string, bool) {
+			if s, ok := any(key).(string); ok {
+				return s, true
+			}
+			return fmt.Sprint(key), true
+		}
+	}
+	labels := []string{"key"}
+	return &KeyedCollector[K]{
+		m:         m,
+		labelFunc: labelFunc,
+		current: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "quota", "tokens_current"),
+			"Number of tokens currently available",
+			labels, nil,
+		),
+		max: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "quota", "tokens_max"),
+			"Maximum number of tokens that can be held",
+			labels, nil,
+		),
+		allowed: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "quota", "allowed_total"),
+			"Number of tokens allowed",
+			labels, nil,
+		),
+		denied: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "quota", "denied_total"),
+			"Number of tokens denied",
+			labels, nil,
+		),
+	}
+}
+
+// Describe implements prometheus.Collector
+func (c *KeyedCollector[K]) Describe(ch chan<- *prometheus.Desc) {
+	ch <- c.current
+	ch <- c.max
+	ch <- c.allowed
+	ch <- c.denied
+}
+
+// Collect implements prometheus.Collector
+func (c *KeyedCollector[K]) Collect(ch chan<- prometheus.Metric) {
+	byLabel := make(map[string]RuleStats)
+	for key, stats := range c.m.StatsAll() {
+		label, ok := c.labelFunc(key)
+		if !ok {
+			continue
+		}
+		sum := byLabel[label]
+		sum.Allowed += stats.Allowed
+		sum.Denied += stats.Denied
+		sum.Current += stats.Current
+		sum.Max += stats.Max
+		byLabel[label] = sum
+	}
+
+	for label, stats := range byLabel {
+		ch <- prometheus.MustNewConstMetric(c.current, prometheus.GaugeValue, float64(stats.Current), label)
+		ch <- prometheus.MustNewConstMetric(c.max, prometheus.GaugeValue, float64(stats.Max), label)
+		ch <- prometheus.MustNewConstMetric(c.allowed, prometheus.CounterValue, float64(stats.Allowed), label)
+		ch <- prometheus.MustNewConstMetric(c.denied, prometheus.CounterValue, float64(stats.Denied), label)
+	}
+}
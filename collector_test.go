@@ -0,0 +1,74 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestCollector(t *testing.T) {
+	m := NewManager()
+
+	m.AddRule("user1", NewRule(1, 3*time.Second))
+	m.AddRule("user2", NewRule(1, 2*time.Second))
+	for i := 0; i < 5; i++ {
+		m.UseToken("user1")
+	}
+
+	c := NewCollector(m, "test", nil)
+	expected := `
+# HELP test_quota_allowed_total Number of tokens allowed
+# TYPE test_quota_allowed_total counter
+test_quota_allowed_total{key="user1"} 3
+test_quota_allowed_total{key="user2"} 0
+# HELP test_quota_denied_total Number of tokens denied
+# TYPE test_quota_denied_total counter
+test_quota_denied_total{key="user1"} 2
+test_quota_denied_total{key="user2"} 0
+# HELP test_quota_tokens_current Number of tokens currently available
+# TYPE test_quota_tokens_current gauge
+test_quota_tokens_current{key="user1"} 0
+test_quota_tokens_current{key="user2"} 2
+# HELP test_quota_tokens_max Maximum number of tokens that can be held
+# TYPE test_quota_tokens_max gauge
+test_quota_tokens_max{key="user1"} 3
+test_quota_tokens_max{key="user2"} 2
+`
+	if err := testutil.CollectAndCompare(c, strings.NewReader(expected)); err != nil {
+		t.Fatalf("Did not expect an error collecting metrics, %v", err)
+	}
+}
+
+func TestCollectorLabelFunc(t *testing.T) {
+	m := NewManager()
+
+	m.AddRule("tenant1:user1", NewRule(1, 3*time.Second))
+	m.AddRule("tenant1:user2", NewRule(1, 2*time.Second))
+	m.AddRule("internal", NewRule(1, 1*time.Second))
+	m.UseToken("tenant1:user1")
+	m.UseToken("tenant1:user2")
+
+	tenant := func(key string) (string, bool) {
+		i := strings.Index(key, ":")
+		if i < 0 {
+			return "", false
+		}
+		return key[:i], true
+	}
+	c := NewCollector(m, "test", tenant)
+	expected := `
+# HELP test_quota_allowed_total Number of tokens allowed
+# TYPE test_quota_allowed_total counter
+test_quota_allowed_total{key="tenant1"} 2
+# HELP test_quota_tokens_current Number of tokens currently available
+# TYPE test_quota_tokens_current gauge
+test_quota_tokens_current{key="tenant1"} 3
+`
+	err := testutil.CollectAndCompare(c, strings.NewReader(expected),
+		"test_quota_allowed_total", "test_quota_tokens_current")
+	if err != nil {
+		t.Fatalf("Did not expect an error collecting metrics, %v", err)
+	}
+}
@@ -0,0 +1,47 @@
+package main
+
+// WithCompactAfter compacts a shard, as Compact does, once n rules have been removed from it since it
+// was last compacted, whether by RemoveRule or by evicting idle or least recently used rules, such as
+// for services churning through many short-lived keys. Non-positive n are ignored and shards are only
+// compacted by Compact.
+func WithCompactAfter(n int) Option {
+	return func(c *config) {
+		if n > 0 {
+			c.compactAfter = n
+		}
+	}
+}
+
+// Compact rebuilds the rule map of every shard holding fewer than a quarter of the most rules it has
+// held since it was last compacted, releasing the memory kept by removed rules since Go maps never
+// shrink. It is O(n) in the number of rules and locks one shard at a time while it copies its rules,
+// blocking their token uses, so it should be called sparingly, such as after removing many rules.
+func (m *KeyedManager[K]) Compact() {
+	for _, s := range m.shards {
+		s.Lock()
+		s.compact()
+		s.Unlock()
+	}
+}
+
+// removed compacts a shard once enough rules have been removed from it for WithCompactAfter. The
+// shard must be locked.
+func (m *KeyedManager[K]) removed(s *shard[K]) {
+	if m.compactAfter > 0 && s.removals >= m.compactAfter {
+		s.compact()
+	}
+}
+
+// compact rebuilds the rule map if it holds fewer than a quarter of the most rules it has held, and
+// restarts counting removals
+func (s *shard[K]) compact() {
+	s.removals = 0
+	if len(s.rules) >= s.rulesPeak/4 {
+		return
+	}
+	rules := make(map[uint64]*entry[K], len(s.rules))
+	for h, e := range s.rules {
+		rules[h] = e
+	}
+	s.rules, s.rulesPeak = rules, len(rules)
+}
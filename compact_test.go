@@ -0,0 +1,59 @@
+package main
+
+import (
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestCompact(t *testing.T) {
+	m := NewManagerWithShards(1)
+	for i := 0; i < 100; i++ {
+		m.AddRule("user"+strconv.Itoa(i), NewRule(1, 1*time.Second))
+	}
+	for i := 0; i < 90; i++ {
+		m.RemoveRule("user" + strconv.Itoa(i))
+	}
+
+	s := m.shards[0]
+	m.Compact()
+	if s.rulesPeak != 10 || s.removals != 0 {
+		t.Fatalf("Expected the shard to be compacted to 10 rules but got a peak of %d with %d removals", s.rulesPeak, s.removals)
+	}
+	for i := 90; i < 100; i++ {
+		if err := m.UseToken("user" + strconv.Itoa(i)); err != nil {
+			t.Fatalf("Did not expect an error using a token after compacting, %v", err)
+		}
+	}
+
+	m.RemoveRule("user90")
+	m.Compact()
+	if s.rulesPeak != 10 {
+		t.Fatalf("Did not expect a mostly occupied shard to be compacted but got a peak of %d", s.rulesPeak)
+	}
+}
+
+func TestCompactAfter(t *testing.T) {
+	m := NewManagerWithShards(1, WithCompactAfter(50))
+	for i := 0; i < 100; i++ {
+		m.AddRule("user"+strconv.Itoa(i), NewRule(1, 1*time.Second))
+	}
+	for i := 0; i < 49; i++ {
+		m.RemoveRule("user" + strconv.Itoa(i))
+	}
+	s := m.shards[0]
+	if s.rulesPeak != 100 || s.removals != 49 {
+		t.Fatalf("Did not expect the shard to be compacted yet but got a peak of %d with %d removals", s.rulesPeak, s.removals)
+	}
+
+	m.RemoveRule("user49")
+	if s.removals != 0 {
+		t.Fatalf("Expected removals to be counted afresh once checked but got %d", s.removals)
+	}
+	for i := 50; i < 100; i++ {
+		m.RemoveRule("user" + strconv.Itoa(i))
+	}
+	if s.rulesPeak != 0 || len(s.rules) != 0 {
+		t.Fatalf("Expected the emptied shard to be compacted but got a peak of %d", s.rulesPeak)
+	}
+}
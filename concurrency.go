@@ -0,0 +1,151 @@
+package main
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// ConcurrencyRule limits how many requests for a key are in flight at once rather than how often they
+// start, such as to protect a downstream with a bounded pool of connections. Each token is a slot which
+// is held until it is given back, never refilled as time passes, so slots are best taken with Acquire
+// and given back with the release func it returns. Slots taken by UseToken or WaitToken must be given
+// back with ReturnToken.
+type ConcurrencyRule struct {
+	limit int
+	held  atomic.Int64 // slots in use, which exceeds limit after borrowing, read by Held without a lock
+}
+
+// NewConcurrencyRule creates a concurrency rule allowing up to limit slots to be held at once
+func NewConcurrencyRule(limit int) *ConcurrencyRule {
+	return &ConcurrencyRule{limit: limit}
+}
+
+// Held returns the number of slots currently held
+func (c *ConcurrencyRule) Held() int {
+	return int(c.held.Load())
+}
+
+// UseTokens takes n slots if they are all free and returns whether they were taken
+func (c *ConcurrencyRule) UseTokens(n int) bool {
+	if c.Held()+n > c.limit {
+		return false
+	}
+	c.held.Add(int64(n))
+	return true
+}
+
+// Borrow takes n slots whether or not they are free, so that no more are free until enough are given
+// back
+func (c *ConcurrencyRule) Borrow(n int) {
+	c.held.Add(int64(n))
+}
+
+// ReturnTokens gives back n held slots, never freeing more slots than are held
+func (c *ConcurrencyRule) ReturnTokens(n int) {
+	c.held.Store(int64(max(c.Held()-n, 0)))
+}
+
+// Remaining returns the number of free slots
+func (c *ConcurrencyRule) Remaining() int {
+	return max(c.limit-c.Held(), 0)
+}
+
+// Max returns the most slots which can be held at once
+func (c *ConcurrencyRule) Max() int {
+	return c.limit
+}
+
+// SetTokens holds slots so that n are free, clamped to between zero and the limit
+func (c *ConcurrencyRule) SetTokens(n int) {
+	c.held.Store(int64(c.limit - min(max(n, 0), c.limit)))
+}
+
+// Full returns true since slots are only freed by giving them back, not by refills
+func (c *ConcurrencyRule) Full() bool {
+	return true
+}
+
+// AddToken is a no-op since slots are only freed by giving them back
+func (c *ConcurrencyRule) AddToken() {}
+
+// Refill is a no-op since slots are only freed by giving them back
+func (c *ConcurrencyRule) Refill(now time.Time) {}
+
+// SetSchedule is a no-op since the rule does not depend on the refill schedule
+func (c *ConcurrencyRule) SetSchedule(sch Schedule) {}
+
+// RetryAfter returns zero, since when slots are given back cannot be known, and false if n slots can
+// never be held at once
+func (c *ConcurrencyRule) RetryAfter(n int, sch Schedule) (time.Duration, Limiter, bool) {
+	return 0, c, n <= c.limit
+}
+
+// Acquire takes a slot for a key, such as one of a ConcurrencyRule, and returns a func giving it back
+// once the caller is done with it. Calling release more than once gives back the slot only once. A
+// slot is taken from the key's rule alone, not from parents added by AddChildRule, the global limit
+// or a backend, and without counting soft limits. Uses which take no slot, such as for allowlisted or
+// disabled keys or those allowed by dry run or failing open, return a release which does nothing. Any
+// other limiter can be acquired too, its token given back to it on release. Errors are those of
+// UseToken.
+func (m *KeyedManager[K]) Acquire(key K) (release func(), err error) {
+	key = m.normalize(key)
+	if err := m.admitting(); err != nil {
+		return nil, err
+	}
+	if l, ok := m.listed(key); ok {
+		if l == allowed {
+			return func() {}, nil
+		}
+		return nil, &KeyedQuotaExceededError[K]{Key: key}
+	}
+	h := m.hash(key)
+	s := m.shard(h)
+	s.Lock()
+	e := m.entryForUse(s, h, key)
+	if e == nil {
+		s.Unlock()
+		if err := m.allowOnFailure(&KeyedRuleNotFoundError[K]{Key: key}); err != nil {
+			return nil, err
+		}
+		return func() {}, nil
+	}
+	now := m.clock.Now()
+	s.touch(e, now)
+	r := e.rule
+	r.Refill(now)
+	if e.disabled {
+		e.allowed.Add(1)
+		remaining := r.Remaining()
+		s.Unlock()
+		m.publish(key, true, remaining, now)
+		return func() {}, nil
+	}
+	if !r.UseTokens(1) {
+		e.denied.Add(1)
+		remaining := r.Remaining()
+		m.Lock()
+		retryAfter, binding, _ := r.RetryAfter(1, m.scheduleFor(e, now))
+		m.Unlock()
+		s.Unlock()
+		m.publish(key, false, remaining, now)
+		if err := m.deny(key, &KeyedQuotaExceededError[K]{Key: key, Rule: binding, RetryAfter: retryAfter}); err != nil {
+			return nil, err
+		}
+		return func() {}, nil
+	}
+	e.allowed.Add(1)
+	remaining := r.Remaining()
+	s.Unlock()
+	m.publish(key, true, remaining, now)
+
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			s.Lock()
+			r.Refill(m.clock.Now())
+			r.ReturnTokens(1)
+			s.Unlock()
+		})
+	}, nil
+}
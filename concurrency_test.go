@@ -0,0 +1,107 @@
+package main
+
+import (
+	"errors"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestConcurrencyRuleAcquire(t *testing.T) {
+	m := NewManager()
+	m.AddRule("db", NewConcurrencyRule(2))
+
+	release1, err := m.Acquire("db")
+	if err != nil {
+		t.Fatalf("Did not expect an error acquiring a slot, %v", err)
+	}
+	release2, err := m.Acquire("db")
+	if err != nil {
+		t.Fatalf("Did not expect an error acquiring a second slot, %v", err)
+	}
+	if _, err := m.Acquire("db"); !errors.Is(err, ErrQuotaExceeded) {
+		t.Fatalf("Expected %v with every slot held but got %v", ErrQuotaExceeded, err)
+	}
+
+	release1()
+	release1()
+	if remaining, _ := m.Remaining("db"); remaining != 1 {
+		t.Fatalf("Expected releasing twice to free a single slot but got %d free", remaining)
+	}
+	release3, err := m.Acquire("db")
+	if err != nil {
+		t.Fatalf("Did not expect an error acquiring a released slot, %v", err)
+	}
+	release2()
+	release3()
+	if remaining, _ := m.Remaining("db"); remaining != 2 {
+		t.Fatalf("Expected every slot to be free but got %d", remaining)
+	}
+
+	if _, err := m.Acquire("cache"); !errors.Is(err, ErrRuleDoesNotExist) {
+		t.Fatalf("Expected %v for a missing rule but got %v", ErrRuleDoesNotExist, err)
+	}
+}
+
+func TestConcurrencyRuleHeldConcurrently(t *testing.T) {
+	m := NewManager()
+	rule := NewConcurrencyRule(4)
+	m.AddRule("db", rule)
+
+	var wg sync.WaitGroup
+	for range 4 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for range 100 {
+				if release, err := m.Acquire("db"); err == nil {
+					release()
+				}
+				if held := rule.Held(); held < 0 || held > 4 {
+					t.Errorf("Expected between 0 and 4 slots held but got %d", held)
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	if held := rule.Held(); held != 0 {
+		t.Fatalf("Expected every slot to be released but got %d held", held)
+	}
+}
+
+func TestConcurrencyRuleNoRefill(t *testing.T) {
+	clock := newFakeClock()
+	m := NewManager(WithClock(clock))
+	m.AddRule("db", NewConcurrencyRule(1))
+	go m.Run()
+	defer m.Stop()
+
+	m.UseToken("db")
+	clock.Advance(time.Minute)
+	var qerr *QuotaExceededError
+	if err := m.UseToken("db"); !errors.As(err, &qerr) || qerr.RetryAfter != 0 {
+		t.Fatalf("Expected a held slot not to be refilled but got %v", err)
+	}
+	m.ReturnToken("db")
+	m.ReturnToken("db")
+	if remaining, _ := m.Remaining("db"); remaining != 1 {
+		t.Fatalf("Did not expect returning a slot twice to over-release but got %d free", remaining)
+	}
+}
+
+func TestAcquireAllowlisted(t *testing.T) {
+	m := NewManager()
+	c := NewConcurrencyRule(1)
+	m.AddRule("db", c)
+	m.UseToken("db")
+
+	m.Allowlist("db")
+	release, err := m.Acquire("db")
+	if err != nil {
+		t.Fatalf("Did not expect an error acquiring an allowlisted key, %v", err)
+	}
+	release()
+	if c.Held() != 1 {
+		t.Fatalf("Did not expect releasing an allowlisted use to free a slot but got %d held", c.Held())
+	}
+}
@@ -0,0 +1,139 @@
+package main
+
+import (
+	"encoding"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+)
+
+// RuleConfig holds the parameters of a *Rule without its tokens. It encodes to JSON as LoadConfig
+// reads rules, with durations in nanoseconds.
+type RuleConfig struct {
+	QPS            float64       `json:"qps"`
+	Window         time.Duration `json:"window"`
+	Burst          int           `json:"burst,omitempty"`
+	Cost           int           `json:"cost,omitempty"`
+	Rollover       bool          `json:"rollover,omitempty"`
+	MaxCarry       int           `json:"max_carry,omitempty"`
+	Align          string        `json:"align,omitempty"` // name of the location windows are aligned in
+	SoftLimit      float64       `json:"soft_limit,omitempty"`
+	Warmup         time.Duration `json:"warmup,omitempty"`
+	RefillInterval time.Duration `json:"refill_interval,omitempty"`
+	Overdraft      int           `json:"overdraft,omitempty"`
+}
+
+// LoadConfig reads a JSON document from r mapping keys to rules and registers them, such as
+// {"user1": {"qps": 2, "window": "5s"}}. Rules are decoded as by Rule.UnmarshalJSON, so they may also set
+// a burst, cost and rollover. Keys which already have a rule are updated as by UpdateRule, so the
+// config can be reloaded without resetting tokens, and rules whose keys are missing from the config
+// are left in place. Every entry is validated first and if any is invalid, or a key appears more than
+// once after normalizing as by WithKeyFunc, nothing is registered and the returned error wraps
+// ErrInvalidConfig listing each bad entry.
+// Keys which are not strings are parsed by their UnmarshalText method, and are invalid without one.
+func (m *KeyedManager[K]) LoadConfig(r io.Reader) error {
+	dec := json.NewDecoder(r)
+	if tok, err := dec.Token(); err != nil || tok != json.Delim('{') {
+		return fmt.Errorf("%w: expected an object of rules", ErrInvalidConfig)
+	}
+
+	var keys []K
+	rules := make(map[K]*Rule)
+	var errs []error
+	for dec.More() {
+		tok, err := dec.Token()
+		if err != nil {
+			return fmt.Errorf("%w: %v", ErrInvalidConfig, err)
+		}
+		name := tok.(string)
+		var raw json.RawMessage
+		if err := dec.Decode(&raw); err != nil {
+			return fmt.Errorf("%w: key %q: %v", ErrInvalidConfig, name, err)
+		}
+		key, err := parseKey[K](name)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%w: key %q: %w", ErrInvalidConfig, name, err))
+			continue
+		}
+		key = m.normalize(key)
+		if _, dup := rules[key]; dup {
+			errs = append(errs, fmt.Errorf("%w: key %q is duplicated", ErrInvalidConfig, name))
+			continue
+		}
+		rule := &Rule{}
+		if err := json.Unmarshal(raw, rule); err != nil {
+			errs = append(errs, fmt.Errorf("%w: key %q: %w", ErrInvalidConfig, name, err))
+		}
+		keys = append(keys, key)
+		rules[key] = rule
+	}
+	if _, err := dec.Token(); err != nil {
+		return fmt.Errorf("%w: %v", ErrInvalidConfig, err)
+	}
+	if len(errs) > 0 {
+		return errors.Join(errs...)
+	}
+
+	for _, key := range keys {
+		if err := m.UpdateRule(key, rules[key]); errors.Is(err, ErrRuleDoesNotExist) {
+			m.AddRule(key, rules[key])
+		}
+	}
+	return nil
+}
+
+// ExportConfig returns the parameters of every registered *Rule by key, such as to diff the running
+// config against its source or to write it out for LoadConfig. Other limiters are not included and
+// tokens are left out, unlike Snapshot. Each shard is read under its lock, so every rule is exported as
+// it was between changes, although shards are visited one at a time.
+func (m *KeyedManager[K]) ExportConfig() map[K]RuleConfig {
+	all := make(map[K]RuleConfig)
+	for _, s := range m.shards {
+		s.RLock()
+		for _, e := range s.rules {
+			for ; e != nil; e = e.next {
+				if r, ok := e.rule.(*Rule); ok {
+					all[e.key] = r.config()
+				}
+			}
+		}
+		s.RUnlock()
+	}
+	return all
+}
+
+// config returns the parameters of the rule
+func (r *Rule) config() RuleConfig {
+	rj := r.json()
+	return RuleConfig{
+		QPS:            rj.QPS,
+		Window:         time.Duration(rj.Window),
+		Burst:          rj.Burst,
+		Cost:           rj.Cost,
+		Rollover:       rj.Rollover,
+		MaxCarry:       rj.MaxCarry,
+		Align:          rj.Align,
+		SoftLimit:      rj.SoftLimit,
+		Warmup:         time.Duration(rj.Warmup),
+		RefillInterval: time.Duration(rj.Refill),
+		Overdraft:      rj.Overdraft,
+	}
+}
+
+// parseKey converts the name of a config entry to a key
+func parseKey[K comparable](name string) (K, error) {
+	var key K
+	switch k := any(&key).(type) {
+	case *string:
+		*k = name
+	case encoding.TextUnmarshaler:
+		if err := k.UnmarshalText([]byte(name)); err != nil {
+			return key, err
+		}
+	default:
+		return key, fmt.Errorf("%T keys do not implement encoding.TextUnmarshaler", key)
+	}
+	return key, nil
+}
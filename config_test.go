@@ -0,0 +1,94 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestLoadConfig(t *testing.T) {
+	m := NewManager()
+	m.AddRule("user1", NewRule(1, 4*time.Second))
+	m.UseTokens("user1", 2)
+
+	config := `{
+		"user1": {"qps": 2, "window": "4s"},
+		"user2": {"qps": 1, "window": 3000000000, "cost": 2}
+	}`
+	if err := m.LoadConfig(strings.NewReader(config)); err != nil {
+		t.Fatalf("Did not expect an error loading a valid config, %v", err)
+	}
+	if remaining, _ := m.Remaining("user1"); remaining != 4 {
+		t.Fatalf("Expected user1 to keep half of its tokens after the reload but got %d", remaining)
+	}
+	l, _ := m.GetRule("user2")
+	if r := l.(*Rule); r.Window() != 3*time.Second || r.Cost() != 2 {
+		t.Fatalf("Expected user2 to be loaded from the config but got %s", r.String())
+	}
+}
+
+func TestExportConfig(t *testing.T) {
+	m := NewManager()
+	m.AddRule("user1", NewRule(1, 4*time.Second, WithCost(2)))
+	m.AddRule("user2", NewRule(2, 3*time.Second, WithRollover(1)))
+	m.AddRule("user3", NewSlidingWindowRule(1, time.Second))
+	m.UseTokens("user1", 3)
+
+	exported := m.ExportConfig()
+	expected := RuleConfig{QPS: 1, Window: 4 * time.Second, Burst: 4, Cost: 2}
+	if len(exported) != 2 || exported["user1"] != expected {
+		t.Fatalf("Expected %+v for user1 and only the *Rules to be exported but got %+v", expected, exported)
+	}
+
+	data, err := json.Marshal(exported)
+	if err != nil {
+		t.Fatalf("Did not expect an error encoding the config, %v", err)
+	}
+	loaded := NewManager()
+	if err := loaded.LoadConfig(strings.NewReader(string(data))); err != nil {
+		t.Fatalf("Did not expect an error loading an exported config, %v", err)
+	}
+	for key, config := range loaded.ExportConfig() {
+		if config != exported[key] {
+			t.Fatalf("Expected %s to round trip as %+v but got %+v", key, exported[key], config)
+		}
+	}
+	if remaining, _ := loaded.Remaining("user1"); remaining != 4 {
+		t.Fatalf("Expected a loaded rule to start with a full burst but got %d", remaining)
+	}
+}
+
+func TestLoadConfigInvalid(t *testing.T) {
+	m := NewManager()
+
+	config := `{
+		"user1": {"qps": 0, "window": "4s"},
+		"user2": {"qps": 1, "window": "3s"},
+		"user3": {"qps": 1, "window": "soon"},
+		"user2": {"qps": 2, "window": "3s"},
+		"user4": {"qps": 0.1, "window": "1s"}
+	}`
+	err := m.LoadConfig(strings.NewReader(config))
+	if !errors.Is(err, ErrInvalidConfig) {
+		t.Fatalf("Expected %v but got %v", ErrInvalidConfig, err)
+	}
+	if !errors.Is(err, ErrInvalidRule) {
+		t.Fatalf("Expected the error to include the invalid rule, %v", err)
+	}
+	for _, key := range []string{`"user1"`, `"user3"`, `"user2" is duplicated`, `"user4"`} {
+		if !strings.Contains(err.Error(), key) {
+			t.Fatalf("Expected the error to list %s but got %v", key, err)
+		}
+	}
+	if m.Len() != 0 {
+		t.Fatalf("Did not expect any rules to be loaded from an invalid config but got %d", m.Len())
+	}
+
+	for _, config := range []string{``, `[]`, `{"user1": {"qps": 1, "window": "1s"}`} {
+		if err := m.LoadConfig(strings.NewReader(config)); !errors.Is(err, ErrInvalidConfig) {
+			t.Fatalf("Expected %v for %q but got %v", ErrInvalidConfig, config, err)
+		}
+	}
+}
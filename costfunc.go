@@ -0,0 +1,82 @@
+package main
+
+// CostFunc maps the size of a request, such as its body in bytes, to the tokens it costs
+type CostFunc func(size int) int
+
+// CostPerUnit returns a CostFunc charging a token for every unit of size, rounding up, such as
+// CostPerUnit(1024) to charge per kilobyte. A non-positive unit charges a token per size.
+func CostPerUnit(unit int) CostFunc {
+	if unit <= 0 {
+		unit = 1
+	}
+	return func(size int) int {
+		if size <= 0 {
+			return 0
+		}
+		return (size-1)/unit + 1
+	}
+}
+
+// WithCostFunc attaches a cost model to a rule, which UseTokenFunc uses to charge each request by its
+// size. A nil f charges the size itself.
+func WithCostFunc(f CostFunc) RuleOption {
+	return func(r *Rule) {
+		r.costFunc = f
+	}
+}
+
+// CostOf returns the tokens a request of size costs on the rule
+func (r *Rule) CostOf(size int) int {
+	if r.costFunc == nil {
+		return size
+	}
+	return r.costFunc(size)
+}
+
+// UseTokenFunc tries to use the tokens a request of size costs for a given key, as computed by the
+// CostFunc of its rule, such as to meter an upload API by bytes. Rules without a CostFunc, and limiters
+// other than *Rule, charge the size itself. A request costing more than the most tokens the rule can
+// hold, plus any overdraft, could never be allowed, so it returns ErrCostExceedsMax rather than an
+// exceeded quota. A cost of zero is counted without charging, as by Observe, and a negative cost
+// returns ErrInvalidTokenCount.
+func (m *KeyedManager[K]) UseTokenFunc(key K, size int) error {
+	key = m.normalize(key)
+	if err := m.admitting(); err != nil {
+		return err
+	}
+	if l, ok := m.listed(key); ok {
+		if l == allowed {
+			return nil
+		}
+		return &KeyedQuotaExceededError[K]{Key: key}
+	}
+	h := m.hash(key)
+	s := m.shard(h)
+	s.RLock()
+	l, exists := s.rule(h, key)
+	cost, most := size, 0
+	if exists {
+		if r, ok := l.(interface{ CostOf(int) int }); ok {
+			cost = r.CostOf(size)
+		}
+		most = usable(l)
+	}
+	s.RUnlock()
+	if !exists {
+		m.Lock()
+		tmpl := m.defaultRule
+		m.Unlock()
+		if tmpl == nil {
+			return m.allowOnFailure(&KeyedRuleNotFoundError[K]{Key: key})
+		}
+		cost, most = tmpl.CostOf(size), usable(tmpl)
+	}
+
+	switch {
+	case cost < 0:
+		return ErrInvalidTokenCount
+	case cost > most:
+		return ErrCostExceedsMax
+	}
+	return m.UseTokenCost(key, cost)
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestCostPerUnit(t *testing.T) {
+	kb := CostPerUnit(1024)
+	for _, tc := range []struct{ size, cost int }{{0, 0}, {1, 1}, {1024, 1}, {1025, 2}, {-5, 0}} {
+		if cost := kb(tc.size); cost != tc.cost {
+			t.Fatalf("Expected %d bytes to cost %d tokens but got %d", tc.size, tc.cost, cost)
+		}
+	}
+	if cost := CostPerUnit(0)(3); cost != 3 {
+		t.Fatalf("Expected a non-positive unit to charge the size but got %d", cost)
+	}
+}
+
+func TestUseTokenFunc(t *testing.T) {
+	m := NewManager()
+	m.AddRule("uploads", NewRule(1, 10*time.Second, WithCostFunc(CostPerUnit(1024))))
+
+	if err := m.UseTokenFunc("uploads", 4000); err != nil {
+		t.Fatalf("Did not expect an error using tokens for 4000 bytes, %v", err)
+	}
+	if remaining, _ := m.Remaining("uploads"); remaining != 6 {
+		t.Fatalf("Expected 4000 bytes to cost 4 tokens but got %d remaining", remaining)
+	}
+	if err := m.UseTokenFunc("uploads", 7000); !errors.Is(err, ErrQuotaExceeded) {
+		t.Fatalf("Expected %v for a cost above the remaining tokens but got %v", ErrQuotaExceeded, err)
+	}
+	if err := m.UseTokenFunc("uploads", 20000); err != ErrCostExceedsMax {
+		t.Fatalf("Expected %v for a cost above the most the rule can hold but got %v", ErrCostExceedsMax, err)
+	}
+	if err := m.UseTokenFunc("uploads", 0); err != nil {
+		t.Fatalf("Did not expect an error for an empty request, %v", err)
+	}
+	if stats, _ := m.Stats("uploads"); stats.Allowed != 2 || stats.Denied != 1 || stats.Current != 6 {
+		t.Fatalf("Expected only the exceeded quota to be denied but got %+v", stats)
+	}
+
+	m.AddRule("plain", NewRule(1, 5*time.Second))
+	if err := m.UseTokenFunc("plain", 3); err != nil {
+		t.Fatalf("Did not expect an error using tokens without a cost func, %v", err)
+	}
+	if remaining, _ := m.Remaining("plain"); remaining != 2 {
+		t.Fatalf("Expected a rule without a cost func to charge the size but got %d remaining", remaining)
+	}
+	if err := m.UseTokenFunc("plain", -1); err != ErrInvalidTokenCount {
+		t.Fatalf("Expected %v for a negative size but got %v", ErrInvalidTokenCount, err)
+	}
+	if err := m.UseTokenFunc("missing", 1); !errors.Is(err, ErrRuleDoesNotExist) {
+		t.Fatalf("Expected %v for a missing rule but got %v", ErrRuleDoesNotExist, err)
+	}
+
+	m.SetDefaultRule(NewRule(1, 2*time.Second, WithCostFunc(CostPerUnit(10))))
+	if err := m.UseTokenFunc("new", 30); err != ErrCostExceedsMax {
+		t.Fatalf("Expected the default rule's cost func to apply but got %v", err)
+	}
+	if err := m.UseTokenFunc("new", 11); err != nil {
+		t.Fatalf("Did not expect an error using the default rule, %v", err)
+	}
+	if remaining, _ := m.Remaining("new"); remaining != 0 {
+		t.Fatalf("Expected the default rule to charge 2 tokens but got %d remaining", remaining)
+	}
+}
+
+func TestUseTokenFuncListed(t *testing.T) {
+	m := NewManager()
+	m.AddRule("user1", NewRule(1, 1*time.Second))
+	m.Allowlist("user1", "admin")
+
+	for _, key := range []string{"user1", "admin"} {
+		if err := m.UseTokenFunc(key, 100); err != nil {
+			t.Fatalf("Did not expect an error for allowlisted %s, %v", key, err)
+		}
+	}
+	m.Denylist("user1")
+	if err := m.UseTokenFunc("user1", 1); !errors.Is(err, ErrQuotaExceeded) {
+		t.Fatalf("Expected %v for a denylisted key but got %v", ErrQuotaExceeded, err)
+	}
+}
@@ -0,0 +1,50 @@
+package main
+
+import (
+	"time"
+)
+
+// RuleInfo describes the rule for a key in full, including the fields derived from its parameters
+type RuleInfo struct {
+	RuleConfig           // parameters, zero for limiters other than *Rule
+	MaxQueries int       // tokens the window holds at the rule's qps, before any burst
+	AddTokens  float64   // tokens added per refill, fractional when the refill rate is below 1
+	Tokens     int       // count, negative while reservations have borrowed tokens
+	Max        int       // most tokens the rule can hold
+	Enabled    bool      // false while the key is disabled by Disable
+	LastAccess time.Time // time the rule was last added or used
+	Stats      RuleStats
+}
+
+// Describe returns everything known about the rule for a specified key, such as for an admin UI. It is
+// read under the shard's lock after bringing the rule up to date, so all of its fields are consistent.
+// Limiters other than *Rule only describe their tokens, stats and state. Uses only update LastAccess
+// on managers which evict idle or least recently used rules, since other managers skip recording it
+// to use tokens under a read lock.
+func (m *KeyedManager[K]) Describe(key K) (RuleInfo, error) {
+	key = m.normalize(key)
+	h := m.hash(key)
+	s := m.shard(h)
+	s.Lock()
+	e := s.entry(h, key)
+	if e == nil {
+		s.Unlock()
+		return RuleInfo{}, &KeyedRuleNotFoundError[K]{Key: key}
+	}
+	e.rule.Refill(m.clock.Now())
+	info := RuleInfo{
+		Tokens:     e.rule.Remaining(),
+		Max:        e.rule.Max(),
+		Enabled:    !e.disabled,
+		LastAccess: e.lastAccess,
+		Stats:      e.stats(),
+	}
+	if r, ok := e.rule.(*Rule); ok {
+		info.RuleConfig = r.config()
+		info.MaxQueries = r.maxQueries
+		info.AddTokens = r.addTokens
+		info.Tokens = r.tokens()
+	}
+	s.Unlock()
+	return info, nil
+}
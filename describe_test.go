@@ -0,0 +1,42 @@
+package main
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestDescribe(t *testing.T) {
+	clock := newFakeClock()
+	m := NewManager(WithClock(clock), WithUpdateRate(500*time.Millisecond), WithIdleTTL(time.Hour, time.Hour))
+	m.AddRule("user1", NewRule(2, 5*time.Second, WithCost(2)))
+	clock.Advance(time.Second)
+	m.UseToken("user1")
+	m.Disable("user1")
+
+	info, err := m.Describe("user1")
+	if err != nil {
+		t.Fatalf("Did not expect an error describing a valid user, %v", err)
+	}
+	expected := RuleInfo{
+		RuleConfig: RuleConfig{QPS: 2, Window: 5 * time.Second, Burst: 10, Cost: 2},
+		MaxQueries: 10,
+		AddTokens:  1,
+		Tokens:     8,
+		Max:        10,
+		Enabled:    false,
+		LastAccess: clock.Now(),
+		Stats:      RuleStats{Allowed: 1, Current: 8, Max: 10},
+	}
+	if info != expected {
+		t.Fatalf("Expected %+v but got %+v", expected, info)
+	}
+
+	m.AddRule("user2", NewSlidingWindowRule(1, 3*time.Second))
+	if info, _ := m.Describe("user2"); info.QPS != 0 || info.Max != 3 || info.Tokens != 3 || !info.Enabled {
+		t.Fatalf("Expected only the tokens and state of a sliding window rule but got %+v", info)
+	}
+	if _, err := m.Describe("user3"); !errors.Is(err, ErrRuleDoesNotExist) {
+		t.Fatalf("Expected %v for a missing rule but got %v", ErrRuleDoesNotExist, err)
+	}
+}
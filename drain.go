@@ -0,0 +1,30 @@
+package main
+
+// Drain stops the manager admitting new token uses, such as while a server is shutting down behind a
+// load balancer. UseToken, UseTokens, UseTokensMulti, WaitToken and Reserve return ErrDraining until
+// Resume is called, while uses already in flight and reservations already held complete as normal.
+// Methods which use no tokens, such as Remaining and Stats, keep working, and rules keep refilling.
+func (m *KeyedManager[K]) Drain() {
+	m.draining.Store(true)
+}
+
+// Resume admits token uses again after Drain
+func (m *KeyedManager[K]) Resume() {
+	m.draining.Store(false)
+}
+
+// Draining returns whether the manager is draining
+func (m *KeyedManager[K]) Draining() bool {
+	return m.draining.Load()
+}
+
+// admitting returns the error token uses must fail with while the manager is closed or draining
+func (m *KeyedManager[K]) admitting() error {
+	switch {
+	case m.closed.Load():
+		return ErrClosed
+	case m.draining.Load():
+		return ErrDraining
+	}
+	return nil
+}
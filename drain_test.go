@@ -0,0 +1,46 @@
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestDrain(t *testing.T) {
+	m := NewManager()
+
+	user := "user1"
+	m.AddRule(user, NewRule(1, 3*time.Second))
+	res, _ := m.Reserve(user)
+
+	m.Drain()
+	if !m.Draining() {
+		t.Fatalf("Expected the manager to be draining")
+	}
+	if err := m.UseToken(user); err != ErrDraining {
+		t.Fatalf("Expected %v while draining but got %v", ErrDraining, err)
+	}
+	if err := m.WaitToken(context.Background(), user); err != ErrDraining {
+		t.Fatalf("Expected %v waiting while draining but got %v", ErrDraining, err)
+	}
+	if err := m.UseTokensMulti([]string{user}); err != ErrDraining {
+		t.Fatalf("Expected %v for several keys while draining but got %v", ErrDraining, err)
+	}
+	if _, err := m.Reserve(user); err != ErrDraining {
+		t.Fatalf("Expected %v reserving while draining but got %v", ErrDraining, err)
+	}
+	if _, err := m.AllowAt(user, time.Now()); err != ErrDraining {
+		t.Fatalf("Expected %v replaying a use while draining but got %v", ErrDraining, err)
+	}
+	if remaining, err := m.Remaining(user); err != nil || remaining != 2 {
+		t.Fatalf("Expected reads to keep working while draining but got %d, %v", remaining, err)
+	}
+	if !res.OK() {
+		t.Fatalf("Expected a reservation held before draining to stay valid")
+	}
+
+	m.Resume()
+	if err := m.UseToken(user); err != nil {
+		t.Fatalf("Did not expect an error once resumed, %v", err)
+	}
+}
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"time"
+)
+
+// KeyedQuotaExceededError is returned when the rule for Key has exceeded its quota. It wraps
+// ErrQuotaExceeded so errors.Is(err, ErrQuotaExceeded) continues to hold.
+type KeyedQuotaExceededError[K comparable] struct {
+	// Key is the key whose rule exceeded its quota. For a rule added by AddChildRule it may be the key
+	// of an ancestor which blocked the use.
+	Key K
+
+	// Rule is the rule whose quota was exceeded. For a MultiRule it is the sub-rule that was the
+	// binding constraint.
+	Rule Limiter
+
+	// RetryAfter is how long until the requested tokens are expected to be available. It is zero when
+	// the rule will never refill enough tokens, such as when the manager is not running.
+	RetryAfter time.Duration
+
+	// Global is set when the use was denied by the manager's global limit, in which case Rule is that
+	// limit, rather than by a rule of Key
+	Global bool
+}
+
+// QuotaExceededError is the KeyedQuotaExceededError returned by a Manager
+type QuotaExceededError = KeyedQuotaExceededError[string]
+
+func (e *KeyedQuotaExceededError[K]) Error() string {
+	if e.Global {
+		return fmt.Sprintf("%v for the global limit using key %s, retry after %v", ErrQuotaExceeded, formatKey(e.Key), e.RetryAfter)
+	}
+	return fmt.Sprintf("%v for key %s, retry after %v", ErrQuotaExceeded, formatKey(e.Key), e.RetryAfter)
+}
+
+// KeyedRuleNotFoundError is returned when there is no rule for Key. It wraps ErrRuleDoesNotExist so
+// errors.Is(err, ErrRuleDoesNotExist) continues to hold.
+type KeyedRuleNotFoundError[K comparable] struct {
+	// Key is the key without a rule. For a rule added by AddChildRule it is the key used, even if it is
+	// an ancestor's rule which is missing.
+	Key K
+}
+
+// RuleNotFoundError is the KeyedRuleNotFoundError returned by a Manager
+type RuleNotFoundError = KeyedRuleNotFoundError[string]
+
+func (e *KeyedRuleNotFoundError[K]) Error() string {
+	return fmt.Sprintf("%v for key %s", ErrRuleDoesNotExist, formatKey(e.Key))
+}
+
+// Unwrap returns ErrRuleDoesNotExist
+func (e *KeyedRuleNotFoundError[K]) Unwrap() error {
+	return ErrRuleDoesNotExist
+}
+
+// formatKey formats a key for messages, quoting string keys
+func formatKey[K comparable](key K) string {
+	if s, ok := any(key).(string); ok {
+		return strconv.Quote(s)
+	}
+	return fmt.Sprintf("%+v", key)
+}
+
+// Unwrap returns ErrQuotaExceeded
+func (e *KeyedQuotaExceededError[K]) Unwrap() error {
+	return ErrQuotaExceeded
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestQuotaExceededError(t *testing.T) {
+	clock := newFakeClock()
+	m := NewManagerLazy(WithClock(clock))
+
+	user := "user1"
+	m.AddRule(user, NewRule(2, 5*time.Second))
+	m.UseTokens(user, 10)
+
+	err := m.UseTokens(user, 3)
+	if !errors.Is(err, ErrQuotaExceeded) {
+		t.Fatalf("Expected error to wrap %v but got %v", ErrQuotaExceeded, err)
+	}
+	var qe *QuotaExceededError
+	if !errors.As(err, &qe) {
+		t.Fatalf("Expected a *QuotaExceededError but got %T", err)
+	}
+	if qe.Key != user {
+		t.Fatalf("Expected key %s but got %s", user, qe.Key)
+	}
+	if qe.RetryAfter != 1500*time.Millisecond {
+		t.Fatalf("Expected 3 tokens at 2 qps to be available in 1.5s but got %v", qe.RetryAfter)
+	}
+}
+
+func TestQuotaExceededErrorRetryAfterTicker(t *testing.T) {
+	clock := newFakeClock()
+	m := NewManager(WithClock(clock))
+
+	user := "user1"
+	m.AddRule(user, NewRule(1, 5*time.Second))
+	m.UseTokens(user, 5)
+
+	var qe *QuotaExceededError
+	if err := m.UseTokens(user, 2); !errors.As(err, &qe) || qe.RetryAfter != 0 {
+		t.Fatalf("Expected no retry after while the manager is not running but got %v", err)
+	}
+
+	m.Run()
+	defer m.Stop()
+	if err := m.UseTokens(user, 2); !errors.As(err, &qe) || qe.RetryAfter != 2*UpdateRate {
+		t.Fatalf("Expected 2 tokens to be available after 2 refills but got %v", err)
+	}
+}
+
+func TestRuleNotFoundError(t *testing.T) {
+	m := NewManager()
+	m.AddRule("org", NewRule(1, 1*time.Second))
+
+	for _, err := range []error{
+		m.UseToken("user1"),
+		m.RemoveRule("user1"),
+		m.AddChildRule("user1", "user2", NewRule(1, 1*time.Second)),
+	} {
+		var nf *RuleNotFoundError
+		if !errors.As(err, &nf) || nf.Key != "user1" {
+			t.Fatalf("Expected a *RuleNotFoundError for user1 but got %v", err)
+		}
+		if !errors.Is(err, ErrRuleDoesNotExist) {
+			t.Fatalf("Expected error to wrap %v but got %v", ErrRuleDoesNotExist, err)
+		}
+	}
+	if _, err := m.GetRule("user1"); err.Error() != `rule does not exist for key "user1"` {
+		t.Fatalf("Expected the error to name the key but got %q", err.Error())
+	}
+}
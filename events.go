@@ -0,0 +1,87 @@
+package main
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// KeyedEvent describes a single decision on whether to allow a token use for a key
+type KeyedEvent[K comparable] struct {
+	Key       K
+	Allowed   bool
+	Remaining int // tokens left after the decision, or -1 if unknown such as with a backend
+	Timestamp time.Time
+}
+
+// Event is the KeyedEvent published by a Manager
+type Event = KeyedEvent[string]
+
+// eventStream publishes events to a buffered channel without ever blocking the publisher
+type eventStream[K comparable] struct {
+	sync.RWMutex // guards closing ch against concurrent sends
+	ch           chan KeyedEvent[K]
+	closed       bool
+	dropped      uint64
+}
+
+// WithEvents has a manager publish every token use decision to the channel returned by Events, which
+// buffers up to size events. Events are dropped rather than waiting for a slow or absent consumer so
+// that token uses are never stalled, and DroppedEvents counts them.
+func WithEvents(size int) Option {
+	return func(c *config) {
+		if size < 0 {
+			size = 0
+		}
+		c.publishEvents = true
+		c.eventBuffer = size
+	}
+}
+
+// Events returns the channel token use decisions are published to, which is closed by Stop. It is nil
+// unless the manager was created with WithEvents, and nothing is published once the manager has been
+// stopped even if it is run again.
+func (m *KeyedManager[K]) Events() <-chan KeyedEvent[K] {
+	if m.events == nil {
+		return nil
+	}
+	return m.events.ch
+}
+
+// DroppedEvents returns the number of events which could not be published because the Events channel
+// was full
+func (m *KeyedManager[K]) DroppedEvents() uint64 {
+	if m.events == nil {
+		return 0
+	}
+	return atomic.LoadUint64(&m.events.dropped)
+}
+
+// publish sends a decision to the Events channel if enabled. No shard may be locked.
+func (m *KeyedManager[K]) publish(key K, allowed bool, remaining int, now time.Time) {
+	if m.events == nil {
+		return
+	}
+	m.events.publish(KeyedEvent[K]{Key: key, Allowed: allowed, Remaining: remaining, Timestamp: now})
+}
+
+func (es *eventStream[K]) publish(ev KeyedEvent[K]) {
+	es.RLock()
+	if !es.closed {
+		select {
+		case es.ch <- ev:
+		default:
+			atomic.AddUint64(&es.dropped, 1)
+		}
+	}
+	es.RUnlock()
+}
+
+func (es *eventStream[K]) close() {
+	es.Lock()
+	if !es.closed {
+		es.closed = true
+		close(es.ch)
+	}
+	es.Unlock()
+}
@@ -0,0 +1,47 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestEvents(t *testing.T) {
+	clock := newFakeClock()
+	m := NewManager(WithClock(clock), WithEvents(2))
+
+	user := "user1"
+	m.AddRule(user, NewRule(1, 1*time.Second))
+	m.UseToken(user)
+	m.UseToken(user)
+	m.UseToken(user)
+
+	expected := []Event{
+		{Key: user, Allowed: true, Remaining: 0, Timestamp: clock.Now()},
+		{Key: user, Allowed: false, Remaining: 0, Timestamp: clock.Now()},
+	}
+	for _, want := range expected {
+		if ev := <-m.Events(); ev != want {
+			t.Fatalf("Expected event %+v but got %+v", want, ev)
+		}
+	}
+	if m.DroppedEvents() != 1 {
+		t.Fatalf("Expected 1 event to be dropped with a full buffer but got %d", m.DroppedEvents())
+	}
+
+	m.Stop()
+	if _, ok := <-m.Events(); ok {
+		t.Fatalf("Expected the events channel to be closed on stop")
+	}
+	m.UseToken(user)
+	m.Stop()
+}
+
+func TestEventsDisabled(t *testing.T) {
+	m := NewManager()
+	m.AddRule("user1", NewRule(1, 1*time.Second))
+	m.UseToken("user1")
+	if m.Events() != nil || m.DroppedEvents() != 0 {
+		t.Fatalf("Did not expect events without WithEvents")
+	}
+	m.Stop()
+}
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"expvar"
+	"sort"
+)
+
+// expvarTopKeys is the number of busiest keys published by PublishExpvar
+const expvarTopKeys = 10
+
+// expvarStats is the summary of a manager published by PublishExpvar
+type expvarStats struct {
+	Rules   int           `json:"rules"`
+	Allowed uint64        `json:"allowed"`
+	Denied  uint64        `json:"denied"`
+	Busiest []expvarUsage `json:"busiest"`
+}
+
+// expvarUsage is the usage of one of the busiest keys
+type expvarUsage struct {
+	Key string `json:"key"`
+	RuleStats
+}
+
+// PublishExpvar publishes a summary of the manager's rules under name with the expvar package, so it
+// is served at /debug/vars without any other dependency. The summary holds the number of rules, their
+// total allowed and denied counts and the stats of the busiest keys by token uses, keeping its size
+// bounded however many rules there are. It is read each time it is served in one pass over the rules,
+// one shard at a time under its read lock as by TopDenied, holding only the busiest keys and without
+// using any tokens. Like expvar.Publish, it panics if name is already published.
+func (m *KeyedManager[K]) PublishExpvar(name string) {
+	expvar.Publish(name, expvar.Func(func() any {
+		return m.expvarStats()
+	}))
+}
+
+// expvarStats summarizes the manager's rules for PublishExpvar
+func (m *KeyedManager[K]) expvarStats() expvarStats {
+	var summary expvarStats
+	top := make(countHeap[K], 0, expvarTopKeys)
+	for _, s := range m.shards {
+		s.RLock()
+		for _, e := range s.rules {
+			for ; e != nil; e = e.next {
+				allowed, denied := e.allowed.Load(), e.denied.Load()
+				summary.Rules++
+				summary.Allowed += allowed
+				summary.Denied += denied
+				top.offer(KeyedCount[K]{Key: e.key, Count: allowed + denied})
+			}
+		}
+		s.RUnlock()
+	}
+	sort.Slice(top, func(i, j int) bool {
+		if top[i].Count != top[j].Count {
+			return top[i].Count > top[j].Count
+		}
+		return keyString(top[i].Key) < keyString(top[j].Key)
+	})
+	summary.Busiest = make([]expvarUsage, 0, len(top))
+	for _, c := range top {
+		// the rule may have been removed since the scan
+		if stats, err := m.Stats(c.Key); err == nil {
+			summary.Busiest = append(summary.Busiest, expvarUsage{Key: keyString(c.Key), RuleStats: stats})
+		}
+	}
+	return summary
+}
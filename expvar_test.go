@@ -0,0 +1,42 @@
+package main
+
+import (
+	"encoding/json"
+	"expvar"
+	"fmt"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestPublishExpvar(t *testing.T) {
+	m := NewManager()
+
+	for i := 0; i < expvarTopKeys+5; i++ {
+		key := "user" + strconv.Itoa(i)
+		m.AddRule(key, NewRule(1, 3*time.Second))
+		for j := 0; j < i%5; j++ {
+			m.UseToken(key)
+		}
+	}
+	// names stay published for the life of the process, so each run needs its own
+	name := fmt.Sprintf("test_quota_%p", m)
+	m.PublishExpvar(name)
+
+	var summary expvarStats
+	if err := json.Unmarshal([]byte(expvar.Get(name).String()), &summary); err != nil {
+		t.Fatalf("Did not expect an error decoding the published summary, %v", err)
+	}
+	if summary.Rules != 15 || summary.Allowed != 27 || summary.Denied != 3 {
+		t.Fatalf("Expected 15 rules with 27 allowed and 3 denied but got %+v", summary)
+	}
+	if len(summary.Busiest) != expvarTopKeys {
+		t.Fatalf("Expected the busiest %d keys but got %d", expvarTopKeys, len(summary.Busiest))
+	}
+	if busiest := summary.Busiest[0]; busiest.Key != "user14" || busiest.Allowed != 3 || busiest.Denied != 1 {
+		t.Fatalf("Expected user14 to be the busiest key but got %+v", busiest)
+	}
+	if remaining, _ := m.Remaining("user0"); remaining != 3 {
+		t.Fatalf("Did not expect publishing to use tokens but got %d remaining", remaining)
+	}
+}
@@ -0,0 +1,34 @@
+package main
+
+import (
+	"errors"
+)
+
+// WithFailOpen sets whether a manager allows token uses it cannot decide rather than returning an
+// error, for services which would rather let traffic through than fail every request when the quota
+// layer has a problem. When enabled, UseToken, UseTokens and WaitToken succeed for keys without a
+// rule when no default rule is set and whenever a backend cannot be reached, whatever the backend's
+// FailurePolicy. Exceeded quotas are still denied. Managers fail closed by default.
+func WithFailOpen(enabled bool) Option {
+	return func(c *config) {
+		c.failOpen = enabled
+	}
+}
+
+// FailOpenAllows returns the number of token uses allowed because they could not be decided, either
+// by WithFailOpen or by a backend's FailOpen policy
+func (m *KeyedManager[K]) FailOpenAllows() uint64 {
+	return m.failOpenAllows.Load()
+}
+
+// allowOnFailure returns nil in place of an error from using tokens which fails open, counting the use
+func (m *KeyedManager[K]) allowOnFailure(err error) error {
+	if err == nil || !m.failOpen {
+		return err
+	}
+	if errors.Is(err, ErrRuleDoesNotExist) || errors.Is(err, ErrBackendUnavailable) {
+		m.failOpenAllows.Add(1)
+		return nil
+	}
+	return err
+}
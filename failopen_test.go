@@ -0,0 +1,49 @@
+package main
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestFailOpen(t *testing.T) {
+	b := &sharedBackend{tokens: make(map[string]int), err: errors.New("connection refused")}
+	m := NewManager(WithBackend(b, FailClosed), WithFailOpen(true))
+
+	m.AddRule("user1", NewRule(1, 1*time.Second))
+	if err := m.UseToken("user1"); err != nil {
+		t.Fatalf("Expected an unavailable backend to fail open but got %v", err)
+	}
+	if err := m.UseTokens("user2", 2); err != nil {
+		t.Fatalf("Expected a missing rule to fail open but got %v", err)
+	}
+	if n := m.FailOpenAllows(); n != 2 {
+		t.Fatalf("Expected 2 fail open allows but got %d", n)
+	}
+
+	b.err = nil
+	m.UseToken("user1")
+	if err := m.UseToken("user1"); !errors.Is(err, ErrQuotaExceeded) {
+		t.Fatalf("Expected %v to still be denied but got %v", ErrQuotaExceeded, err)
+	}
+	if err := m.UseTokens("user1", 0); err != ErrInvalidTokenCount {
+		t.Fatalf("Expected %v to still be returned but got %v", ErrInvalidTokenCount, err)
+	}
+	if n := m.FailOpenAllows(); n != 2 {
+		t.Fatalf("Did not expect decided uses to count as fail open allows but got %d", n)
+	}
+}
+
+func TestFailOpenDefault(t *testing.T) {
+	b := &sharedBackend{tokens: make(map[string]int), err: errors.New("connection refused")}
+	m := NewManager(WithBackend(b, FailOpen))
+
+	m.AddRule("user1", NewRule(1, 1*time.Second))
+	m.UseToken("user1")
+	if err := m.UseToken("user2"); !errors.Is(err, ErrRuleDoesNotExist) {
+		t.Fatalf("Expected managers to fail closed for missing rules by default but got %v", err)
+	}
+	if n := m.FailOpenAllows(); n != 1 {
+		t.Fatalf("Expected the backend's fail open policy to be counted but got %d", n)
+	}
+}
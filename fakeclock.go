@@ -0,0 +1,115 @@
+package main
+
+import (
+	"sync"
+	"time"
+)
+
+// FakeClock is a Clock whose time only moves when advanced, letting tests control exactly when a
+// manager's refills come due
+type FakeClock struct {
+	sync.Mutex
+	now     time.Time
+	waiters []*fakeTimer
+}
+
+// NewFakeClock returns a FakeClock stopped at start
+func NewFakeClock(start time.Time) *FakeClock {
+	return &FakeClock{now: start}
+}
+
+// Now returns the time the clock is stopped at
+func (c *FakeClock) Now() time.Time {
+	c.Lock()
+	now := c.now
+	c.Unlock()
+	return now
+}
+
+// NewTicker returns a Ticker which ticks every d as the clock is advanced
+func (c *FakeClock) NewTicker(d time.Duration) Ticker {
+	return fakeTicker{c.add(d, d)}
+}
+
+// NewTimer returns a Timer which fires once the clock is advanced by d
+func (c *FakeClock) NewTimer(d time.Duration) Timer {
+	return c.add(d, 0)
+}
+
+func (c *FakeClock) add(d, period time.Duration) *fakeTimer {
+	c.Lock()
+	t := &fakeTimer{c: make(chan time.Time, 1), when: c.now.Add(d), period: period, clock: c}
+	c.waiters = append(c.waiters, t)
+	c.Unlock()
+	return t
+}
+
+// Waiters returns the number of active tickers and timers
+func (c *FakeClock) Waiters() int {
+	c.Lock()
+	n := len(c.waiters)
+	c.Unlock()
+	return n
+}
+
+// Advance moves the clock forward by d, firing any tickers and timers that come due. Like the time
+// package, ticks are dropped when a receiver falls behind.
+func (c *FakeClock) Advance(d time.Duration) {
+	c.Lock()
+	c.now = c.now.Add(d)
+	waiters := c.waiters[:0]
+	for _, t := range c.waiters {
+		for !t.when.After(c.now) {
+			select {
+			case t.c <- t.when:
+			default:
+			}
+			if t.period == 0 {
+				break
+			}
+			t.when = t.when.Add(t.period)
+		}
+		if t.period != 0 || t.when.After(c.now) {
+			waiters = append(waiters, t)
+		}
+	}
+	c.waiters = waiters
+	c.Unlock()
+}
+
+func (c *FakeClock) remove(t *fakeTimer) bool {
+	c.Lock()
+	defer c.Unlock()
+	for i, w := range c.waiters {
+		if w == t {
+			c.waiters = append(c.waiters[:i], c.waiters[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
+
+// fakeTimer is a Timer of a FakeClock
+type fakeTimer struct {
+	c      chan time.Time
+	when   time.Time
+	period time.Duration // zero for timers
+	clock  *FakeClock
+}
+
+func (t *fakeTimer) C() <-chan time.Time {
+	return t.c
+}
+
+func (t *fakeTimer) Stop() bool {
+	return t.clock.remove(t)
+}
+
+// fakeTicker is a Ticker of a FakeClock
+type fakeTicker struct {
+	*fakeTimer
+}
+
+func (t fakeTicker) Stop() {
+	t.fakeTimer.Stop()
+}
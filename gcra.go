@@ -0,0 +1,133 @@
+package main
+
+import (
+	"time"
+)
+
+// GCRA limits a key with the generic cell rate algorithm, which tracks only the theoretical arrival
+// time (TAT) at which the key's requests would have been evenly spread one period apart. A request is
+// allowed if the TAT it would advance to is within burst periods of now, so up to burst requests may
+// be made at once and requests then sustain one per period. Everything is computed from the time of
+// the request, so nothing is added on refills and the manager's refill sweep skips these rules, which
+// suits managers holding large numbers of keys.
+type GCRA struct {
+	period time.Duration // emission interval between evenly spaced requests
+	burst  int
+	tat    time.Time // theoretical arrival time of the next request
+	now    time.Time // time of the most recent refill
+}
+
+// NewGCRA creates a GCRA limiter allowing one request per period with bursts of up to burst requests.
+// A non-positive burst allows a single request at a time.
+func NewGCRA(period time.Duration, burst int) *GCRA {
+	if burst < 1 {
+		burst = 1
+	}
+	return &GCRA{period: period, burst: burst}
+}
+
+// Period returns the emission interval between evenly spaced requests
+func (g *GCRA) Period() time.Duration {
+	return g.period
+}
+
+// Burst returns the most requests which may be made at once
+func (g *GCRA) Burst() int {
+	return g.burst
+}
+
+// next returns the TAT after n more requests from the current time
+func (g *GCRA) next(n int) time.Time {
+	tat := g.tat
+	if tat.Before(g.now) {
+		tat = g.now
+	}
+	return tat.Add(time.Duration(n) * g.period)
+}
+
+// tolerance returns how far ahead of the current time the TAT may be
+func (g *GCRA) tolerance() time.Duration {
+	return time.Duration(g.burst) * g.period
+}
+
+// UseTokens allows n requests if the TAT they advance to is within the burst tolerance and returns
+// whether they were allowed
+func (g *GCRA) UseTokens(n int) bool {
+	tat := g.next(n)
+	if tat.Sub(g.now) > g.tolerance() {
+		return false
+	}
+	g.tat = tat
+	return true
+}
+
+// Borrow advances the TAT by n requests whether or not they are allowed
+func (g *GCRA) Borrow(n int) {
+	g.tat = g.next(n)
+}
+
+// ReturnTokens moves the TAT back by n requests, no earlier than the current time
+func (g *GCRA) ReturnTokens(n int) {
+	g.tat = g.next(0).Add(-time.Duration(n) * g.period)
+	if g.tat.Before(g.now) {
+		g.tat = g.now
+	}
+}
+
+// Remaining returns how many more requests are allowed at the current time
+func (g *GCRA) Remaining() int {
+	if g.period <= 0 {
+		return g.burst
+	}
+	remaining := int((g.tolerance() - g.next(0).Sub(g.now)) / g.period)
+	if remaining < 0 {
+		return 0
+	}
+	return remaining
+}
+
+// SetTokens sets the TAT so that n more requests are allowed, clamped to between zero and the burst
+func (g *GCRA) SetTokens(n int) {
+	if n > g.burst {
+		n = g.burst
+	}
+	if n < 0 {
+		n = 0
+	}
+	g.tat = g.now.Add(time.Duration(g.burst-n) * g.period)
+}
+
+// Max returns the burst
+func (g *GCRA) Max() int {
+	return g.burst
+}
+
+// Full returns true since refills add nothing to a limiter computed from the time of each request
+func (g *GCRA) Full() bool {
+	return true
+}
+
+// AddToken is a no-op since requests are allowed based on the time they are made
+func (g *GCRA) AddToken() {}
+
+// Refill records now as the time of the request being made
+func (g *GCRA) Refill(now time.Time) {
+	g.now = now
+}
+
+// SetSchedule records the time the limiter was added to a manager
+func (g *GCRA) SetSchedule(sch Schedule) {
+	g.now = sch.Now
+}
+
+// RetryAfter returns how long until n more requests would be allowed
+func (g *GCRA) RetryAfter(n int, sch Schedule) (time.Duration, Limiter, bool) {
+	if n > g.burst {
+		return 0, g, false
+	}
+	delay := g.next(n).Add(-g.tolerance()).Sub(g.now)
+	if delay < 0 {
+		delay = 0
+	}
+	return delay, g, true
+}
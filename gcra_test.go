@@ -0,0 +1,71 @@
+package main
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestGCRA(t *testing.T) {
+	clock := newFakeClock()
+	m := NewManager(WithClock(clock))
+
+	user := "user1"
+	m.AddRule(user, NewGCRA(100*time.Millisecond, 3))
+	if err := m.UseTokens(user, 3); err != nil {
+		t.Fatalf("Did not expect an error using the burst, %v", err)
+	}
+
+	testData := []struct {
+		advance    time.Duration
+		allowed    bool
+		retryAfter time.Duration
+	}{
+		{0, false, 100 * time.Millisecond},
+		{100 * time.Millisecond, true, 0},
+		{50 * time.Millisecond, false, 50 * time.Millisecond},
+		{50 * time.Millisecond, true, 0},
+		{0, false, 100 * time.Millisecond},
+	}
+	for i, tc := range testData {
+		clock.Advance(tc.advance)
+		err := m.UseToken(user)
+		var qe *QuotaExceededError
+		switch {
+		case tc.allowed && err != nil:
+			t.Fatalf("Did not expect an error on request %d, %v", i, err)
+		case !tc.allowed && (!errors.As(err, &qe) || qe.RetryAfter != tc.retryAfter):
+			t.Fatalf("Expected %v retrying after %v on request %d but got %v", ErrQuotaExceeded, tc.retryAfter, i, err)
+		}
+	}
+
+	// an idle limiter recovers its burst and no more
+	clock.Advance(time.Second)
+	if remaining, _ := m.Remaining(user); remaining != 3 {
+		t.Fatalf("Expected the full burst of 3 after idling but got %d", remaining)
+	}
+	if err := m.UseTokens(user, 4); !errors.Is(err, ErrQuotaExceeded) {
+		t.Fatalf("Expected more than the burst to be denied but got %v", err)
+	}
+}
+
+func TestGCRATokens(t *testing.T) {
+	g := NewGCRA(time.Second, 5)
+	g.Refill(time.Unix(0, 0))
+
+	g.SetTokens(2)
+	if g.Remaining() != 2 {
+		t.Fatalf("Expected 2 requests remaining but got %d", g.Remaining())
+	}
+	g.ReturnTokens(10)
+	if g.Remaining() != 5 {
+		t.Fatalf("Expected returns to be capped at the burst but got %d", g.Remaining())
+	}
+	g.Borrow(7)
+	if g.Remaining() != 0 {
+		t.Fatalf("Expected no requests remaining after borrowing but got %d", g.Remaining())
+	}
+	if delay, _, ok := g.RetryAfter(1, Schedule{}); !ok || delay != 3*time.Second {
+		t.Fatalf("Expected a request to be allowed once the debt is repaid in 3s but got %v", delay)
+	}
+}
@@ -0,0 +1,47 @@
+package main
+
+import "time"
+
+// WithGlobalLimit caps the tokens used across every key of a manager with r, such as to protect a
+// shared downstream however the traffic is spread across keys. UseToken, UseTokens, UseTokenCost,
+// UseTokenFunc and WaitToken use their tokens from both the key's rule, including any ancestors added
+// by AddChildRule, and the global limit, or from neither. A use denied by the global limit returns a
+// KeyedQuotaExceededError with Global set, even when the key's rule has tokens. The global limit
+// accrues tokens lazily from the time elapsed between uses, whether or not the manager is running.
+// Disabled keys, UseTokensMulti, reservations, AllowAt and backends do not use it. Token uses all
+// serialize on the global limit, so they no longer take the read locked fast path.
+func WithGlobalLimit(r *Rule) Option {
+	return func(c *config) {
+		c.global = r
+	}
+}
+
+// useGlobal uses n tokens from the global limit if there is one, returning the error to deny a use for
+// key with if there are too few
+func (m *KeyedManager[K]) useGlobal(key K, n int, now time.Time) *KeyedQuotaExceededError[K] {
+	if m.global == nil {
+		return nil
+	}
+	m.globalMu.Lock()
+	m.global.Refill(now)
+	if m.global.UseTokens(n) {
+		m.globalMu.Unlock()
+		return nil
+	}
+	retryAfter, _, _ := m.global.RetryAfter(n, Schedule{Now: now, Lazy: true, UpdateRate: m.updateRate})
+	m.globalMu.Unlock()
+	return &KeyedQuotaExceededError[K]{Key: key, Rule: m.global, RetryAfter: retryAfter, Global: true}
+}
+
+// GlobalRemaining returns the tokens available on the global limit set by WithGlobalLimit, or 0 if
+// there is none
+func (m *KeyedManager[K]) GlobalRemaining() int {
+	if m.global == nil {
+		return 0
+	}
+	m.globalMu.Lock()
+	m.global.Refill(m.clock.Now())
+	remaining := m.global.Remaining()
+	m.globalMu.Unlock()
+	return remaining
+}
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestGlobalLimit(t *testing.T) {
+	clock := newFakeClock()
+	m := NewManager(WithClock(clock), WithGlobalLimit(NewRule(1, 3*time.Second)))
+	m.AddRule("user1", NewRule(2, 1*time.Second))
+	m.AddRule("user2", NewRule(2, 1*time.Second))
+
+	for _, key := range []string{"user1", "user2", "user1"} {
+		if err := m.UseToken(key); err != nil {
+			t.Fatalf("Did not expect an error using a token for %s, %v", key, err)
+		}
+	}
+	err := m.UseToken("user2")
+	var qerr *QuotaExceededError
+	if !errors.As(err, &qerr) || !qerr.Global || qerr.Key != "user2" || qerr.RetryAfter != time.Second {
+		t.Fatalf("Expected the global limit to deny user2 for 1s but got %v", err)
+	}
+	if remaining, _ := m.Remaining("user2"); remaining != 1 {
+		t.Fatalf("Expected user2's token to be returned when denied but got %d remaining", remaining)
+	}
+	if stats, _ := m.Stats("user2"); stats.Allowed != 1 || stats.Denied != 1 {
+		t.Fatalf("Expected the denial by the global limit in user2's stats but got %+v", stats)
+	}
+
+	clock.Advance(time.Second)
+	if remaining := m.GlobalRemaining(); remaining != 1 {
+		t.Fatalf("Expected the global limit to accrue 1 token but got %d", remaining)
+	}
+	if err := m.UseToken("user2"); err != nil {
+		t.Fatalf("Did not expect an error once the global limit accrued a token, %v", err)
+	}
+}
+
+func TestGlobalLimitKeyDenied(t *testing.T) {
+	m := NewManager(WithGlobalLimit(NewRule(10, 1*time.Second)))
+	m.AddRule("user1", NewRule(1, 1*time.Second))
+	m.UseToken("user1")
+
+	err := m.UseToken("user1")
+	var qerr *QuotaExceededError
+	if !errors.As(err, &qerr) || qerr.Global {
+		t.Fatalf("Expected user1's rule to deny it but got %v", err)
+	}
+	if remaining := m.GlobalRemaining(); remaining != 9 {
+		t.Fatalf("Did not expect a use denied by a key's rule to touch the global limit but got %d", remaining)
+	}
+}
+
+func TestGlobalLimitChildRule(t *testing.T) {
+	m := NewManager(WithGlobalLimit(NewRule(1, 1*time.Second)))
+	m.AddRule("org", NewRule(5, 1*time.Second))
+	m.AddChildRule("org", "user1", NewRule(5, 1*time.Second))
+
+	m.UseToken("user1")
+	if err := m.UseToken("user1"); !errors.Is(err, ErrQuotaExceeded) {
+		t.Fatalf("Expected %v from the global limit but got %v", ErrQuotaExceeded, err)
+	}
+	for _, key := range []string{"org", "user1"} {
+		if remaining, _ := m.Remaining(key); remaining != 4 {
+			t.Fatalf("Expected the chain's tokens to be returned for %s but got %d", key, remaining)
+		}
+	}
+}
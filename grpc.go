@@ -0,0 +1,63 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"math"
+	"strconv"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+// KeyedInterceptor rate limits the unary RPCs of a gRPC server by the key KeyFunc extracts from each
+// call. Calls exceeding their quota fail with codes.ResourceExhausted and a retry-after trailer in
+// whole seconds, and every limited call carries an x-ratelimit-remaining trailer.
+type KeyedInterceptor[K comparable] struct {
+	Manager *KeyedManager[K]
+	KeyFunc func(context.Context, *grpc.UnaryServerInfo) K
+
+	// AllowNotFound lets calls whose key has no rule through. Otherwise they fail with
+	// codes.PermissionDenied.
+	AllowNotFound bool
+}
+
+// Interceptor is a KeyedInterceptor for a Manager keyed by strings
+type Interceptor = KeyedInterceptor[string]
+
+// KeyByMethod keys calls by their full method name, such as "/package.Service/Method"
+func KeyByMethod(ctx context.Context, info *grpc.UnaryServerInfo) string {
+	return info.FullMethod
+}
+
+// Unary returns a grpc.UnaryServerInterceptor which uses a token for each call before handling it
+func (ic *KeyedInterceptor[K]) Unary() grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+		key := ic.KeyFunc(ctx, info)
+		remaining, err := ic.Manager.UseTokenWithRemaining(key)
+
+		var qe *KeyedQuotaExceededError[K]
+		switch {
+		case err == nil:
+			if remaining >= 0 {
+				grpc.SetTrailer(ctx, metadata.Pairs("x-ratelimit-remaining", strconv.Itoa(remaining)))
+			}
+		case errors.As(err, &qe):
+			trailer := metadata.Pairs("x-ratelimit-remaining", "0")
+			if qe.RetryAfter > 0 {
+				trailer.Set("retry-after", strconv.Itoa(int(math.Ceil(qe.RetryAfter.Seconds()))))
+			}
+			grpc.SetTrailer(ctx, trailer)
+			return nil, status.Error(codes.ResourceExhausted, err.Error())
+		case errors.Is(err, ErrRuleDoesNotExist):
+			if !ic.AllowNotFound {
+				return nil, status.Error(codes.PermissionDenied, err.Error())
+			}
+		default:
+			return nil, status.Error(codes.Internal, err.Error())
+		}
+		return handler(ctx, req)
+	}
+}
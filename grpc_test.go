@@ -0,0 +1,79 @@
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+// trailerStream records the trailers an interceptor sets on a call
+type trailerStream struct {
+	trailer metadata.MD
+}
+
+func (s *trailerStream) Method() string                  { return "/test.Service/Method" }
+func (s *trailerStream) SetHeader(md metadata.MD) error  { return nil }
+func (s *trailerStream) SendHeader(md metadata.MD) error { return nil }
+func (s *trailerStream) SetTrailer(md metadata.MD) error {
+	s.trailer = metadata.Join(s.trailer, md)
+	return nil
+}
+
+func TestInterceptor(t *testing.T) {
+	clock := newFakeClock()
+	m := NewManager(WithClock(clock))
+	m.Run()
+	defer m.Stop()
+	m.AddRule("/test.Service/Method", NewRule(1, 2*time.Second))
+
+	ic := &Interceptor{Manager: m, KeyFunc: KeyByMethod}
+	info := &grpc.UnaryServerInfo{FullMethod: "/test.Service/Method"}
+	handler := func(ctx context.Context, req any) (any, error) { return "ok", nil }
+
+	for _, tc := range []struct {
+		code      codes.Code
+		remaining string
+	}{
+		{codes.OK, "1"},
+		{codes.OK, "0"},
+		{codes.ResourceExhausted, "0"},
+	} {
+		stream := &trailerStream{}
+		ctx := grpc.NewContextWithServerTransportStream(context.Background(), stream)
+		_, err := ic.Unary()(ctx, nil, info, handler)
+
+		if code := status.Code(err); code != tc.code {
+			t.Fatalf("Expected code %v but got %v", tc.code, code)
+		}
+		if remaining := stream.trailer.Get("x-ratelimit-remaining"); len(remaining) != 1 || remaining[0] != tc.remaining {
+			t.Fatalf("Expected %s remaining but got %v", tc.remaining, remaining)
+		}
+		if retry := stream.trailer.Get("retry-after"); tc.code == codes.ResourceExhausted && (len(retry) != 1 || retry[0] != "1") {
+			t.Fatalf("Expected a retry after of 1 second but got %v", retry)
+		}
+	}
+}
+
+func TestInterceptorNotFound(t *testing.T) {
+	m := NewManager()
+	info := &grpc.UnaryServerInfo{FullMethod: "/test.Service/Method"}
+	handler := func(ctx context.Context, req any) (any, error) { return "ok", nil }
+
+	for _, tc := range []struct {
+		allow bool
+		code  codes.Code
+	}{
+		{false, codes.PermissionDenied},
+		{true, codes.OK},
+	} {
+		ic := &Interceptor{Manager: m, KeyFunc: KeyByMethod, AllowNotFound: tc.allow}
+		if _, err := ic.Unary()(context.Background(), nil, info, handler); status.Code(err) != tc.code {
+			t.Fatalf("Expected code %v with AllowNotFound %t but got %v", tc.code, tc.allow, err)
+		}
+	}
+}
@@ -0,0 +1,116 @@
+package main
+
+import (
+	"errors"
+	"time"
+)
+
+// TB is the part of testing.TB a Harness reports failures to, so that the package does not import
+// testing outside of its tests
+type TB interface {
+	Helper()
+	Fatalf(format string, args ...any)
+}
+
+// Harness wraps a Manager with a FakeClock for tests of code which is rate limited. Rather than
+// running a refill goroutine and waiting on it, Advance moves the clock forward and performs every
+// refill and idle sweep which comes due along the way before returning, so assertions made afterwards
+// never race a tick. Run must not be called on the wrapped Manager.
+type Harness struct {
+	*Manager
+	Clock *FakeClock
+
+	tb         TB
+	nextRefill time.Time // zero when rules refill lazily
+	nextSweep  time.Time // zero without idle eviction
+}
+
+// NewHarness returns a Harness whose Manager is configured by opts and reads the time from a new
+// FakeClock, overriding any WithClock option
+func NewHarness(tb TB, opts ...Option) *Harness {
+	clock := NewFakeClock(time.Unix(0, 0))
+	h := &Harness{
+		Manager: NewManager(append(opts, WithClock(clock))...),
+		Clock:   clock,
+		tb:      tb,
+	}
+	now := clock.Now()
+	switch {
+	case h.lazy:
+	case h.queued:
+		h.nextRefill = now.Add(h.queueWait())
+	default:
+		h.nextRefill = now.Add(h.updateRate)
+	}
+	if h.idleTTL > 0 {
+		h.nextSweep = now.Add(h.sweepInterval)
+	}
+	h.scheduled()
+	return h
+}
+
+// Advance moves the clock forward by d, refilling and sweeping idle rules at each instant a running
+// Manager would have in that time
+func (h *Harness) Advance(d time.Duration) {
+	end := h.Clock.Now().Add(d)
+	for {
+		next := h.nextRefill
+		if next.IsZero() || (!h.nextSweep.IsZero() && h.nextSweep.Before(next)) {
+			next = h.nextSweep
+		}
+		if next.IsZero() || next.After(end) {
+			break
+		}
+		h.Clock.Advance(next.Sub(h.Clock.Now()))
+		if next.Equal(h.nextRefill) {
+			h.reclaim.expire(next)
+			if h.queued {
+				h.nextRefill = next.Add(h.refillQueued())
+			} else {
+				h.addTokens()
+				h.nextRefill = next.Add(h.updateRate)
+			}
+		}
+		if next.Equal(h.nextSweep) {
+			h.evictIdle()
+			h.nextSweep = next.Add(h.sweepInterval)
+		}
+		h.scheduled()
+	}
+	h.Clock.Advance(end.Sub(h.Clock.Now()))
+}
+
+// scheduled records the next refill on the Manager as Run would, so rules and retry hints see it
+func (h *Harness) scheduled() {
+	h.Lock()
+	h.Manager.nextRefill = h.nextRefill
+	h.Unlock()
+}
+
+// AssertRemaining fails the test unless key has exactly n tokens remaining
+func (h *Harness) AssertRemaining(key string, n int) {
+	h.tb.Helper()
+	remaining, err := h.Remaining(key)
+	if err != nil {
+		h.tb.Fatalf("Did not expect an error getting the remaining tokens of %s, %v", key, err)
+	}
+	if remaining != n {
+		h.tb.Fatalf("Expected %d tokens remaining for %s but got %d", n, key, remaining)
+	}
+}
+
+// AssertAllowed fails the test unless a token can be used for key, using it
+func (h *Harness) AssertAllowed(key string) {
+	h.tb.Helper()
+	if err := h.UseToken(key); err != nil {
+		h.tb.Fatalf("Did not expect an error using a token for %s, %v", key, err)
+	}
+}
+
+// AssertExceeded fails the test unless using a token for key exceeds its quota
+func (h *Harness) AssertExceeded(key string) {
+	h.tb.Helper()
+	if err := h.UseToken(key); !errors.Is(err, ErrQuotaExceeded) {
+		h.tb.Fatalf("Expected %v using a token for %s but got %v", ErrQuotaExceeded, key, err)
+	}
+}
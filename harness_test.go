@@ -0,0 +1,63 @@
+package main
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+// TestHarnessExample shows how code limited by a Manager can be tested without sleeping
+func TestHarnessExample(t *testing.T) {
+	h := NewHarness(t, WithUpdateRate(time.Second))
+	h.AddRule("user1", NewRule(1, 2*time.Second))
+
+	h.AssertAllowed("user1")
+	h.AssertAllowed("user1")
+	h.AssertExceeded("user1")
+
+	h.Advance(999 * time.Millisecond)
+	h.AssertRemaining("user1", 0)
+	h.Advance(time.Millisecond)
+	h.AssertRemaining("user1", 1)
+	h.Advance(5 * time.Second)
+	h.AssertRemaining("user1", 2)
+}
+
+func TestHarnessRetryAfter(t *testing.T) {
+	h := NewHarness(t, WithUpdateRate(time.Second))
+	h.AddRule("user1", NewRule(1, 1*time.Second))
+	h.Advance(1300 * time.Millisecond)
+
+	h.AssertAllowed("user1")
+	err := h.UseToken("user1")
+	var qerr *QuotaExceededError
+	if !errors.As(err, &qerr) || qerr.RetryAfter != 700*time.Millisecond {
+		t.Fatalf("Expected %v retrying after the next refill but got %v", ErrQuotaExceeded, err)
+	}
+}
+
+func TestHarnessRefillQueue(t *testing.T) {
+	h := NewHarness(t, WithRefillQueue())
+	h.AddRule("user1", NewRule(1, 2*time.Second))
+	h.UseTokens("user1", 2)
+
+	h.Advance(500 * time.Millisecond)
+	h.AddRule("user2", NewRule(1, 2*time.Second))
+	h.UseTokens("user2", 2)
+
+	h.Advance(500 * time.Millisecond)
+	h.AssertRemaining("user1", 1)
+	h.AssertRemaining("user2", 0)
+	h.Advance(500 * time.Millisecond)
+	h.AssertRemaining("user2", 1)
+}
+
+func TestHarnessIdle(t *testing.T) {
+	h := NewHarness(t, WithIdleTTL(time.Minute, 10*time.Second))
+	h.AddRule("user1", NewRule(1, 1*time.Second))
+
+	h.Advance(2 * time.Minute)
+	if _, err := h.GetRule("user1"); !errors.Is(err, ErrRuleDoesNotExist) {
+		t.Fatalf("Expected an idle rule to be evicted but got %v", err)
+	}
+}
@@ -0,0 +1,159 @@
+package main
+
+import (
+	"fmt"
+)
+
+// AddChildRule adds a quota rule for childKey which also uses tokens from the rule of parentKey, such
+// as a user limited within the overall quota of their organization. Parents may themselves be
+// children, and a token use only succeeds if every rule up the chain has capacity, using tokens from
+// all of them or none. ErrRuleDoesNotExist is returned if the parent has no rule and an error
+// wrapping ErrInvalidRule if the child is the parent or one of its ancestors. Removing a parent ends
+// the chain at its children, and adding the child again with AddRule removes its link while
+// UpdateRule keeps it. Disabled ancestors are skipped, and chains are always used in memory even when
+// the manager has a backend.
+func (m *KeyedManager[K]) AddChildRule(parentKey, childKey K, r Limiter) error {
+	parentKey, childKey = m.normalize(parentKey), m.normalize(childKey)
+	ancestors, exists := m.chain(parentKey)
+	if !exists {
+		return &KeyedRuleNotFoundError[K]{Key: parentKey}
+	}
+	for _, key := range ancestors {
+		if key == childKey {
+			return fmt.Errorf("%w: %q cannot be a child of its descendant %s", ErrInvalidRule, formatKey(childKey), formatKey(parentKey))
+		}
+	}
+
+	h := m.hash(childKey)
+	s := m.shard(h)
+	s.Lock()
+	e := m.add(s, h, childKey, r)
+	e.parent, e.hasParent = parentKey, true
+	s.Unlock()
+	return nil
+}
+
+// chain returns the keys from a key up through its ancestors and whether the key has a rule. Each
+// shard is only locked as its key is looked up, so the chain must be verified before it is used.
+func (m *KeyedManager[K]) chain(key K) ([]K, bool) {
+	var keys []K
+	seen := make(map[K]bool)
+	for !seen[key] {
+		h := m.hash(key)
+		s := m.shard(h)
+		s.Lock()
+		e := s.entry(h, key)
+		if e == nil {
+			s.Unlock()
+			return keys, len(keys) > 0
+		}
+		parent, hasParent := e.parent, e.hasParent
+		s.Unlock()
+
+		keys = append(keys, key)
+		seen[key] = true
+		if !hasParent {
+			break
+		}
+		key = parent
+	}
+	return keys, true
+}
+
+// lockChain locks the shards of every key in a chain and returns their entries if the chain is still
+// linked as it was looked up. The returned unlock must be called once the entries are no longer used.
+func (m *KeyedManager[K]) lockChain(keys []K) (entries []*entry[K], linked bool, unlock func()) {
+	hashes, unlock := m.lockKeys(keys)
+	entries = make([]*entry[K], len(keys))
+	for i, key := range keys {
+		e := m.shard(hashes[i]).entry(hashes[i], key)
+		if e == nil {
+			return entries[:i], false, unlock
+		}
+		entries[i] = e
+		if i < len(keys)-1 && (!e.hasParent || e.parent != keys[i+1]) {
+			return entries[:i+1], false, unlock
+		}
+	}
+	return entries, true, unlock
+}
+
+// useChain uses n tokens for a key from its rule and the rules of all its ancestors, returning the
+// tokens left on the key's own rule along with the tokens taken
+func (m *KeyedManager[K]) useChain(key K, n int) (remaining int, c charge[K], err error) {
+	var entries []*entry[K]
+	var keys []K
+	var unlock func()
+	for {
+		var exists, linked bool
+		keys, exists = m.chain(key)
+		if !exists {
+			return -1, c, &KeyedRuleNotFoundError[K]{Key: key}
+		}
+		entries, linked, unlock = m.lockChain(keys)
+		if linked {
+			break
+		}
+		unlock()
+		if len(entries) == 0 {
+			return -1, c, &KeyedRuleNotFoundError[K]{Key: key}
+		}
+	}
+
+	now := m.clock.Now()
+	blocked := -1
+	for i, e := range entries {
+		m.shard(m.hash(keys[i])).touch(e, now)
+		if e.disabled {
+			continue
+		}
+		e.rule.Refill(now)
+		if !e.rule.UseTokens(n) {
+			for _, used := range entries[:i] {
+				if !used.disabled {
+					used.rule.ReturnTokens(n)
+				}
+			}
+			blocked = i
+			break
+		}
+	}
+	var global *KeyedQuotaExceededError[K]
+	if blocked < 0 {
+		if global = m.useGlobal(key, n, now); global != nil {
+			for _, used := range entries {
+				if !used.disabled {
+					used.rule.ReturnTokens(n)
+				}
+			}
+		}
+	}
+	remaining = entries[0].rule.Remaining()
+	if blocked < 0 && global == nil {
+		for _, e := range entries {
+			e.allowed.Add(1)
+		}
+		unlock()
+		m.publish(key, true, remaining, now)
+		c = charge[K]{chain: make([]K, 0, len(keys)), n: n, global: m.global != nil}
+		for i, e := range entries {
+			if !e.disabled {
+				c.chain = append(c.chain, keys[i])
+			}
+		}
+		return remaining, c, nil
+	}
+
+	entries[0].denied.Add(1)
+	if global != nil {
+		unlock()
+		m.publish(key, false, remaining, now)
+		return remaining, c, m.deny(key, global)
+	}
+	m.Lock()
+	retryAfter, binding, _ := entries[blocked].rule.RetryAfter(n, m.scheduleFor(entries[blocked], now))
+	m.Unlock()
+	unlock()
+	m.publish(key, false, remaining, now)
+	return remaining, c, m.deny(key, &KeyedQuotaExceededError[K]{Key: keys[blocked], Rule: binding, RetryAfter: retryAfter})
+}
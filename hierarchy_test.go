@@ -0,0 +1,101 @@
+package main
+
+import (
+	"errors"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestChildRule(t *testing.T) {
+	m := NewManager()
+
+	m.AddRule("org", NewRule(1, 5*time.Second))
+	if err := m.AddChildRule("org", "user1", NewRule(1, 3*time.Second)); err != nil {
+		t.Fatalf("Did not expect an error adding a child rule, %v", err)
+	}
+	if err := m.AddChildRule("org", "user2", NewRule(1, 3*time.Second)); err != nil {
+		t.Fatalf("Did not expect an error adding a child rule, %v", err)
+	}
+
+	if err := m.UseTokens("user1", 3); err != nil {
+		t.Fatalf("Did not expect an error on valid user, %v", err)
+	}
+	var qerr *QuotaExceededError
+	if err := m.UseToken("user1"); !errors.As(err, &qerr) || qerr.Key != "user1" {
+		t.Fatalf("Expected user1 to block its own use but got %v", err)
+	}
+	if remaining, _ := m.Remaining("org"); remaining != 2 {
+		t.Fatalf("Expected the org to have 2 tokens left but got %d", remaining)
+	}
+
+	if err := m.UseTokens("user2", 3); !errors.As(err, &qerr) || qerr.Key != "org" {
+		t.Fatalf("Expected the org to block the use but got %v", err)
+	}
+	if remaining, _ := m.Remaining("user2"); remaining != 3 {
+		t.Fatalf("Expected no tokens to be used from user2 when blocked but got %d remaining", remaining)
+	}
+	if err := m.UseTokens("user2", 2); err != nil {
+		t.Fatalf("Did not expect an error on valid user, %v", err)
+	}
+	if stats, _ := m.Stats("org"); stats.Allowed != 2 || stats.Current != 0 {
+		t.Fatalf("Expected the org to count both children's uses but got %+v", stats)
+	}
+
+	m.RemoveRule("org")
+	if err := m.UseToken("user2"); err != nil {
+		t.Fatalf("Expected removing the parent to end the chain but got %v", err)
+	}
+}
+
+func TestChildRuleInvalid(t *testing.T) {
+	m := NewManager()
+
+	if err := m.AddChildRule("org", "user1", NewRule(1, 1*time.Second)); !errors.Is(err, ErrRuleDoesNotExist) {
+		t.Fatalf("Expected %v for a missing parent but got %v", ErrRuleDoesNotExist, err)
+	}
+
+	m.AddRule("org", NewRule(1, 1*time.Second))
+	m.AddChildRule("org", "team", NewRule(1, 1*time.Second))
+	m.AddChildRule("team", "user1", NewRule(1, 1*time.Second))
+	for _, tc := range []struct{ parent, child string }{
+		{"org", "org"},
+		{"user1", "org"},
+		{"user1", "team"},
+	} {
+		if err := m.AddChildRule(tc.parent, tc.child, NewRule(1, 1*time.Second)); !errors.Is(err, ErrInvalidRule) {
+			t.Fatalf("Expected %v making %s a child of %s but got %v", ErrInvalidRule, tc.child, tc.parent, err)
+		}
+	}
+}
+
+func TestChildRuleConcurrent(t *testing.T) {
+	m := NewManagerWithShards(4)
+
+	m.AddRule("org", NewRule(100, 1*time.Second))
+	users := []string{"user1", "user2", "user3", "user4"}
+	for _, user := range users {
+		m.AddChildRule("org", user, NewRule(50, 1*time.Second))
+	}
+
+	var wg sync.WaitGroup
+	for _, user := range users {
+		wg.Add(1)
+		go func(user string) {
+			defer wg.Done()
+			for i := 0; i < 50; i++ {
+				m.UseToken(user)
+			}
+		}(user)
+	}
+	wg.Wait()
+
+	var used int
+	for _, user := range users {
+		remaining, _ := m.Remaining(user)
+		used += 50 - remaining
+	}
+	if remaining, _ := m.Remaining("org"); remaining != 0 || used != 100 {
+		t.Fatalf("Expected children to use exactly the org's 100 tokens but used %d with %d left", used, remaining)
+	}
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"time"
+)
+
+// WithIdleTTL has a running manager evict rules which have not been added, used or looked up with
+// GetRule for at least ttl, checking every sweepInterval. This bounds memory for services which see
+// many short-lived keys, such as one-time callers. A non-positive sweepInterval checks every ttl and
+// a non-positive ttl disables eviction, which is the default.
+func WithIdleTTL(ttl, sweepInterval time.Duration) Option {
+	return func(c *config) {
+		if ttl <= 0 {
+			c.idleTTL = 0
+			return
+		}
+		if sweepInterval <= 0 {
+			sweepInterval = ttl
+		}
+		c.idleTTL = ttl
+		c.sweepInterval = sweepInterval
+	}
+}
+
+// evictIdle removes every rule which has been idle for at least the manager's TTL, locking one shard
+// at a time
+func (m *KeyedManager[K]) evictIdle() {
+	now := m.clock.Now()
+	var evicted []K // only collected to be logged
+	for _, s := range m.shards {
+		s.Lock()
+		for h, head := range s.rules {
+			var kept *entry[K]
+			for e := head; e != nil; {
+				next := e.next
+				if now.Sub(e.lastAccess) < m.idleTTL {
+					e.next = kept
+					kept = e
+				} else {
+					s.evict(e)
+					if m.logger != nil {
+						evicted = append(evicted, e.key)
+					}
+				}
+				e = next
+			}
+			if kept == nil {
+				delete(s.rules, h)
+			} else {
+				s.rules[h] = kept
+			}
+		}
+		m.removed(s)
+		s.Unlock()
+		for _, key := range evicted {
+			m.logger.Infof("evicted idle rule for key %s", formatKey(key))
+		}
+		evicted = evicted[:0]
+	}
+}
@@ -0,0 +1,52 @@
+package main
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestIdleTTL(t *testing.T) {
+	clock := newFakeClock()
+	m := NewManagerLazy(WithClock(clock), WithIdleTTL(time.Minute, 10*time.Second))
+	m.Run()
+	defer m.Stop()
+
+	m.AddRule("user1", NewRule(1, 1*time.Second))
+	m.AddRule("user2", NewRule(1, 1*time.Second))
+	for clock.Waiters() == 0 {
+		time.Sleep(time.Millisecond)
+	}
+
+	for i := 0; i < 5; i++ {
+		clock.Advance(10 * time.Second)
+		m.UseToken("user2")
+	}
+	clock.Advance(10 * time.Second)
+	deadline := time.Now().Add(time.Second)
+	for m.Len() != 1 {
+		if time.Now().After(deadline) {
+			t.Fatalf("Expected only the idle rule to be evicted but got %v", m.Keys())
+		}
+		time.Sleep(time.Millisecond)
+	}
+	if _, err := m.GetRule("user1"); !errors.Is(err, ErrRuleDoesNotExist) {
+		t.Fatalf("Expected %v for an evicted rule but got %v", ErrRuleDoesNotExist, err)
+	}
+	if _, err := m.GetRule("user2"); err != nil {
+		t.Fatalf("Did not expect the used rule to be evicted, %v", err)
+	}
+}
+
+func TestIdleTTLDisabled(t *testing.T) {
+	clock := newFakeClock()
+	m := NewManagerLazy(WithClock(clock))
+	m.Run()
+	defer m.Stop()
+
+	m.AddRule("user1", NewRule(1, 1*time.Second))
+	clock.Advance(24 * time.Hour)
+	if m.Len() != 1 || clock.Waiters() != 0 {
+		t.Fatalf("Did not expect rules to be evicted by default")
+	}
+}
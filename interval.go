@@ -0,0 +1,75 @@
+package main
+
+import (
+	"time"
+)
+
+// WithRefillInterval has a rule refilled every d rather than every update interval of its manager,
+// such as coarse refills for a daily quota or fine ones for a rule which must recover smoothly. Each
+// refill adds d's worth of tokens at the rule's qps, carrying any fraction over to the next refill, so
+// a rule of 0.001 qps refilled daily adds 86 tokens a day and carries 0.4 over towards an 87th. Managers
+// using WithRefillQueue refill the rule on exactly its own interval, waking at least that often from
+// then on even after the rule is removed. Managers sweeping every update interval refill it on the
+// first sweep once its interval has passed, so intervals shorter than the update interval are rounded
+// up to it. Lazily refilled rules accrue tokens continuously whatever their interval. Non-positive
+// intervals are ignored.
+func WithRefillInterval(d time.Duration) RuleOption {
+	return func(r *Rule) {
+		if d > 0 {
+			r.every = d
+			r.setUpdateRate(r.interval)
+		}
+	}
+}
+
+// RefillInterval returns the rule's own refill interval, or zero if it is refilled every update
+// interval of its manager
+func (r *Rule) RefillInterval() time.Duration {
+	return r.every
+}
+
+// refillInterval returns how often a limiter asks to be refilled, using its RefillInterval method if it
+// has one and fallback otherwise
+func refillInterval(l Limiter, fallback time.Duration) time.Duration {
+	if ri, ok := l.(interface{ RefillInterval() time.Duration }); ok {
+		if every := ri.RefillInterval(); every > 0 {
+			return every
+		}
+	}
+	return fallback
+}
+
+// period returns the time between the rule's refills, taking each sweep as a refill when the rule has
+// no interval of its own or a shorter one than a sweeping manager's
+func (r *Rule) period() time.Duration {
+	if r.every > 0 && (r.queued || r.every > r.interval) {
+		return r.every
+	}
+	return r.interval
+}
+
+// untilOwnRefill returns how long after the manager's next sweep the rule is next refilled, which is
+// only later when the rule is swept more often than its own interval
+func (r *Rule) untilOwnRefill(updateRate time.Duration) time.Duration {
+	period := r.period()
+	if r.queued || period <= updateRate || updateRate <= 0 {
+		return 0
+	}
+	sweeps := (period - r.elapsed + updateRate - 1) / updateRate
+	return (sweeps - 1) * updateRate
+}
+
+// queueWait returns the longest a manager queueing refills may wait between refills, which is the
+// shortest of its update interval and any rule's own refill interval so that rules queued while it
+// waits are not refilled late. The shortest interval is kept once its rule is removed or replaced
+// rather than recomputed from every remaining rule, which at worst wakes the manager more often than
+// needed.
+func (m *KeyedManager[K]) queueWait() time.Duration {
+	m.Lock()
+	wait := m.updateRate
+	if m.finest > 0 && m.finest < wait {
+		wait = m.finest
+	}
+	m.Unlock()
+	return wait
+}
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestRefillInterval(t *testing.T) {
+	m := NewManager(WithUpdateRate(time.Second))
+
+	user := "user1"
+	m.AddRule(user, NewRule(1, 10*time.Second, WithRefillInterval(3*time.Second)))
+	m.UseTokens(user, 10)
+
+	for i := 0; i < 2; i++ {
+		m.addTokens()
+	}
+	if remaining, _ := m.Remaining(user); remaining != 0 {
+		t.Fatalf("Did not expect a refill before the rule's own interval but got %d tokens", remaining)
+	}
+	m.addTokens()
+	if remaining, _ := m.Remaining(user); remaining != 3 {
+		t.Fatalf("Expected the interval's worth of tokens after 3 sweeps but got %d", remaining)
+	}
+
+	m.Run()
+	defer m.Stop()
+	err := m.UseTokens(user, 5)
+	var qerr *QuotaExceededError
+	if !errors.As(err, &qerr) || qerr.RetryAfter <= 2*time.Second || qerr.RetryAfter > 3*time.Second {
+		t.Fatalf("Expected to retry after the rule's next own refill but got %v", err)
+	}
+}
+
+func TestRefillIntervalQueued(t *testing.T) {
+	clock := newFakeClock()
+	m := NewManager(WithClock(clock), WithUpdateRate(time.Second), WithRefillQueue())
+	m.Run()
+	defer m.Stop()
+
+	m.AddRule("fine", NewRule(10, time.Second, WithRefillInterval(200*time.Millisecond)))
+	m.AddRule("coarse", NewRule(1, 10*time.Second, WithRefillInterval(5*time.Second)))
+	m.UseTokens("fine", 10)
+	m.UseTokens("coarse", 10)
+
+	clock.Advance(200 * time.Millisecond)
+	if wait := m.refillQueued(); wait != 200*time.Millisecond {
+		t.Fatalf("Expected to wait no longer than the finest interval but got %v", wait)
+	}
+	if remaining, _ := m.Remaining("fine"); remaining != 2 {
+		t.Fatalf("Expected a fine refill of 2 tokens but got %d", remaining)
+	}
+
+	clock.Advance(4800 * time.Millisecond)
+	m.refillQueued()
+	if remaining, _ := m.Remaining("coarse"); remaining != 5 {
+		t.Fatalf("Expected a coarse refill of 5 tokens but got %d", remaining)
+	}
+	if wait, _ := m.RetryAfter("coarse"); wait != 0 {
+		t.Fatalf("Expected a token to be available now but got %v", wait)
+	}
+	m.UseTokens("coarse", 5)
+	if wait, err := m.RetryAfter("coarse"); err != nil || wait != 5*time.Second {
+		t.Fatalf("Expected to retry after the coarse interval but got %v, %v", wait, err)
+	}
+}
+
+func TestRefillIntervalQueuedRemoved(t *testing.T) {
+	m := NewManager(WithUpdateRate(time.Second), WithRefillQueue())
+	m.AddRule("fine", NewRule(10, time.Second, WithRefillInterval(200*time.Millisecond)))
+	m.RemoveRule("fine")
+	m.AddRule("coarse", NewRule(1, 10*time.Second, WithRefillInterval(5*time.Second)))
+
+	if wait := m.queueWait(); wait != 200*time.Millisecond {
+		t.Fatalf("Expected to keep waiting no longer than a removed rule's interval but got %v", wait)
+	}
+}
+
+func TestRefillIntervalJSON(t *testing.T) {
+	var r Rule
+	if err := json.Unmarshal([]byte(`{"qps": 1, "window": "1h", "refill_interval": "1m"}`), &r); err != nil {
+		t.Fatalf("Did not expect an error decoding a rule, %v", err)
+	}
+	if r.RefillInterval() != time.Minute {
+		t.Fatalf("Expected a refill interval of 1m but got %v", r.RefillInterval())
+	}
+}
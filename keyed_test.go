@@ -0,0 +1,101 @@
+package main
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+type routeKey struct {
+	tenant string
+	route  string
+}
+
+// tenantKey is a key which can be loaded from a config as "tenant:id"
+type tenantKey struct {
+	tenant string
+	id     int
+}
+
+func (k *tenantKey) UnmarshalText(text []byte) error {
+	tenant, id, ok := strings.Cut(string(text), ":")
+	if !ok {
+		return errors.New("expected tenant:id")
+	}
+	n, err := strconv.Atoi(id)
+	if err != nil {
+		return err
+	}
+	k.tenant, k.id = tenant, n
+	return nil
+}
+
+func TestKeyedManager(t *testing.T) {
+	m := NewKeyedManager[routeKey]()
+
+	search := routeKey{"tenant1", "/search"}
+	upload := routeKey{"tenant1", "/upload"}
+	m.AddRule(search, NewRule(1, 2*time.Second))
+	m.AddRule(upload, NewRule(1, 1*time.Second))
+
+	m.UseToken(search)
+	m.UseToken(search)
+	err := m.UseToken(search)
+	var qerr *KeyedQuotaExceededError[routeKey]
+	if !errors.As(err, &qerr) || qerr.Key != search {
+		t.Fatalf("Expected %v for %v but got %v", ErrQuotaExceeded, search, err)
+	}
+	if !strings.Contains(err.Error(), "/search") {
+		t.Fatalf("Expected the error to name the key but got %q", err.Error())
+	}
+
+	if err := m.UseToken(upload); err != nil {
+		t.Fatalf("Did not expect an error using a token for %v, %v", upload, err)
+	}
+	if err := m.UseToken(routeKey{"tenant2", "/search"}); !errors.Is(err, ErrRuleDoesNotExist) {
+		t.Fatalf("Expected %v but got %v", ErrRuleDoesNotExist, err)
+	}
+	if keys := m.Keys(); len(keys) != 2 {
+		t.Fatalf("Expected 2 keys but got %v", keys)
+	}
+}
+
+func TestKeyedManagerCollision(t *testing.T) {
+	m := NewKeyedManager[routeKey]()
+	m.hash = func(routeKey) uint64 { return 0 }
+
+	a := routeKey{"tenant1", "/search"}
+	b := routeKey{"tenant2", "/search"}
+	m.AddRule(a, NewRule(1, 1*time.Second))
+	m.AddRule(b, NewRule(1, 2*time.Second))
+
+	m.UseToken(a)
+	if remaining, _ := m.Remaining(b); remaining != 2 {
+		t.Fatalf("Expected colliding keys to keep their own rules but got %d tokens", remaining)
+	}
+	m.RemoveRule(a)
+	if _, err := m.GetRule(b); err != nil {
+		t.Fatalf("Did not expect an error getting a colliding rule, %v", err)
+	}
+}
+
+func TestKeyedManagerLoadConfig(t *testing.T) {
+	m := NewKeyedManager[tenantKey]()
+
+	config := `{"tenant1:7": {"qps": 1, "window": "3s"}}`
+	if err := m.LoadConfig(strings.NewReader(config)); err != nil {
+		t.Fatalf("Did not expect an error loading a config, %v", err)
+	}
+	if remaining, err := m.Remaining(tenantKey{"tenant1", 7}); err != nil || remaining != 3 {
+		t.Fatalf("Expected 3 tokens for the parsed key but got %d, %v", remaining, err)
+	}
+
+	if err := m.LoadConfig(strings.NewReader(`{"tenant1": {"qps": 1, "window": "3s"}}`)); !errors.Is(err, ErrInvalidConfig) {
+		t.Fatalf("Expected %v for a key which does not parse but got %v", ErrInvalidConfig, err)
+	}
+	if err := NewKeyedManager[routeKey]().LoadConfig(strings.NewReader(config)); !errors.Is(err, ErrInvalidConfig) {
+		t.Fatalf("Expected %v for keys which cannot be parsed but got %v", ErrInvalidConfig, err)
+	}
+}
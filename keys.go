@@ -0,0 +1,56 @@
+package main
+
+import (
+	"strings"
+)
+
+// keyEscaper escapes the separator of composite keys and the escape character itself
+var keyEscaper = strings.NewReplacer(`\`, `\\`, ":", `\:`)
+
+// Key builds a composite key from parts, such as Key(tenant, route, method), joining them with ":" and
+// escaping any ":" or "\" within a part with a "\". Different parts therefore never build the same
+// key, so Key("a", "bc") and Key("ab", "c") are distinct, with the one exception that no parts and a
+// single empty part both build the empty key. Parts without either character are kept readable, such
+// as "tenant1:/search:GET".
+func Key(parts ...string) string {
+	var b strings.Builder
+	for i, part := range parts {
+		if i > 0 {
+			b.WriteByte(':')
+		}
+		keyEscaper.WriteString(&b, part)
+	}
+	return b.String()
+}
+
+// WithKeyFunc normalizes every string key passed to a manager with f before it is looked up or stored,
+// such as to lowercase API keys or strip ports from addresses, so that the same client never ends up
+// with two rules. It applies to every method taking a key, including the allowlist and denylist, and
+// keys returned by the manager, such as by Keys or to callbacks, are the normalized ones. f must be
+// idempotent, since those keys are normalized again when passed back. Managers with keys other than
+// strings ignore it, and keys are used as is by default.
+func WithKeyFunc(f func(string) string) Option {
+	return func(c *config) {
+		c.keyFunc = f
+	}
+}
+
+// normalize returns the key a manager looks up and stores for key
+func (m *KeyedManager[K]) normalize(key K) K {
+	if m.normalizeKey == nil {
+		return key
+	}
+	return m.normalizeKey(key)
+}
+
+// normalizeAll returns keys normalized, copying them only if the manager normalizes keys
+func (m *KeyedManager[K]) normalizeAll(keys []K) []K {
+	if m.normalizeKey == nil {
+		return keys
+	}
+	normalized := make([]K, len(keys))
+	for i, key := range keys {
+		normalized[i] = m.normalize(key)
+	}
+	return normalized
+}
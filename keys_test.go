@@ -0,0 +1,123 @@
+package main
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestKey(t *testing.T) {
+	if key := Key("tenant1", "/search", "GET"); key != "tenant1:/search:GET" {
+		t.Fatalf("Expected parts to be joined readably but got %q", key)
+	}
+	if key := Key(`a:b\c`); key != `a\:b\\c` {
+		t.Fatalf("Expected separators and escapes to be escaped but got %q", key)
+	}
+
+	for _, tc := range [][2][]string{
+		{{"a", "bc"}, {"ab", "c"}},
+		{{"a:b"}, {"a", "b"}},
+		{{`a\`, "b"}, {`a\:b`}},
+		{{`a\`, ":b"}, {`a\:`, "b"}},
+		{{"", "a"}, {"a", ""}},
+		{{""}, {"", ""}},
+	} {
+		if a, b := Key(tc[0]...), Key(tc[1]...); a == b {
+			t.Fatalf("Expected %q and %q to build different keys but both built %q", tc[0], tc[1], a)
+		}
+	}
+}
+
+func TestKeyManager(t *testing.T) {
+	m := NewManager()
+
+	m.AddRule(Key("tenant1", "/search"), NewRule(1, 1*time.Second))
+	if err := m.UseToken(Key("tenant1", "/search")); err != nil {
+		t.Fatalf("Did not expect an error using a composite key, %v", err)
+	}
+	if err := m.UseToken(Key("tenant1:/search")); !errors.Is(err, ErrRuleDoesNotExist) {
+		t.Fatalf("Expected %v for a colliding concatenation but got %v", ErrRuleDoesNotExist, err)
+	}
+}
+
+func TestWithKeyFunc(t *testing.T) {
+	m := NewManager(WithKeyFunc(strings.ToLower))
+	m.AddRule("API-Key-1", NewRule(1, 2*time.Second))
+
+	if err := m.UseToken("api-key-1"); err != nil {
+		t.Fatalf("Did not expect an error using a token for a normalized key, %v", err)
+	}
+	if err := m.UseTokensMulti([]string{"Api-Key-1"}); err != nil {
+		t.Fatalf("Did not expect an error using tokens for a normalized key, %v", err)
+	}
+	if remaining, _ := m.Remaining("API-KEY-1"); remaining != 0 {
+		t.Fatalf("Expected every spelling of the key to share a rule but got %d remaining", remaining)
+	}
+	if keys := m.Keys(); len(keys) != 1 || keys[0] != "api-key-1" {
+		t.Fatalf("Expected only the normalized key but got %v", keys)
+	}
+
+	m.Allowlist("API-KEY-1")
+	if err := m.UseToken("api-key-1"); err != nil {
+		t.Fatalf("Did not expect an error for a key allowlisted under another spelling, %v", err)
+	}
+	if err := m.RemoveRule("Api-Key-1"); err != nil {
+		t.Fatalf("Did not expect an error removing a normalized key, %v", err)
+	}
+	if _, err := m.GetRule("api-key-1"); !errors.Is(err, ErrRuleDoesNotExist) {
+		t.Fatalf("Expected %v once removed but got %v", ErrRuleDoesNotExist, err)
+	}
+}
+
+func TestWithKeyFuncConfig(t *testing.T) {
+	m := NewManager(WithKeyFunc(strings.ToLower))
+
+	config := `{"User1": {"qps": 1, "window": "2s"}, "user1": {"qps": 2, "window": "2s"}}`
+	err := m.LoadConfig(strings.NewReader(config))
+	if !errors.Is(err, ErrInvalidConfig) || !strings.Contains(err.Error(), `"user1" is duplicated`) {
+		t.Fatalf("Expected keys normalizing to the same key to be duplicates but got %v", err)
+	}
+	if m.Len() != 0 {
+		t.Fatalf("Did not expect any rules to be loaded but got %d", m.Len())
+	}
+
+	if err := m.LoadConfig(strings.NewReader(`{"User1": {"qps": 1, "window": "2s"}}`)); err != nil {
+		t.Fatalf("Did not expect an error loading the config, %v", err)
+	}
+	if keys := m.Keys(); len(keys) != 1 || keys[0] != "user1" {
+		t.Fatalf("Expected only the normalized key but got %v", keys)
+	}
+}
+
+func TestWithKeyFuncSyncMapManager(t *testing.T) {
+	m := NewSyncMapManager(WithKeyFunc(strings.ToLower))
+	m.AddRule("API-Key-1", NewRule(1, 2*time.Second))
+
+	if err := m.UseToken("api-key-1"); err != nil {
+		t.Fatalf("Did not expect an error using a token for a normalized key, %v", err)
+	}
+	if err := m.UseTokens("Api-Key-1", 1); err != nil {
+		t.Fatalf("Did not expect an error using tokens for a normalized key, %v", err)
+	}
+	if remaining, _ := m.Remaining("API-KEY-1"); remaining != 0 {
+		t.Fatalf("Expected every spelling of the key to share a rule but got %d remaining", remaining)
+	}
+	if stats, err := m.Stats("API-key-1"); err != nil || stats.Allowed != 2 {
+		t.Fatalf("Expected stats for the normalized key but got %+v, %v", stats, err)
+	}
+	if err := m.RemoveRule("Api-Key-1"); err != nil {
+		t.Fatalf("Did not expect an error removing a normalized key, %v", err)
+	}
+	if _, err := m.GetRule("api-key-1"); !errors.Is(err, ErrRuleDoesNotExist) {
+		t.Fatalf("Expected %v once removed but got %v", ErrRuleDoesNotExist, err)
+	}
+}
+
+func TestWithKeyFuncOtherKeys(t *testing.T) {
+	m := NewKeyedManager[int](WithKeyFunc(strings.ToLower))
+	m.AddRule(1, NewRule(1, 1*time.Second))
+	if err := m.UseToken(1); err != nil {
+		t.Fatalf("Did not expect the key func to affect int keys, %v", err)
+	}
+}
@@ -0,0 +1,113 @@
+package main
+
+import (
+	"math"
+	"time"
+)
+
+// LeakyBucket limits a key by adding each request to a bucket which drains continuously at a fixed
+// rate, admitting a request only if it fits within the bucket's capacity. The requests admitted match
+// a token bucket with a burst of capacity, but where a Rule is refilled a whole update interval's
+// worth of tokens at a time, the bucket drains as time passes so capacity frees up evenly between
+// refills. A small capacity therefore paces requests: with a capacity of 1, requests are admitted at
+// least 1/rate seconds apart and RetryAfter waits out exactly the remaining gap.
+type LeakyBucket struct {
+	rate     float64 // requests drained per second
+	capacity int
+	level    float64   // requests in the bucket, which exceeds capacity after borrowing
+	last     time.Time // time the bucket was last drained
+}
+
+// NewLeakyBucket creates a leaky bucket holding up to capacity requests which drains rate requests per
+// second
+func NewLeakyBucket(rate float64, capacity int) *LeakyBucket {
+	return &LeakyBucket{rate: rate, capacity: capacity}
+}
+
+// Rate returns the requests drained per second
+func (b *LeakyBucket) Rate() float64 {
+	return b.rate
+}
+
+// UseTokens adds n requests to the bucket if they fit within its capacity and returns whether they
+// were added
+func (b *LeakyBucket) UseTokens(n int) bool {
+	if b.level+float64(n) > float64(b.capacity) {
+		return false
+	}
+	b.level += float64(n)
+	return true
+}
+
+// Borrow adds n requests to the bucket whether or not they fit, delaying further requests until the
+// excess has drained
+func (b *LeakyBucket) Borrow(n int) {
+	b.level += float64(n)
+}
+
+// ReturnTokens removes n requests from the bucket
+func (b *LeakyBucket) ReturnTokens(n int) {
+	b.level = math.Max(b.level-float64(n), 0)
+}
+
+// Remaining returns how many more requests fit in the bucket
+func (b *LeakyBucket) Remaining() int {
+	if remaining := math.Floor(float64(b.capacity) - b.level); remaining > 0 {
+		return int(remaining)
+	}
+	return 0
+}
+
+// SetTokens fills the bucket so that n more requests fit, clamped to between zero and its capacity
+func (b *LeakyBucket) SetTokens(n int) {
+	if n > b.capacity {
+		n = b.capacity
+	}
+	if n < 0 {
+		n = 0
+	}
+	b.level = float64(b.capacity - n)
+}
+
+// Max returns the capacity of the bucket
+func (b *LeakyBucket) Max() int {
+	return b.capacity
+}
+
+// Full returns true since refills add nothing to a bucket that drains as time passes
+func (b *LeakyBucket) Full() bool {
+	return true
+}
+
+// AddToken is a no-op since the bucket drains as time passes rather than on refills
+func (b *LeakyBucket) AddToken() {}
+
+// Refill drains the bucket for the time elapsed since it was last drained
+func (b *LeakyBucket) Refill(now time.Time) {
+	elapsed := now.Sub(b.last)
+	if elapsed <= 0 {
+		return
+	}
+	b.last = now
+	b.level = math.Max(b.level-elapsed.Seconds()*b.rate, 0)
+}
+
+// SetSchedule records the time the bucket was added to a manager
+func (b *LeakyBucket) SetSchedule(sch Schedule) {
+	b.last = sch.Now
+}
+
+// RetryAfter returns how long until enough of the bucket has drained for n more requests to fit
+func (b *LeakyBucket) RetryAfter(n int, sch Schedule) (time.Duration, Limiter, bool) {
+	if n > b.capacity {
+		return 0, b, false
+	}
+	excess := b.level + float64(n) - float64(b.capacity)
+	if excess <= 0 {
+		return 0, b, true
+	}
+	if b.rate <= 0 {
+		return 0, b, false
+	}
+	return time.Duration(math.Ceil(excess / b.rate * float64(time.Second))), b, true
+}
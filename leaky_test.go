@@ -0,0 +1,62 @@
+package main
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestLeakyBucket(t *testing.T) {
+	clock := newFakeClock()
+	m := NewManager(WithClock(clock))
+
+	user := "user1"
+	m.AddRule(user, NewLeakyBucket(2, 3))
+	if err := m.UseTokens(user, 3); err != nil {
+		t.Fatalf("Did not expect an error filling the bucket, %v", err)
+	}
+	var qe *QuotaExceededError
+	if err := m.UseToken(user); !errors.As(err, &qe) || qe.RetryAfter != 500*time.Millisecond {
+		t.Fatalf("Expected %v retrying once a request drains but got %v", ErrQuotaExceeded, err)
+	}
+
+	// the bucket drains between refills rather than a refill at a time
+	clock.Advance(250 * time.Millisecond)
+	if remaining, _ := m.Remaining(user); remaining != 0 {
+		t.Fatalf("Did not expect half a drained request to fit but got %d remaining", remaining)
+	}
+	clock.Advance(250 * time.Millisecond)
+	if remaining, _ := m.Remaining(user); remaining != 1 {
+		t.Fatalf("Expected a request to drain after 500ms but got %d remaining", remaining)
+	}
+	clock.Advance(10 * time.Second)
+	if remaining, _ := m.Remaining(user); remaining != 3 {
+		t.Fatalf("Expected the bucket to drain no further than empty but got %d remaining", remaining)
+	}
+}
+
+func TestLeakyBucketPacing(t *testing.T) {
+	clock := newFakeClock()
+	m := NewManager(WithClock(clock))
+
+	user := "user1"
+	m.AddRule(user, NewLeakyBucket(10, 1))
+	start := clock.Now()
+
+	// each request waits out the gap left by the previous one, so they are admitted 100ms apart
+	for i := 0; i < 5; i++ {
+		err := m.UseToken(user)
+		var qe *QuotaExceededError
+		for errors.As(err, &qe) {
+			clock.Advance(qe.RetryAfter)
+			err = m.UseToken(user)
+		}
+		if err != nil {
+			t.Fatalf("Did not expect an error pacing requests, %v", err)
+		}
+		if admitted, expected := clock.Now().Sub(start), time.Duration(i)*100*time.Millisecond; admitted != expected {
+			t.Fatalf("Expected request %d to be admitted at %v but got %v", i, expected, admitted)
+		}
+		clock.Advance(30 * time.Millisecond)
+	}
+}
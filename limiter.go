@@ -0,0 +1,75 @@
+package main
+
+import (
+	"time"
+)
+
+// Limiter is implemented by the rule types a Manager can enforce, such as *Rule, *MultiRule and
+// *SlidingWindowRule, and lets callers plug in their own algorithms. A Manager calls these methods
+// with the limiter's shard locked, so implementations need no synchronization of their own as long as
+// a limiter is only added to a single manager under a single key. Calling them directly on a limiter
+// that has been added to a manager is not safe.
+type Limiter interface {
+	// UseTokens uses n tokens if they are all available and returns whether they were used
+	UseTokens(n int) bool
+
+	// Borrow uses n tokens whether or not they are available, leaving a debt for future refills
+	Borrow(n int)
+
+	// ReturnTokens gives back n previously used tokens without exceeding the limiter's maximum
+	ReturnTokens(n int)
+
+	// Remaining returns the number of tokens currently available
+	Remaining() int
+
+	// Max returns the most tokens the limiter can have available
+	Max() int
+
+	// SetTokens sets the number of tokens available, clamped to between zero and Max
+	SetTokens(n int)
+
+	// AddToken adds a single refill's worth of tokens and is called every update interval while the
+	// manager is running
+	AddToken()
+
+	// Refill brings the limiter up to date as of now and is called before each use
+	Refill(now time.Time)
+
+	// SetSchedule configures the limiter for the refill schedule of the manager it is added to
+	SetSchedule(sch Schedule)
+
+	// RetryAfter returns how long until n tokens are available, the limiter which is the binding
+	// constraint and false if the tokens will never be available
+	RetryAfter(n int, sch Schedule) (time.Duration, Limiter, bool)
+}
+
+// Schedule describes how and when a manager refills its limiters
+type Schedule struct {
+	Now        time.Time
+	Lazy       bool // tokens should be accrued in Refill rather than AddToken
+	UpdateRate time.Duration
+	NextRefill time.Time // zero when no refill is scheduled
+	Queued     bool      // AddToken is called at a limiter's own refill interval if it has one
+}
+
+// schedule returns the current refill schedule of the manager. The manager must be locked.
+func (m *KeyedManager[K]) schedule(now time.Time) Schedule {
+	return Schedule{
+		Now:        now,
+		Lazy:       m.lazy,
+		UpdateRate: m.updateRate,
+		NextRefill: m.nextRefill,
+		Queued:     m.queued,
+	}
+}
+
+// scheduleFor returns the refill schedule of an entry, which is next refilled when it is due rather
+// than at the manager's next refill if the manager queues refills. The manager and the entry's shard
+// must be locked.
+func (m *KeyedManager[K]) scheduleFor(e *entry[K], now time.Time) Schedule {
+	sch := m.schedule(now)
+	if !sch.NextRefill.IsZero() && !e.due.IsZero() {
+		sch.NextRefill = e.due
+	}
+	return sch
+}
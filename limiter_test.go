@@ -0,0 +1,53 @@
+package main
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+// fixedLimiter allows a fixed number of tokens that are never refilled
+type fixedLimiter struct {
+	count int
+}
+
+func (l *fixedLimiter) UseTokens(n int) bool {
+	if l.count < n {
+		return false
+	}
+	l.count -= n
+	return true
+}
+
+func (l *fixedLimiter) Borrow(n int)             { l.count -= n }
+func (l *fixedLimiter) ReturnTokens(n int)       { l.count += n }
+func (l *fixedLimiter) Remaining() int           { return l.count }
+func (l *fixedLimiter) Max() int                 { return l.count }
+func (l *fixedLimiter) SetTokens(n int)          { l.count = n }
+func (l *fixedLimiter) AddToken()                {}
+func (l *fixedLimiter) Refill(now time.Time)     {}
+func (l *fixedLimiter) SetSchedule(sch Schedule) {}
+func (l *fixedLimiter) RetryAfter(n int, sch Schedule) (time.Duration, Limiter, bool) {
+	return 0, l, l.count >= n
+}
+
+func TestCustomLimiter(t *testing.T) {
+	m := NewManager()
+
+	user := "user1"
+	l := &fixedLimiter{count: 2}
+	m.AddRule(user, l)
+
+	if err := m.UseTokens(user, 2); err != nil {
+		t.Fatalf("Did not expect an error on valid user, %v", err)
+	}
+	var qe *QuotaExceededError
+	if err := m.UseToken(user); !errors.As(err, &qe) || qe.Rule != l {
+		t.Fatalf("Expected the custom limiter to deny the request but got %v", err)
+	}
+
+	r, err := m.GetRule(user)
+	if err != nil || r != l {
+		t.Fatalf("Expected to get back the custom limiter but got %v, %v", r, err)
+	}
+}
@@ -0,0 +1,109 @@
+package main
+
+// listing is whether a key is allowlisted or denylisted
+type listing bool
+
+const (
+	denied  listing = false
+	allowed listing = true
+)
+
+// Allowlist exempts keys from quotas, such as those of internal or admin callers. Token uses such as
+// UseToken, UseTokenFunc, UseTokensMulti, WaitToken, Reserve, Acquire and AllowAt succeed for
+// allowlisted keys without touching their rules, which need not exist, so their stats and tokens are
+// unaffected. A denylisted key is moved to the allowlist. Keys are checked before any rule, after
+// closing or draining the manager.
+func (m *KeyedManager[K]) Allowlist(keys ...K) {
+	m.list(keys, allowed)
+}
+
+// Denylist denies every token use for keys whatever their rules' tokens, such as for abusive callers.
+// Token uses such as UseToken, UseTokenFunc, UseTokensMulti, WaitToken, Reserve, Acquire and Observe
+// return a KeyedQuotaExceededError without a Rule or RetryAfter for denylisted keys, WaitToken
+// without waiting, and AllowAt reports them as not allowed. An allowlisted key is moved to the
+// denylist.
+func (m *KeyedManager[K]) Denylist(keys ...K) {
+	m.list(keys, denied)
+}
+
+// RemoveAllowlist returns keys on the allowlist to their quotas, ignoring those which are not on it
+func (m *KeyedManager[K]) RemoveAllowlist(keys ...K) {
+	m.unlist(keys, allowed)
+}
+
+// RemoveDenylist returns keys on the denylist to their quotas, ignoring those which are not on it
+func (m *KeyedManager[K]) RemoveDenylist(keys ...K) {
+	m.unlist(keys, denied)
+}
+
+// list sets the listing of keys. Lists are copied on write so that token uses read them without a lock.
+func (m *KeyedManager[K]) list(keys []K, l listing) {
+	m.Lock()
+	lists := m.copyLists(len(keys))
+	for _, key := range keys {
+		lists[m.normalize(key)] = l
+	}
+	m.lists.Store(&lists)
+	m.Unlock()
+}
+
+// unlist removes keys with listing l from the lists
+func (m *KeyedManager[K]) unlist(keys []K, l listing) {
+	m.Lock()
+	lists := m.copyLists(0)
+	for _, key := range keys {
+		key = m.normalize(key)
+		if listed, ok := lists[key]; ok && listed == l {
+			delete(lists, key)
+		}
+	}
+	if len(lists) == 0 {
+		m.lists.Store(nil)
+	} else {
+		m.lists.Store(&lists)
+	}
+	m.Unlock()
+}
+
+// copyLists returns a copy of the lists with room for extra keys. The manager must be locked.
+func (m *KeyedManager[K]) copyLists(extra int) map[K]listing {
+	var lists map[K]listing
+	if p := m.lists.Load(); p != nil {
+		lists = make(map[K]listing, len(*p)+extra)
+		for key, l := range *p {
+			lists[key] = l
+		}
+	} else {
+		lists = make(map[K]listing, extra)
+	}
+	return lists
+}
+
+// listed returns the listing of a key and whether it is on either list
+func (m *KeyedManager[K]) listed(key K) (listing, bool) {
+	p := m.lists.Load()
+	if p == nil {
+		return false, false
+	}
+	l, ok := (*p)[key]
+	return l, ok
+}
+
+// unlisted returns the keys which are on neither list, or a KeyedQuotaExceededError for the first key
+// which is denylisted
+func (m *KeyedManager[K]) unlisted(keys []K) ([]K, error) {
+	if m.lists.Load() == nil {
+		return keys, nil
+	}
+	var rest []K
+	for _, key := range keys {
+		l, ok := m.listed(key)
+		switch {
+		case !ok:
+			rest = append(rest, key)
+		case l == denied:
+			return nil, &KeyedQuotaExceededError[K]{Key: key}
+		}
+	}
+	return rest, nil
+}
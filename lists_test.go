@@ -0,0 +1,121 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestAllowlist(t *testing.T) {
+	m := NewManager()
+	m.AddRule("user1", NewRule(1, 1*time.Second))
+	m.UseToken("user1")
+
+	m.Allowlist("user1", "admin")
+	for _, key := range []string{"user1", "admin"} {
+		if err := m.UseToken(key); err != nil {
+			t.Fatalf("Did not expect an error using a token for allowlisted %s, %v", key, err)
+		}
+	}
+	if err := m.UseTokensMulti([]string{"user1", "admin"}); err != nil {
+		t.Fatalf("Did not expect an error using tokens for allowlisted keys, %v", err)
+	}
+	if stats, _ := m.Stats("user1"); stats.Allowed != 1 {
+		t.Fatalf("Did not expect allowlisted uses to touch the rule but got %+v", stats)
+	}
+
+	m.RemoveDenylist("user1")
+	m.RemoveAllowlist("user1")
+	if err := m.UseToken("user1"); !errors.Is(err, ErrQuotaExceeded) {
+		t.Fatalf("Expected %v once user1 is off the allowlist but got %v", ErrQuotaExceeded, err)
+	}
+	if err := m.UseToken("admin"); err != nil {
+		t.Fatalf("Did not expect an error for a key left on the allowlist, %v", err)
+	}
+}
+
+func TestDenylist(t *testing.T) {
+	m := NewManager()
+	m.AddRule("user1", NewRule(1, 5*time.Second))
+	m.AddRule("user2", NewRule(1, 5*time.Second))
+	m.Allowlist("user1")
+
+	m.Denylist("user1")
+	err := m.UseToken("user1")
+	var qerr *QuotaExceededError
+	if !errors.As(err, &qerr) || qerr.Key != "user1" || qerr.Rule != nil {
+		t.Fatalf("Expected %v for denylisted user1 but got %v", ErrQuotaExceeded, err)
+	}
+	if err := m.UseTokensMulti([]string{"user2", "user1"}); !errors.Is(err, ErrQuotaExceeded) {
+		t.Fatalf("Expected %v when any key is denylisted but got %v", ErrQuotaExceeded, err)
+	}
+	if remaining, _ := m.Remaining("user2"); remaining != 5 {
+		t.Fatalf("Did not expect tokens to be used alongside a denylisted key but got %d", remaining)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+	if err := m.WaitToken(ctx, "user1"); !errors.Is(err, ErrQuotaExceeded) {
+		t.Fatalf("Expected %v without waiting for a denylisted key but got %v", ErrQuotaExceeded, err)
+	}
+
+	m.RemoveDenylist("user1")
+	if err := m.UseToken("user1"); err != nil {
+		t.Fatalf("Did not expect an error once user1 is off the denylist, %v", err)
+	}
+	if m.lists.Load() != nil {
+		t.Fatalf("Expected the lists to be dropped once empty")
+	}
+}
+
+func TestListsReserveAllowAt(t *testing.T) {
+	m := NewManager()
+	m.AddRule("user1", NewRule(1, 1*time.Second))
+	m.AddRule("user2", NewRule(1, 5*time.Second))
+	m.UseToken("user1")
+	m.Allowlist("user1", "admin")
+	m.Denylist("user2")
+
+	for _, key := range []string{"user1", "admin"} {
+		res, err := m.Reserve(key)
+		if err != nil {
+			t.Fatalf("Did not expect an error reserving for allowlisted %s, %v", key, err)
+		}
+		if !res.OK() || res.Delay() != 0 {
+			t.Fatalf("Expected an immediate reservation for allowlisted %s", key)
+		}
+		res.Cancel()
+		if ok, err := m.AllowAt(key, time.Now()); err != nil || !ok {
+			t.Fatalf("Expected allowlisted %s to be allowed at any time but got %t, %v", key, ok, err)
+		}
+	}
+	if remaining, _ := m.Remaining("user1"); remaining != 0 {
+		t.Fatalf("Did not expect allowlisted uses to touch the rule but got %d tokens", remaining)
+	}
+
+	if _, err := m.Reserve("user2"); !errors.Is(err, ErrQuotaExceeded) {
+		t.Fatalf("Expected %v reserving for denylisted user2 but got %v", ErrQuotaExceeded, err)
+	}
+	if ok, err := m.AllowAt("user2", time.Now()); err != nil || ok {
+		t.Fatalf("Expected denylisted user2 to not be allowed but got %t, %v", ok, err)
+	}
+	if remaining, _ := m.Remaining("user2"); remaining != 5 {
+		t.Fatalf("Did not expect denylisted uses to touch the rule but got %d tokens", remaining)
+	}
+}
+
+func TestListsRetryAfter(t *testing.T) {
+	m := NewManager()
+	m.AddRule("user2", NewRule(1, 5*time.Second))
+	m.Allowlist("admin")
+	m.Denylist("user2")
+
+	if wait, err := m.RetryAfter("admin"); err != nil || wait != 0 {
+		t.Fatalf("Expected allowlisted admin to be available now but got %v, %v", wait, err)
+	}
+	var qerr *QuotaExceededError
+	if _, err := m.RetryAfter("user2"); !errors.As(err, &qerr) || qerr.RetryAfter != 0 {
+		t.Fatalf("Expected %v without a retry for denylisted user2 but got %v", ErrQuotaExceeded, err)
+	}
+}
@@ -0,0 +1,18 @@
+package main
+
+// Logger receives structured log messages from a Manager, such as a wrapper around log/slog or any
+// other logging package. Rule additions, removals and evictions are logged at info level and denied
+// token uses at debug level. Rule additions are logged while the rule's shard is locked, so a logger
+// must not call back into the manager.
+type Logger interface {
+	Debugf(format string, args ...any)
+	Infof(format string, args ...any)
+}
+
+// WithLogger sets the logger a manager writes to. Managers log nothing by default, and without a logger
+// no log messages are formatted at all.
+func WithLogger(l Logger) Option {
+	return func(c *config) {
+		c.logger = l
+	}
+}
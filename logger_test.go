@@ -0,0 +1,62 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// testLogger records log messages prefixed by their level
+type testLogger struct {
+	sync.Mutex
+	lines []string
+}
+
+func (l *testLogger) Debugf(format string, args ...any) {
+	l.log("debug: "+format, args...)
+}
+
+func (l *testLogger) Infof(format string, args ...any) {
+	l.log("info: "+format, args...)
+}
+
+func (l *testLogger) log(format string, args ...any) {
+	l.Lock()
+	l.lines = append(l.lines, fmt.Sprintf(format, args...))
+	l.Unlock()
+}
+
+func TestLogger(t *testing.T) {
+	clock := newFakeClock()
+	logger := &testLogger{}
+	m := NewManagerWithCapacity(1, WithClock(clock), WithLogger(logger), WithIdleTTL(time.Minute, time.Minute))
+
+	m.AddRule("user1", NewRule(1, 1*time.Second))
+	m.UseToken("user1")
+	m.UseToken("user1")
+	m.AddRule("user2", NewRule(1, 1*time.Second))
+	m.RemoveRule("user2")
+	m.AddRule("user3", NewRule(1, 1*time.Second))
+	clock.Advance(time.Minute)
+	m.evictIdle()
+
+	expected := []string{
+		`info: added rule for key "user1"`,
+		`debug: denied token use: rule quota exceeded for key "user1"`,
+		`info: added rule for key "user2"`,
+		`info: evicted least recently used rule for key "user1"`,
+		`info: removed rule for key "user2"`,
+		`info: added rule for key "user3"`,
+		`info: evicted idle rule for key "user3"`,
+	}
+	if len(logger.lines) != len(expected) {
+		t.Fatalf("Expected %d log lines but got %q", len(expected), logger.lines)
+	}
+	for i, line := range logger.lines {
+		if !strings.HasPrefix(line, expected[i]) {
+			t.Fatalf("Expected log line %d to start with %q but got %q", i, expected[i], line)
+		}
+	}
+}
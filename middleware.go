@@ -0,0 +1,118 @@
+package main
+
+import (
+	"errors"
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+)
+
+// KeyFunc extracts the quota key for a request
+type KeyFunc func(*http.Request) string
+
+// KeyByRemoteIP keys requests by the IP address of the client connection
+func KeyByRemoteIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// KeyByHeader returns a KeyFunc keying requests by the value of a header such as an API key
+func KeyByHeader(name string) KeyFunc {
+	return func(r *http.Request) string {
+		return r.Header.Get(name)
+	}
+}
+
+// KeyedMiddleware rate limits requests to an http.Handler by the key KeyFunc extracts from each
+// request. Requests exceeding their quota receive a 429 Too Many Requests with a Retry-After header,
+// and allowed requests carry an X-RateLimit-Remaining header.
+type KeyedMiddleware[K comparable] struct {
+	Manager *KeyedManager[K]
+	KeyFunc func(*http.Request) K
+
+	// AllowNotFound lets requests whose key has no rule through. Otherwise they receive a 403
+	// Forbidden.
+	AllowNotFound bool
+
+	// Refund returns the tokens a request used when it reports true for the status the handler
+	// responded with, such as RefundServerErrors to not charge clients for the server's own failures.
+	// Tokens are never refunded if it is nil, nor for requests that took none, such as allowlisted
+	// keys, disabled rules, dry run denials or rules enforced by a backend.
+	Refund func(status int) bool
+}
+
+// Middleware is a KeyedMiddleware for a Manager keyed by strings
+type Middleware = KeyedMiddleware[string]
+
+// RefundServerErrors reports whether a status is a 5xx server error, for use as a Refund predicate
+func RefundServerErrors(status int) bool {
+	return status >= http.StatusInternalServerError
+}
+
+// Handler wraps next so that each request uses a token before being served
+func (mw *KeyedMiddleware[K]) Handler(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		key := mw.KeyFunc(r)
+		remaining, c, err := mw.Manager.useToken(key)
+
+		var qe *KeyedQuotaExceededError[K]
+		switch {
+		case err == nil:
+			if remaining >= 0 {
+				w.Header().Set("X-RateLimit-Remaining", strconv.Itoa(remaining))
+			}
+			if mw.Refund != nil {
+				sw := &statusWriter{ResponseWriter: w, status: http.StatusOK}
+				next.ServeHTTP(sw, r)
+				if mw.Refund(sw.status) {
+					mw.Manager.refund(c)
+				}
+				return
+			}
+		case errors.As(err, &qe):
+			if qe.RetryAfter > 0 {
+				w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(qe.RetryAfter.Seconds()))))
+			}
+			w.Header().Set("X-RateLimit-Remaining", "0")
+			http.Error(w, err.Error(), http.StatusTooManyRequests)
+			return
+		case errors.Is(err, ErrRuleDoesNotExist):
+			if !mw.AllowNotFound {
+				http.Error(w, err.Error(), http.StatusForbidden)
+				return
+			}
+		default:
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// statusWriter records the status a handler responds with
+type statusWriter struct {
+	http.ResponseWriter
+	status      int
+	wroteHeader bool
+}
+
+func (w *statusWriter) WriteHeader(status int) {
+	if !w.wroteHeader {
+		w.status, w.wroteHeader = status, true
+	}
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *statusWriter) Write(b []byte) (int, error) {
+	w.wroteHeader = true
+	return w.ResponseWriter.Write(b)
+}
+
+// Unwrap lets http.ResponseController reach the underlying ResponseWriter
+func (w *statusWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
@@ -0,0 +1,164 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestMiddleware(t *testing.T) {
+	clock := newFakeClock()
+	m := NewManager(WithClock(clock))
+	m.Run()
+	defer m.Stop()
+	m.AddRule("key1", NewRule(1, 2*time.Second))
+
+	mw := &Middleware{Manager: m, KeyFunc: KeyByHeader("X-Api-Key")}
+	h := mw.Handler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	}))
+
+	for _, tc := range []struct {
+		code      int
+		remaining string
+	}{
+		{http.StatusNoContent, "1"},
+		{http.StatusNoContent, "0"},
+		{http.StatusTooManyRequests, "0"},
+	} {
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		req.Header.Set("X-Api-Key", "key1")
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+
+		if rec.Code != tc.code {
+			t.Fatalf("Expected status %d but got %d", tc.code, rec.Code)
+		}
+		if remaining := rec.Header().Get("X-RateLimit-Remaining"); remaining != tc.remaining {
+			t.Fatalf("Expected %s remaining but got %s", tc.remaining, remaining)
+		}
+		if tc.code == http.StatusTooManyRequests && rec.Header().Get("Retry-After") != "1" {
+			t.Fatalf("Expected a retry after of 1 second but got %s", rec.Header().Get("Retry-After"))
+		}
+	}
+}
+
+func TestMiddlewareNotFound(t *testing.T) {
+	m := NewManager()
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})
+
+	for _, tc := range []struct {
+		allow bool
+		code  int
+	}{
+		{false, http.StatusForbidden},
+		{true, http.StatusOK},
+	} {
+		mw := &Middleware{Manager: m, KeyFunc: KeyByRemoteIP, AllowNotFound: tc.allow}
+		rec := httptest.NewRecorder()
+		mw.Handler(next).ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/", nil))
+		if rec.Code != tc.code {
+			t.Fatalf("Expected status %d with allow not found %t but got %d", tc.code, tc.allow, rec.Code)
+		}
+	}
+}
+
+func TestMiddlewareRefund(t *testing.T) {
+	m := NewManager()
+	m.AddRule("key1", NewRule(1, 4*time.Second, WithCost(2)))
+
+	mw := &Middleware{Manager: m, KeyFunc: KeyByHeader("X-Api-Key"), Refund: RefundServerErrors}
+	for _, tc := range []struct {
+		code      int
+		remaining int
+	}{
+		{http.StatusServiceUnavailable, 4},
+		{http.StatusBadRequest, 2},
+		{http.StatusOK, 0},
+	} {
+		h := mw.Handler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if tc.code != http.StatusOK {
+				w.WriteHeader(tc.code)
+			}
+			w.Write([]byte("done"))
+		}))
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		req.Header.Set("X-Api-Key", "key1")
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+
+		if rec.Code != tc.code {
+			t.Fatalf("Expected status %d but got %d", tc.code, rec.Code)
+		}
+		if remaining, _ := m.Remaining("key1"); remaining != tc.remaining {
+			t.Fatalf("Expected %d tokens after a %d but got %d", tc.remaining, tc.code, remaining)
+		}
+	}
+}
+
+func TestMiddlewareRefundChain(t *testing.T) {
+	m := NewManager(WithClock(newFakeClock()), WithGlobalLimit(NewRule(10, time.Second)))
+	m.AddRule("org", NewRule(10, time.Second))
+	if err := m.AddChildRule("org", "user1", NewRule(10, time.Second)); err != nil {
+		t.Fatalf("Did not expect an error adding a child rule, %v", err)
+	}
+
+	mw := &Middleware{Manager: m, KeyFunc: KeyByHeader("X-Api-Key"), Refund: RefundServerErrors}
+	h := mw.Handler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	for range 3 {
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		req.Header.Set("X-Api-Key", "user1")
+		h.ServeHTTP(httptest.NewRecorder(), req)
+	}
+	for _, key := range []string{"user1", "org"} {
+		if remaining, _ := m.Remaining(key); remaining != 10 {
+			t.Fatalf("Expected refunds to give back every token of %s but got %d", key, remaining)
+		}
+	}
+	if remaining := m.GlobalRemaining(); remaining != 10 {
+		t.Fatalf("Expected refunds to give back every global token but got %d", remaining)
+	}
+}
+
+func TestMiddlewareRefundUncharged(t *testing.T) {
+	m := NewManager(WithDryRun(true))
+	m.AddRule("key1", NewRule(1, 2*time.Second, WithCost(2)))
+	m.AddRule("key2", NewRule(1, 4*time.Second, WithCost(2)))
+	m.AddRule("key3", NewRule(1, 4*time.Second, WithCost(2)))
+	m.Allowlist("key2")
+	if err := m.Disable("key3"); err != nil {
+		t.Fatalf("Did not expect an error disabling key3, %v", err)
+	}
+	if err := m.UseToken("key1"); err != nil {
+		t.Fatalf("Did not expect an error using key1, %v", err)
+	}
+
+	mw := &Middleware{Manager: m, KeyFunc: KeyByHeader("X-Api-Key"), Refund: RefundServerErrors}
+	h := mw.Handler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	for key, want := range map[string]int{"key1": 0, "key2": 4, "key3": 4} {
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		req.Header.Set("X-Api-Key", key)
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+
+		if rec.Code != http.StatusInternalServerError {
+			t.Fatalf("Expected status %d but got %d", http.StatusInternalServerError, rec.Code)
+		}
+		if remaining, _ := m.Remaining(key); remaining != want {
+			t.Fatalf("Expected %d tokens for %s after an uncharged request but got %d", want, key, remaining)
+		}
+	}
+}
+
+func TestKeyByRemoteIP(t *testing.T) {
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.RemoteAddr = "10.0.0.1:1234"
+	if key := KeyByRemoteIP(req); key != "10.0.0.1" {
+		t.Fatalf("Expected the port to be stripped but got %s", key)
+	}
+}
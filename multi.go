@@ -0,0 +1,97 @@
+package main
+
+import (
+	"sort"
+)
+
+// lockKeys locks the shards of every key, each once, and returns the hashes of the keys along with a
+// function unlocking the shards. Shards are locked in order so that concurrent callers locking
+// overlapping shards cannot deadlock.
+func (m *KeyedManager[K]) lockKeys(keys []K) ([]uint64, func()) {
+	hashes := make([]uint64, len(keys))
+	var indexes []int
+	locked := make(map[int]bool)
+	for i, key := range keys {
+		hashes[i] = m.hash(key)
+		if idx := int(hashes[i] & m.mask); !locked[idx] {
+			locked[idx] = true
+			indexes = append(indexes, idx)
+		}
+	}
+	sort.Ints(indexes)
+	for _, idx := range indexes {
+		m.shards[idx].Lock()
+	}
+	return hashes, func() {
+		for _, idx := range indexes {
+			m.shards[idx].Unlock()
+		}
+	}
+}
+
+// UseTokensMulti tries to use a token for each of several keys at once, such as a user, an
+// endpoint and a global limit, and returns nil if used. Either a token is used from every key or from
+// none of them. Rules with a cost set by WithCost use that many tokens, a key listed more than once is
+// charged each time and disabled or allowlisted keys are skipped. ErrRuleDoesNotExist is returned if
+// any key has no rule, and a QuotaExceededError for the first key without capacity otherwise. The
+// keys' own rules are used in memory, without consulting parents or a backend.
+func (m *KeyedManager[K]) UseTokensMulti(keys []K) error {
+	if err := m.admitting(); err != nil {
+		return err
+	}
+	keys, err := m.unlisted(m.normalizeAll(keys))
+	if err != nil {
+		return err
+	}
+	hashes, unlock := m.lockKeys(keys)
+	entries := make([]*entry[K], len(keys))
+	for i, key := range keys {
+		e := m.entryForUse(m.shard(hashes[i]), hashes[i], key)
+		if e == nil {
+			unlock()
+			return &KeyedRuleNotFoundError[K]{Key: key}
+		}
+		entries[i] = e
+	}
+
+	now := m.clock.Now()
+	blocked := -1
+	for i, e := range entries {
+		m.shard(hashes[i]).touch(e, now)
+		if e.disabled {
+			continue
+		}
+		e.rule.Refill(now)
+		if !e.rule.UseTokens(cost(e.rule)) {
+			for _, used := range entries[:i] {
+				if !used.disabled {
+					used.rule.ReturnTokens(cost(used.rule))
+				}
+			}
+			blocked = i
+			break
+		}
+	}
+	if blocked < 0 {
+		remaining := make([]int, len(entries))
+		for i, e := range entries {
+			e.allowed.Add(1)
+			remaining[i] = e.rule.Remaining()
+		}
+		unlock()
+		for i, key := range keys {
+			m.publish(key, true, remaining[i], now)
+		}
+		return nil
+	}
+
+	e := entries[blocked]
+	e.denied.Add(1)
+	remaining := e.rule.Remaining()
+	m.Lock()
+	retryAfter, binding, _ := e.rule.RetryAfter(cost(e.rule), m.scheduleFor(e, now))
+	m.Unlock()
+	unlock()
+	m.publish(e.key, false, remaining, now)
+	return m.deny(e.key, &KeyedQuotaExceededError[K]{Key: e.key, Rule: binding, RetryAfter: retryAfter})
+}
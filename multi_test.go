@@ -0,0 +1,73 @@
+package main
+
+import (
+	"errors"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestUseTokensMulti(t *testing.T) {
+	m := NewManager()
+
+	m.AddRule("user1", NewRule(1, 3*time.Second))
+	m.AddRule("endpoint", NewRule(1, 5*time.Second))
+	m.AddRule("global", NewRule(1, 10*time.Second))
+
+	keys := []string{"user1", "endpoint", "global"}
+	for i := 0; i < 3; i++ {
+		if err := m.UseTokensMulti(keys); err != nil {
+			t.Fatalf("Did not expect an error on valid keys, %v", err)
+		}
+	}
+
+	var qerr *QuotaExceededError
+	if err := m.UseTokensMulti(keys); !errors.As(err, &qerr) || qerr.Key != "user1" {
+		t.Fatalf("Expected user1 to lack capacity but got %v", err)
+	}
+	for key, expected := range map[string]int{"user1": 0, "endpoint": 2, "global": 7} {
+		if remaining, _ := m.Remaining(key); remaining != expected {
+			t.Fatalf("Expected a denied use to leave %d tokens for %s but got %d", expected, key, remaining)
+		}
+	}
+
+	if err := m.UseTokensMulti([]string{"endpoint", "missing"}); !errors.Is(err, ErrRuleDoesNotExist) {
+		t.Fatalf("Expected %v for a missing rule but got %v", ErrRuleDoesNotExist, err)
+	}
+	if err := m.UseTokensMulti([]string{"endpoint", "endpoint", "endpoint"}); !errors.As(err, &qerr) || qerr.Key != "endpoint" {
+		t.Fatalf("Expected a key listed more than once to be charged each time but got %v", err)
+	}
+	if remaining, _ := m.Remaining("endpoint"); remaining != 2 {
+		t.Fatalf("Expected a denied use to leave 2 tokens but got %d", remaining)
+	}
+}
+
+func TestUseTokensMultiConcurrent(t *testing.T) {
+	m := NewManagerWithShards(4)
+	var keys []string
+	for i := 0; i < 8; i++ {
+		keys = append(keys, "user"+strconv.Itoa(i))
+		m.AddRule(keys[i], NewRule(100, 1*time.Second))
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			// overlapping sets of keys in different orders
+			set := []string{keys[i], keys[(i+3)%8], keys[(7-i+8)%8]}
+			for j := 0; j < 50; j++ {
+				m.UseTokensMulti(set)
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	for _, key := range keys {
+		if remaining, _ := m.Remaining(key); remaining < 0 || remaining > 100 {
+			t.Fatalf("Expected %s to keep between 0 and 100 tokens but got %d", key, remaining)
+		}
+	}
+}
@@ -0,0 +1,133 @@
+package main
+
+import (
+	"time"
+)
+
+// MultiRule layers several rules on a single key, such as 10 per second and 1000 per hour. A token is
+// only used if every sub-rule has one available, in which case it is used from all of them. The
+// sub-rules belong to the MultiRule and must not be added to a manager on their own.
+type MultiRule struct {
+	rules []*Rule
+}
+
+// NewMultiRule creates a rule which enforces all of the given rules at once
+func NewMultiRule(rules ...*Rule) *MultiRule {
+	return &MultiRule{rules: rules}
+}
+
+// Rules returns the sub-rules of the multi rule
+func (mr *MultiRule) Rules() []*Rule {
+	return mr.rules
+}
+
+// UseTokens uses n tokens from every sub-rule if they all have n available, counting their overdrafts
+func (mr *MultiRule) UseTokens(n int) bool {
+	for _, r := range mr.rules {
+		if !r.covers(int64(r.tokens()), int64(n)) {
+			return false
+		}
+	}
+	for _, r := range mr.rules {
+		r.Borrow(n)
+	}
+	return true
+}
+
+// Borrow uses n tokens from every sub-rule whether or not they are available
+func (mr *MultiRule) Borrow(n int) {
+	for _, r := range mr.rules {
+		r.Borrow(n)
+	}
+}
+
+// ReturnTokens gives back n tokens to every sub-rule
+func (mr *MultiRule) ReturnTokens(n int) {
+	for _, r := range mr.rules {
+		r.ReturnTokens(n)
+	}
+}
+
+// SetTokens sets the tokens available on every sub-rule, each clamped to its own maximum
+func (mr *MultiRule) SetTokens(n int) {
+	for _, r := range mr.rules {
+		r.SetTokens(n)
+	}
+}
+
+// Full returns whether refilling would add nothing to any of the sub-rules
+func (mr *MultiRule) Full() bool {
+	for _, r := range mr.rules {
+		if !r.Full() {
+			return false
+		}
+	}
+	return true
+}
+
+// Remaining returns the fewest tokens available across the sub-rules
+func (mr *MultiRule) Remaining() int {
+	if len(mr.rules) == 0 {
+		return 0
+	}
+	min := mr.rules[0].Remaining()
+	for _, r := range mr.rules[1:] {
+		if remaining := r.Remaining(); remaining < min {
+			min = remaining
+		}
+	}
+	return min
+}
+
+// Max returns the smallest maximum across the sub-rules
+func (mr *MultiRule) Max() int {
+	if len(mr.rules) == 0 {
+		return 0
+	}
+	min := mr.rules[0].Max()
+	for _, r := range mr.rules[1:] {
+		if max := r.Max(); max < min {
+			min = max
+		}
+	}
+	return min
+}
+
+// AddToken refills every sub-rule
+func (mr *MultiRule) AddToken() {
+	for _, r := range mr.rules {
+		r.AddToken()
+	}
+}
+
+// Refill brings every sub-rule up to date as of now
+func (mr *MultiRule) Refill(now time.Time) {
+	for _, r := range mr.rules {
+		r.Refill(now)
+	}
+}
+
+// SetSchedule configures every sub-rule for the manager's refill schedule
+func (mr *MultiRule) SetSchedule(sch Schedule) {
+	for _, r := range mr.rules {
+		r.SetSchedule(sch)
+	}
+}
+
+// RetryAfter returns the longest wait across the sub-rules along with the sub-rule causing it
+func (mr *MultiRule) RetryAfter(n int, sch Schedule) (time.Duration, Limiter, bool) {
+	var (
+		longest time.Duration
+		binding Limiter
+	)
+	for _, r := range mr.rules {
+		delay, _, ok := r.RetryAfter(n, sch)
+		if !ok {
+			return 0, r, false
+		}
+		if binding == nil || delay > longest {
+			longest, binding = delay, r
+		}
+	}
+	return longest, binding, true
+}
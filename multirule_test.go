@@ -0,0 +1,45 @@
+package main
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestMultiRule(t *testing.T) {
+	clock := newFakeClock()
+	m := NewManagerLazy(WithClock(clock))
+
+	fast := NewRule(2, 1*time.Second)
+	slow := NewRule(1, 3*time.Second)
+
+	user := "user1"
+	m.AddRule(user, NewMultiRule(fast, slow))
+
+	if err := m.UseTokens(user, 2); err != nil {
+		t.Fatalf("Did not expect an error on valid user, %v", err)
+	}
+	var qe *QuotaExceededError
+	if err := m.UseToken(user); !errors.As(err, &qe) || qe.Rule != fast {
+		t.Fatalf("Expected the fast rule to be the binding constraint but got %v", err)
+	}
+	if slow.tokens() != 1 {
+		t.Fatalf("Expected a denied request to leave the slow rule untouched but got %d", slow.tokens())
+	}
+
+	clock.Advance(time.Second)
+	if err := m.UseTokens(user, 2); err != nil {
+		t.Fatalf("Did not expect an error once both rules have refilled, %v", err)
+	}
+
+	clock.Advance(500 * time.Millisecond)
+	if err := m.UseToken(user); !errors.As(err, &qe) || qe.Rule != slow {
+		t.Fatalf("Expected the slow rule to be the binding constraint but got %v", err)
+	}
+	if fast.tokens() != 1 {
+		t.Fatalf("Expected a denied request to leave the fast rule untouched but got %d", fast.tokens())
+	}
+	if remaining, _ := m.Remaining(user); remaining != 0 {
+		t.Fatalf("Expected the fewest tokens across sub-rules to remain but got %d", remaining)
+	}
+}
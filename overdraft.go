@@ -0,0 +1,33 @@
+package main
+
+// WithOverdraft lets a rule use up to n tokens more than it has, driving its count as low as -n, such
+// as to absorb short spikes from well-behaved clients without raising their rate. Refills repay the
+// debt before any tokens become available again, and uses are only denied once they would take the
+// debt past n. Remaining and Peek still count only the tokens available, ignoring the overdraft.
+// Non-positive n are ignored and the rule has no overdraft.
+func WithOverdraft(n int) RuleOption {
+	return func(r *Rule) {
+		if n > 0 {
+			r.overdraft = n
+		}
+	}
+}
+
+// covers returns whether a count leaves n tokens to use, counting the overdraft
+func (r *Rule) covers(count, n int64) bool {
+	return count-n >= -int64(r.overdraft)
+}
+
+// usable returns the most tokens a single use can take from a full rule, its maximum plus any overdraft
+func usable(l Limiter) int {
+	if r, ok := l.(*Rule); ok {
+		return r.Max() + r.overdraft
+	}
+	return l.Max()
+}
+
+// Debt returns the tokens used beyond those the rule had, by its overdraft or by reservations, which
+// refills must repay before tokens are available again
+func (r *Rule) Debt() int {
+	return max(-r.tokens(), 0)
+}
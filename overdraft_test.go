@@ -0,0 +1,105 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestOverdraft(t *testing.T) {
+	h := NewHarness(t, WithUpdateRate(time.Second))
+	r := NewRule(1, 2*time.Second, WithOverdraft(2))
+	h.AddRule("user1", r)
+
+	for i := 0; i < 4; i++ {
+		h.AssertAllowed("user1")
+	}
+	if debt := r.Debt(); debt != 2 {
+		t.Fatalf("Expected a debt of 2 tokens but got %d", debt)
+	}
+	err := h.UseToken("user1")
+	var qerr *QuotaExceededError
+	if !errors.As(err, &qerr) || qerr.RetryAfter != time.Second {
+		t.Fatalf("Expected %v retrying after the next refill but got %v", ErrQuotaExceeded, err)
+	}
+	h.AssertRemaining("user1", 0)
+
+	h.Advance(2 * time.Second)
+	if debt := r.Debt(); debt != 0 {
+		t.Fatalf("Expected refills to repay the debt but got %d", debt)
+	}
+	h.AssertRemaining("user1", 0)
+	h.Advance(time.Second)
+	h.AssertRemaining("user1", 1)
+}
+
+func TestOverdraftLazy(t *testing.T) {
+	clock := newFakeClock()
+	m := NewManagerLazy(WithClock(clock))
+	m.AddRule("user1", NewRule(1, 1*time.Second, WithOverdraft(3)))
+
+	if err := m.UseTokens("user1", 4); err != nil {
+		t.Fatalf("Did not expect an error using tokens within the overdraft, %v", err)
+	}
+	if err := m.UseTokens("user1", 1); !errors.Is(err, ErrQuotaExceeded) {
+		t.Fatalf("Expected %v past the overdraft but got %v", ErrQuotaExceeded, err)
+	}
+	clock.Advance(2 * time.Second)
+	if err := m.UseTokens("user1", 2); err != nil {
+		t.Fatalf("Did not expect an error once the debt was partly repaid, %v", err)
+	}
+}
+
+func TestOverdraftJSON(t *testing.T) {
+	data, err := json.Marshal(NewRule(1, 1*time.Second, WithOverdraft(5)))
+	if err != nil {
+		t.Fatalf("Did not expect an error marshalling a rule, %v", err)
+	}
+	var r Rule
+	if err := json.Unmarshal(data, &r); err != nil {
+		t.Fatalf("Did not expect an error unmarshalling a rule, %v", err)
+	}
+	if r.overdraft != 5 || r.config().Overdraft != 5 {
+		t.Fatalf("Expected the overdraft to round trip but got %s", data)
+	}
+}
+
+func TestOverdraftDefaultRule(t *testing.T) {
+	m := NewManager()
+	m.SetDefaultRule(NewRule(1, 1*time.Second, WithOverdraft(2)))
+
+	if err := m.UseTokens("user1", 3); err != nil {
+		t.Fatalf("Did not expect an error using tokens within the default rule's overdraft, %v", err)
+	}
+	r, _ := m.GetRule("user1")
+	if debt := r.(*Rule).Debt(); debt != 2 {
+		t.Fatalf("Expected a debt of 2 tokens but got %d", debt)
+	}
+}
+
+func TestOverdraftMultiRule(t *testing.T) {
+	m := NewManager()
+	m.AddRule("user1", NewMultiRule(NewRule(1, 1*time.Second, WithOverdraft(1)), NewRule(10, 1*time.Second)))
+
+	for i := 0; i < 2; i++ {
+		if err := m.UseToken("user1"); err != nil {
+			t.Fatalf("Did not expect an error using a token within the overdraft, %v", err)
+		}
+	}
+	if err := m.UseToken("user1"); !errors.Is(err, ErrQuotaExceeded) {
+		t.Fatalf("Expected %v past the overdraft but got %v", ErrQuotaExceeded, err)
+	}
+}
+
+func TestOverdraftUseTokenFunc(t *testing.T) {
+	m := NewManager()
+	m.AddRule("user1", NewRule(1, 2*time.Second, WithOverdraft(3)))
+
+	if err := m.UseTokenFunc("user1", 6); err != ErrCostExceedsMax {
+		t.Fatalf("Expected %v past the max and overdraft but got %v", ErrCostExceedsMax, err)
+	}
+	if err := m.UseTokenFunc("user1", 5); err != nil {
+		t.Fatalf("Did not expect an error using the max and overdraft at once, %v", err)
+	}
+}
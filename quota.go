@@ -1,102 +1,1369 @@
 package main
 
 import (
+	"container/list"
+	"context"
 	"errors"
+	"fmt"
+	"hash/maphash"
+	"math"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/OneOfOne/xxhash"
 )
 
 var (
-	// UpdateRate represents the time interval to update all available tokens for each rule
+	// UpdateRate represents the default time interval to update all available tokens for each rule.
+	// Use WithUpdateRate to change the interval of a single manager.
 	UpdateRate = 1 * time.Second
 
-	// ErrRuleDoesNotExist is returned when a rule for a key string cannot be found
+	// ErrRuleDoesNotExist is returned when a rule for a key string cannot be found, wrapped in a
+	// KeyedRuleNotFoundError naming the key
 	ErrRuleDoesNotExist = errors.New("rule does not exist")
 
-	// ErrQuotaExceeded is returned when a rule has exceeded its quota
+	// ErrQuotaExceeded is wrapped by the QuotaExceededError returned when a rule has exceeded its quota
 	ErrQuotaExceeded = errors.New("rule quota exceeded")
+
+	// ErrInvalidTokenCount is returned when a non-positive number of tokens is requested
+	ErrInvalidTokenCount = errors.New("token count must be positive")
+
+	// ErrInvalidRule is wrapped by errors describing bad rule parameters
+	ErrInvalidRule = errors.New("invalid rule")
+
+	// ErrInvalidSnapshot is wrapped by errors describing a snapshot which cannot be restored
+	ErrInvalidSnapshot = errors.New("invalid snapshot")
+
+	// ErrInvalidConfig is wrapped by errors describing a rule config which cannot be loaded
+	ErrInvalidConfig = errors.New("invalid config")
+
+	// ErrBackendUnavailable is wrapped by errors returned when a fail closed backend cannot be reached
+	ErrBackendUnavailable = errors.New("backend unavailable")
+
+	// ErrClosed is returned when using tokens from a manager which has been closed
+	ErrClosed = errors.New("manager is closed")
+
+	// ErrDraining is returned when using tokens from a manager which is draining
+	ErrDraining = errors.New("manager is draining")
+
+	// ErrCostExceedsMax is returned when a request costs more tokens than its rule can ever hold
+	ErrCostExceedsMax = errors.New("cost exceeds the most tokens the rule can hold")
+
+	// ErrWaitTimeout is returned by WaitTokenTimeout when no token became available in time
+	ErrWaitTimeout = errors.New("timed out waiting for a token")
+)
+
+const (
+	// DefaultShards is the number of shards used by NewManager
+	DefaultShards = 64
+
+	// MaxTokens is the most tokens a rule can hold or add in a single refill. Rules whose window and
+	// qps would exceed it are clamped so that counts never overflow, even on 32-bit platforms.
+	MaxTokens = math.MaxInt32
 )
 
-// Manager keeps track of all the current running quota rules
-type Manager struct {
-	sync.Mutex
-	rules map[uint64]*Rule
-	hash  *xxhash.XXHash64
+// KeyedManager keeps track of all the current running quota rules for keys of a comparable type K,
+// such as a struct of tenant and route, which are used as they are rather than serialized to strings.
+// Rules are spread across shards by key hash so that operations on different keys rarely contend on
+// the same lock. When both are needed a shard is always locked before the manager.
+type KeyedManager[K comparable] struct {
+	sync.Mutex // guards the refill lifecycle and callbacks, rules are guarded by their shard
+	config
+	shards []*shard[K]
+	mask   uint64
+	hash   func(K) uint64
+	done   chan struct{} // non-nil while the refill goroutine is running
+	exited chan struct{} // closed once the refill goroutine has returned
+
+	normalizeKey func(K) K // maps keys to those stored, nil if keys are stored as is
+
+	nextRefill time.Time     // zero while the refill goroutine is not running
+	finest     time.Duration // shortest refill interval of any rule ever added, zero if none had its own
+	closed     atomic.Bool   // set by Close, after which tokens cannot be used
+	draining   atomic.Bool   // set by Drain until Resume, while tokens cannot be used
+
+	failOpenAllows atomic.Uint64 // token uses allowed because they could not be decided
+
+	globalMu sync.Mutex // guards the global limit, locked after any shard
+
+	lists atomic.Pointer[map[K]listing] // allowlisted and denylisted keys, nil if none, copied on write
+
+	onExceeded  func(key K)
+	onSoftLimit func(key K, remaining int)
+	defaultRule *Rule // template for keys used without a rule
+
+	events  *eventStream[K] // decisions published to Events, nil if not enabled
+	reclaim reclaimer       // reservations to return unless committed by their deadline
 }
 
-// NewManager returns a new quota manager
-func NewManager() *Manager {
-	return &Manager{
-		rules: make(map[uint64]*Rule),
-		hash:  xxhash.New64(),
+// Manager is a KeyedManager for string keys
+type Manager = KeyedManager[string]
+
+// config holds the settings of a manager which Options apply, whatever its key type
+type config struct {
+	lazy       bool    // accrue tokens on use instead of from a refill goroutine
+	queued     bool    // refill rules when each is due instead of sweeping them every interval
+	jitter     float64 // most a queued rule's refills are offset by, as a fraction of the interval
+	workers    int     // goroutines refilling shards in parallel, refilling serially if at most one
+	updateRate time.Duration
+	clock      Clock
+	dryRun     bool // report exceeded quotas without denying token uses, guarded by the manager
+
+	eventBuffer   int // size of the Events channel
+	publishEvents bool
+
+	backend       Backend // shared token store, nil to keep tokens in memory
+	backendPolicy FailurePolicy
+	failOpen      bool // allow token uses for missing rules or an unavailable backend
+
+	logger Logger // nil to log nothing
+	global *Rule  // limit on the tokens used across every key, nil if none
+
+	keyFunc func(string) string // normalizes string keys, nil to use them as is
+
+	pollStep    time.Duration // longest WaitToken sleeps between attempts, until a refill if zero
+	pollBackoff bool          // double the poll step after each attempt, up to the update interval
+
+	idleTTL       time.Duration // rules unused for this long are evicted, never if zero
+	sweepInterval time.Duration
+
+	compactAfter int // removals from a shard before compacting it, never if zero
+}
+
+// hashKey maps a string key to the hash its rule is stored under
+var hashKey = xxhash.ChecksumString64
+
+// keyHasher returns the hash function for keys of type K. String keys are hashed by hashKey and other
+// keys by maphash with a seed of their own.
+func keyHasher[K comparable]() func(K) uint64 {
+	if hash, ok := any(func(key string) uint64 { return hashKey(key) }).(func(K) uint64); ok {
+		return hash
+	}
+	seed := maphash.MakeSeed()
+	return func(key K) uint64 {
+		return maphash.Comparable(seed, key)
 	}
 }
 
-// AddRule adds a new quota rule for a specified string key
-func (m *Manager) AddRule(key string, r *Rule) {
+// shard holds the subset of rules whose key hash falls into it. Lookups which change nothing, not even
+// a rule's refill or an entry's recency, only need the read lock.
+type shard[K comparable] struct {
+	sync.RWMutex
+	rules  map[uint64]*entry[K]
+	active map[*entry[K]]struct{} // entries which may not be full, the only ones the sweep refills
+	peak   int                    // most active entries since the active map was last rebuilt
+	queue  *refillQueue[K]        // entries which may not be full by refill time, replacing active if set
+
+	lru      *list.List // entries from most to least recently used, nil if the shard is unbounded
+	capacity int
+
+	rulesPeak int // most hashes in the rule map since it was last compacted
+	removals  int // rules removed since the rule map was last compacted
+}
+
+// entry pairs a rule with the original key it was added under and the usage tracked for the key. Keys
+// whose hashes collide are chained together so that they never share a rule.
+type entry[K comparable] struct {
+	key     K
+	hash    uint64
+	rule    Limiter
+	allowed atomic.Uint64
+	denied  atomic.Uint64
+	next    *entry[K]
+
+	parent    K // key of the rule tokens are also used from when hasParent is set
+	hasParent bool
+
+	lastAccess time.Time     // time the rule was last added or used, for evicting idle rules
+	elem       *list.Element // position in the shard's LRU list if it has one
+	disabled   bool          // allow every token use without using tokens from the rule
+
+	due   time.Time // time of the next refill while in the shard's refill queue, zero otherwise
+	index int       // position in the shard's refill queue
+}
+
+// entry looks up the entry for a key and its hash
+func (s *shard[K]) entry(h uint64, key K) *entry[K] {
+	for e := s.rules[h]; e != nil; e = e.next {
+		if e.key == key {
+			return e
+		}
+	}
+	return nil
+}
+
+// rule looks up the rule for a key and its hash
+func (s *shard[K]) rule(h uint64, key K) (Limiter, bool) {
+	if e := s.entry(h, key); e != nil {
+		return e.rule, true
+	}
+	return nil, false
+}
+
+// set adds or replaces the rule for a key and its hash, returning its entry and the entry evicted to
+// make room for it if any. Replacing a rule keeps the key's usage.
+func (s *shard[K]) set(h uint64, key K, r Limiter) (e, victim *entry[K]) {
+	if e := s.entry(h, key); e != nil {
+		e.rule = r
+		return e, nil
+	}
+	e = &entry[K]{key: key, hash: h, rule: r, next: s.rules[h]}
+	s.rules[h] = e
+	if len(s.rules) > s.rulesPeak {
+		s.rulesPeak = len(s.rules)
+	}
+	if s.lru != nil {
+		e.elem = s.lru.PushFront(e)
+		if s.lru.Len() > s.capacity {
+			victim = s.lru.Back().Value.(*entry[K])
+			s.remove(victim.hash, victim.key)
+		}
+	}
+	return e, victim
+}
+
+// touch records that an entry was used at now, so it is refilled by the sweep and recently used for
+// eviction
+func (s *shard[K]) touch(e *entry[K], now time.Time) {
+	e.lastAccess = now
+	s.activate(e, now)
+	if e.elem != nil {
+		s.lru.MoveToFront(e.elem)
+	}
+}
+
+// activate marks an entry as possibly not full at now so that the sweep refills it
+func (s *shard[K]) activate(e *entry[K], now time.Time) {
+	if s.queue != nil {
+		s.queue.schedule(e, now)
+		return
+	}
+	s.active[e] = struct{}{}
+	if len(s.active) > s.peak {
+		s.peak = len(s.active)
+	}
+}
+
+// refilling returns whether an entry is refilled while the manager runs, as it is until it is full
+func (s *shard[K]) refilling(e *entry[K]) bool {
+	if s.queue != nil {
+		return !e.due.IsZero()
+	}
+	_, ok := s.active[e]
+	return ok
+}
+
+// evict stops tracking the recency and refills of an entry being removed
+func (s *shard[K]) evict(e *entry[K]) {
+	s.removals++
+	if e.elem != nil {
+		s.lru.Remove(e.elem)
+	}
+	delete(s.active, e)
+	if s.queue != nil {
+		s.queue.unschedule(e)
+	}
+}
+
+// remove deletes the rule for a key and its hash and returns whether it existed
+func (s *shard[K]) remove(h uint64, key K) bool {
+	var prev *entry[K]
+	for e := s.rules[h]; e != nil; prev, e = e, e.next {
+		if e.key != key {
+			continue
+		}
+		s.evict(e)
+		switch {
+		case prev != nil:
+			prev.next = e.next
+		case e.next != nil:
+			s.rules[h] = e.next
+		default:
+			delete(s.rules, h)
+		}
+		return true
+	}
+	return false
+}
+
+// Option configures a manager of any key type at construction
+type Option func(*config)
+
+// WithUpdateRate sets the time interval at which the manager refills its rules. Non-positive
+// intervals are ignored and the manager uses UpdateRate.
+func WithUpdateRate(d time.Duration) Option {
+	return func(c *config) {
+		if d > 0 {
+			c.updateRate = d
+		}
+	}
+}
+
+// NewManager returns a new quota manager for string keys with DefaultShards shards
+func NewManager(opts ...Option) *Manager {
+	return NewKeyedManager[string](opts...)
+}
+
+// NewManagerWithShards returns a new quota manager for string keys with n shards. n is rounded up to
+// the next power of two and a non-positive n results in a single shard.
+func NewManagerWithShards(n int, opts ...Option) *Manager {
+	return NewKeyedManagerWithShards[string](n, opts...)
+}
+
+// NewManagerWithCapacity returns a new quota manager for string keys holding at most n rules, as
+// described by NewKeyedManagerWithCapacity
+func NewManagerWithCapacity(n int, opts ...Option) *Manager {
+	return NewKeyedManagerWithCapacity[string](n, opts...)
+}
+
+// NewManagerLazy returns a new quota manager for string keys which accrues tokens for a rule whenever
+// the rule is used, as described by NewKeyedManagerLazy
+func NewManagerLazy(opts ...Option) *Manager {
+	return NewKeyedManagerLazy[string](opts...)
+}
+
+// NewKeyedManager returns a new quota manager for keys of type K with DefaultShards shards
+func NewKeyedManager[K comparable](opts ...Option) *KeyedManager[K] {
+	return NewKeyedManagerWithShards[K](DefaultShards, opts...)
+}
+
+// NewKeyedManagerWithShards returns a new quota manager for keys of type K with n shards. n is rounded
+// up to the next power of two and a non-positive n results in a single shard.
+func NewKeyedManagerWithShards[K comparable](n int, opts ...Option) *KeyedManager[K] {
+	size := 1
+	for size < n {
+		size <<= 1
+	}
+	shards := make([]*shard[K], size)
+	for i := range shards {
+		shards[i] = &shard[K]{rules: make(map[uint64]*entry[K]), active: make(map[*entry[K]]struct{})}
+	}
+	m := &KeyedManager[K]{
+		config: config{
+			updateRate: UpdateRate,
+			clock:      realClock{},
+		},
+		shards: shards,
+		mask:   uint64(size - 1),
+		hash:   keyHasher[K](),
+	}
+	for _, opt := range opts {
+		opt(&m.config)
+	}
+	if normalize, ok := any(m.keyFunc).(func(K) K); ok && m.keyFunc != nil {
+		m.normalizeKey = normalize
+	}
+	if m.queued {
+		for _, s := range shards {
+			s.queue = &refillQueue[K]{interval: m.updateRate, jitter: time.Duration(m.jitter * float64(m.updateRate))}
+		}
+	}
+	if m.global != nil {
+		m.global.SetSchedule(Schedule{Now: m.clock.Now(), Lazy: true, UpdateRate: m.updateRate})
+	}
+	if m.publishEvents {
+		m.events = &eventStream[K]{ch: make(chan KeyedEvent[K], m.eventBuffer)}
+	}
+	return m
+}
+
+// minShardCapacity is the fewest rules each shard of a manager created by NewKeyedManagerWithCapacity
+// holds, so that small capacities are not spread too thinly to track recency usefully
+const minShardCapacity = 16
+
+// NewKeyedManagerWithCapacity returns a new quota manager for keys of type K holding at most n rules.
+// Adding a rule for a new key to a full manager evicts the least recently used rule, after which the
+// evicted key has no rule or is given a fresh one from the default rule. Recency is tracked per shard,
+// so n is divided among the shards and the rule evicted is the least recently used of the shard being
+// added to. A non-positive n is unbounded.
+func NewKeyedManagerWithCapacity[K comparable](n int, opts ...Option) *KeyedManager[K] {
+	if n <= 0 {
+		return NewKeyedManager[K](opts...)
+	}
+	size := DefaultShards
+	for size > 1 && n/size < minShardCapacity {
+		size >>= 1
+	}
+	m := NewKeyedManagerWithShards[K](size, opts...)
+	for i, s := range m.shards {
+		s.lru = list.New()
+		s.capacity = n / size
+		if i < n%size {
+			s.capacity++
+		}
+	}
+	return m
+}
+
+// NewKeyedManagerLazy returns a new quota manager for keys of type K which accrues tokens for a rule
+// whenever the rule is used, based on the time elapsed since it was last used. No refill goroutine is
+// needed so Run is a no-op, which avoids sweeping every rule each update interval when most rules are
+// idle.
+func NewKeyedManagerLazy[K comparable](opts ...Option) *KeyedManager[K] {
+	m := NewKeyedManager[K](opts...)
+	m.lazy = true
+	return m
+}
+
+// shard returns the shard responsible for a key hash
+func (m *KeyedManager[K]) shard(h uint64) *shard[K] {
+	return m.shards[h&m.mask]
+}
+
+// AddRule adds a new quota rule, such as a *Rule or *MultiRule, for a specified key. The rule
+// is refilled at the manager's update interval regardless of the UpdateRate it was created with. Any
+// link to a parent rule made by AddChildRule is removed.
+func (m *KeyedManager[K]) AddRule(key K, r Limiter) {
+	key = m.normalize(key)
+	h := m.hash(key)
+	s := m.shard(h)
+	s.Lock()
+	m.add(s, h, key, r).hasParent = false
+	s.Unlock()
+}
+
+// GetOrCreateRule looks up the current rule for a specified key, adding the rule returned by
+// factory if there is none. The factory is only called when a rule is created, and whether one was
+// created is returned. Concurrent callers for the same key all receive the same rule.
+func (m *KeyedManager[K]) GetOrCreateRule(key K, factory func() Limiter) (Limiter, bool) {
+	key = m.normalize(key)
+	h := m.hash(key)
+	s := m.shard(h)
+	s.Lock()
+	if r, exists := s.rule(h, key); exists {
+		s.Unlock()
+		return r, false
+	}
+	r := factory()
+	m.add(s, h, key, r)
+	s.Unlock()
+	return r, true
+}
+
+// add configures a rule for the manager's refill schedule and sets it as the rule for a key. The shard
+// must be locked.
+func (m *KeyedManager[K]) add(s *shard[K], h uint64, key K, r Limiter) *entry[K] {
 	m.Lock()
-	m.hash.WriteString(key)
-	m.rules[m.hash.Sum64()] = r
-	m.hash.Reset()
+	now := m.clock.Now()
+	r.SetSchedule(m.schedule(now))
+	if every := refillInterval(r, 0); every > 0 && (m.finest == 0 || every < m.finest) {
+		m.finest = every
+	}
 	m.Unlock()
+	e, victim := s.set(h, key, r)
+	s.touch(e, now)
+	if victim != nil {
+		m.removed(s)
+	}
+	if m.logger != nil {
+		m.logger.Infof("added rule for key %s: %v", formatKey(key), r)
+		if victim != nil {
+			m.logger.Infof("evicted least recently used rule for key %s", formatKey(victim.key))
+		}
+	}
+	return e
 }
 
-// GetRule looks up the current rule for a specified string key
-func (m *Manager) GetRule(key string) (*Rule, error) {
-	m.Lock()
-	m.hash.WriteString(key)
-	r, exists := m.rules[m.hash.Sum64()]
+// GetRule looks up the current rule for a specified key
+func (m *KeyedManager[K]) GetRule(key K) (Limiter, error) {
+	key = m.normalize(key)
+	h := m.hash(key)
+	s := m.shard(h)
+	if !m.tracksAccess(s) {
+		s.RLock()
+		r, exists := s.rule(h, key)
+		s.RUnlock()
+		if !exists {
+			return nil, &KeyedRuleNotFoundError[K]{Key: key}
+		}
+		return r, nil
+	}
+	s.Lock()
+	e := s.entry(h, key)
+	if e == nil {
+		s.Unlock()
+		return nil, &KeyedRuleNotFoundError[K]{Key: key}
+	}
+	s.touch(e, m.clock.Now())
+	s.Unlock()
+	return e.rule, nil
+}
+
+// Has reports whether a rule is registered for a specified key. Unlike GetRule it does not count as a
+// use of the key, so it never keeps an idle rule from being evicted.
+func (m *KeyedManager[K]) Has(key K) bool {
+	key = m.normalize(key)
+	h := m.hash(key)
+	s := m.shard(h)
+	s.RLock()
+	e := s.entry(h, key)
+	s.RUnlock()
+	return e != nil
+}
+
+// Remaining returns the number of tokens currently available for a specified key without
+// using any of them. Tokens held by reservations are not available.
+func (m *KeyedManager[K]) Remaining(key K) (int, error) {
+	count, _, err := m.remaining(key)
+	return count, err
+}
+
+// RemainingFraction returns the fraction of a specified key's maximum tokens currently available,
+// from 0 when none are left to 1 when it is full, such as to render a gauge. A rule without any
+// tokens at all, such as one with a qps of 0, has 0 remaining.
+func (m *KeyedManager[K]) RemainingFraction(key K) (float64, error) {
+	count, max, err := m.remaining(key)
+	if err != nil {
+		return 0, err
+	}
+	if max <= 0 {
+		return 0, nil
+	}
+	return math.Min(math.Max(float64(count)/float64(max), 0), 1), nil
+}
+
+// UsageFraction returns the fraction of a specified key's maximum tokens currently used, from 0 when
+// it is full to 1 when none are left, the inverse of RemainingFraction
+func (m *KeyedManager[K]) UsageFraction(key K) (float64, error) {
+	remaining, err := m.RemainingFraction(key)
+	if err != nil {
+		return 0, err
+	}
+	return 1 - remaining, nil
+}
+
+// remaining returns the tokens currently available for a key along with its maximum, both read under
+// the shard's lock
+func (m *KeyedManager[K]) remaining(key K) (int, int, error) {
+	key = m.normalize(key)
+	h := m.hash(key)
+	s := m.shard(h)
+	s.RLock()
+	r, exists := s.rule(h, key)
 	if !exists {
-		m.hash.Reset()
-		m.Unlock()
-		return nil, ErrRuleDoesNotExist
+		s.RUnlock()
+		return 0, 0, &KeyedRuleNotFoundError[K]{Key: key}
 	}
-	m.hash.Reset()
-	m.Unlock()
+	if upToDate(r) {
+		count, max := r.Remaining(), r.Max()
+		s.RUnlock()
+		return count, max, nil
+	}
+	s.RUnlock()
+
+	s.Lock()
+	r, exists = s.rule(h, key)
+	if !exists {
+		s.Unlock()
+		return 0, 0, &KeyedRuleNotFoundError[K]{Key: key}
+	}
+	r.Refill(m.clock.Now())
+	count, max := r.Remaining(), r.Max()
+	s.Unlock()
+	return count, max, nil
+}
+
+// Peek returns whether at least one token is currently available for a specified key without using
+// it, as a check before using a token. Like Remaining, tokens held by reservations are not available.
+func (m *KeyedManager[K]) Peek(key K) (bool, error) {
+	remaining, err := m.Remaining(key)
+	return remaining > 0, err
+}
+
+// tracksAccess returns whether looking up a rule in a shard must record the access, for evicting idle
+// or least recently used rules
+func (m *KeyedManager[K]) tracksAccess(s *shard[K]) bool {
+	return s.lru != nil || m.idleTTL > 0
+}
+
+// upToDate returns whether a limiter is already up to date without calling Refill, which holds
+// for a *Rule refilled by a running manager and allows it to be read with only a read lock
+func upToDate(l Limiter) bool {
+	r, ok := l.(*Rule)
+	return ok && !r.lazy && !r.rollover
+}
+
+// UpdateRule replaces the quota rule for a specified key without leaving a gap where the key
+// has no rule. When both are a *Rule, the fraction of tokens available on the existing rule is carried
+// over to the new rule.
+func (m *KeyedManager[K]) UpdateRule(key K, r Limiter) error {
+	key = m.normalize(key)
+	h := m.hash(key)
+	s := m.shard(h)
+	s.Lock()
+	old, exists := s.rule(h, key)
+	if !exists {
+		s.Unlock()
+		return &KeyedRuleNotFoundError[K]{Key: key}
+	}
+	oldRule, oldOK := old.(*Rule)
+	newRule, newOK := r.(*Rule)
+	if oldOK && newOK {
+		if oldMax := oldRule.Max(); oldMax > 0 {
+			newRule.setCount(int(float64(oldRule.tokens()) / float64(oldMax) * float64(newRule.Max())))
+		}
+		if max := newRule.Max(); newRule.tokens() > max {
+			newRule.setCount(max)
+		}
+	}
+	m.add(s, h, key, r)
+	s.Unlock()
+	return nil
+}
+
+// SetQPS changes the qps of the *Rule for a specified key in place, such as for live tuning, and
+// returns the adjusted rule. The fraction of tokens available is kept and a burst which defaulted to
+// the window's worth of queries follows the new qps, while other settings are unchanged. An error
+// wrapping ErrInvalidRule is returned if the qps does not make a usable rule or the key's rule is not
+// a *Rule.
+func (m *KeyedManager[K]) SetQPS(key K, qps int) (*Rule, error) {
+	return m.resize(key, func(r *Rule) (float64, time.Duration) {
+		return float64(qps), r.window
+	})
+}
+
+// SetWindow changes the window of the *Rule for a specified key in place as described by SetQPS
+func (m *KeyedManager[K]) SetWindow(key K, window time.Duration) (*Rule, error) {
+	return m.resize(key, func(r *Rule) (float64, time.Duration) {
+		return r.rate, window
+	})
+}
+
+// resize changes the rate and window of the *Rule for a key to those returned by params
+func (m *KeyedManager[K]) resize(key K, params func(r *Rule) (float64, time.Duration)) (*Rule, error) {
+	key = m.normalize(key)
+	h := m.hash(key)
+	s := m.shard(h)
+	s.Lock()
+	e := s.entry(h, key)
+	if e == nil {
+		s.Unlock()
+		return nil, &KeyedRuleNotFoundError[K]{Key: key}
+	}
+	r, ok := e.rule.(*Rule)
+	if !ok {
+		s.Unlock()
+		return nil, fmt.Errorf("%w: %T cannot be resized", ErrInvalidRule, e.rule)
+	}
+	rate, window := params(r)
+	if err := checkRule(rate, window); err != nil {
+		s.Unlock()
+		return nil, err
+	}
+	now := m.clock.Now()
+	r.Refill(now)
+	r.resize(rate, window, m.updateRate)
+	s.activate(e, now)
+	s.Unlock()
 	return r, nil
 }
 
-// Run starts the quota manager periodically updating the tracked quotas
-func (m *Manager) Run() {
-	ticker := time.NewTicker(UpdateRate)
+// RemoveRule deletes the quota rule for a specified key
+func (m *KeyedManager[K]) RemoveRule(key K) error {
+	key = m.normalize(key)
+	h := m.hash(key)
+	s := m.shard(h)
+	s.Lock()
+	if !s.remove(h, key) {
+		s.Unlock()
+		return &KeyedRuleNotFoundError[K]{Key: key}
+	}
+	m.removed(s)
+	s.Unlock()
+	if m.logger != nil {
+		m.logger.Infof("removed rule for key %s", formatKey(key))
+	}
+	return nil
+}
+
+// AddTokens gives n extra tokens to the rule for a specified key, such as a one-time boost for
+// a customer, without exceeding the most the rule can hold. Like SetTokens it changes the tokens
+// directly rather than through a refill, so the rule's refill schedule is unaffected.
+func (m *KeyedManager[K]) AddTokens(key K, n int) error {
+	if n <= 0 {
+		return ErrInvalidTokenCount
+	}
+	return m.ReturnTokens(key, n)
+}
+
+// SetTokens sets the tokens available on the rule for a specified key, clamped to between zero
+// and the most the rule can hold. It overrides whatever the rule has used or refilled, and the rule
+// continues to refill as normal from the new count.
+func (m *KeyedManager[K]) SetTokens(key K, n int) error {
+	key = m.normalize(key)
+	h := m.hash(key)
+	s := m.shard(h)
+	s.Lock()
+	e := s.entry(h, key)
+	if e == nil {
+		s.Unlock()
+		return &KeyedRuleNotFoundError[K]{Key: key}
+	}
+	now := m.clock.Now()
+	e.rule.Refill(now)
+	e.rule.SetTokens(n)
+	s.activate(e, now)
+	s.Unlock()
+	return nil
+}
+
+// Reset refills the rule for a specified key to the most it can hold, such as for an operational
+// reset. Unlike SetTokens it always means full, whatever the rule can hold.
+func (m *KeyedManager[K]) Reset(key K) error {
+	key = m.normalize(key)
+	h := m.hash(key)
+	s := m.shard(h)
+	s.Lock()
+	e := s.entry(h, key)
+	if e == nil {
+		s.Unlock()
+		return &KeyedRuleNotFoundError[K]{Key: key}
+	}
+	e.rule.Refill(m.clock.Now())
+	e.rule.SetTokens(e.rule.Max())
+	s.Unlock()
+	return nil
+}
+
+// ResetAll refills every rule to the most it can hold as described by Reset, locking one shard at a
+// time
+func (m *KeyedManager[K]) ResetAll() {
+	now := m.clock.Now()
+	for _, s := range m.shards {
+		s.Lock()
+		for _, e := range s.rules {
+			for ; e != nil; e = e.next {
+				e.rule.Refill(now)
+				e.rule.SetTokens(e.rule.Max())
+			}
+		}
+		s.Unlock()
+	}
+}
+
+// Disable stops enforcing the rule for a specified key without removing it, such as during an
+// incident. Every token use for the key succeeds without using tokens until the key is enabled again,
+// and its stats continue to count them as allowed.
+func (m *KeyedManager[K]) Disable(key K) error {
+	return m.setDisabled(key, true)
+}
+
+// Enable resumes enforcing the rule for a specified key after Disable, starting from the
+// tokens the rule had when it was disabled plus any refilled since
+func (m *KeyedManager[K]) Enable(key K) error {
+	return m.setDisabled(key, false)
+}
+
+// setDisabled sets whether the rule for a key is enforced
+func (m *KeyedManager[K]) setDisabled(key K, disabled bool) error {
+	key = m.normalize(key)
+	h := m.hash(key)
+	s := m.shard(h)
+	s.Lock()
+	e := s.entry(h, key)
+	if e == nil {
+		s.Unlock()
+		return &KeyedRuleNotFoundError[K]{Key: key}
+	}
+	e.disabled = disabled
+	s.Unlock()
+	return nil
+}
+
+// Keys returns the string keys of all registered rules in no particular order
+func (m *KeyedManager[K]) Keys() []K {
+	var keys []K
+	m.Range(func(key K, r Limiter) bool {
+		keys = append(keys, key)
+		return true
+	})
+	return keys
+}
+
+// Len returns the number of registered rules
+func (m *KeyedManager[K]) Len() int {
+	n := 0
+	for _, s := range m.shards {
+		s.RLock()
+		for _, e := range s.rules {
+			for ; e != nil; e = e.next {
+				n++
+			}
+		}
+		s.RUnlock()
+	}
+	return n
+}
+
+// Clear removes every registered rule. The manager keeps running and rules may be added again
+// afterwards. Each shard is cleared in turn, so rules added concurrently may survive.
+func (m *KeyedManager[K]) Clear() {
+	for _, s := range m.shards {
+		s.Lock()
+		s.rules, s.rulesPeak, s.removals = make(map[uint64]*entry[K]), 0, 0
+		s.active, s.peak = make(map[*entry[K]]struct{}), 0
+		if s.queue != nil {
+			s.queue.entries = nil
+		}
+		if s.lru != nil {
+			s.lru.Init()
+		}
+		s.Unlock()
+	}
+}
+
+// Range calls fn for every registered rule until fn returns false. Each shard is locked while its
+// rules are visited, so fn must not call back into the manager. Rules added or removed during Range
+// may or may not be visited.
+func (m *KeyedManager[K]) Range(fn func(key K, r Limiter) bool) {
+	for _, s := range m.shards {
+		s.RLock()
+		for _, e := range s.rules {
+			for ; e != nil; e = e.next {
+				if !fn(e.key, e.rule) {
+					s.RUnlock()
+					return
+				}
+			}
+		}
+		s.RUnlock()
+	}
+}
+
+// SetDefaultRule sets a template rule for keys which have no rule of their own. The first time such a
+// key uses a token it is given its own rule with the template's qps, window, burst, cost, rollover,
+// alignment, soft limit, warmup, refill interval and overdraft, so each key is limited independently
+// rather than sharing one quota. Rules created this way stay registered like any other until removed.
+// A nil rule restores returning ErrRuleDoesNotExist for unknown keys.
+func (m *KeyedManager[K]) SetDefaultRule(r *Rule) {
+	m.Lock()
+	m.defaultRule = r
+	m.Unlock()
+}
+
+// entryForUse looks up the entry for a key that tokens are being used from, creating it from the
+// default rule if there is one. The shard must be locked.
+func (m *KeyedManager[K]) entryForUse(s *shard[K], h uint64, key K) *entry[K] {
+	if e := s.entry(h, key); e != nil {
+		return e
+	}
+	m.Lock()
+	tmpl := m.defaultRule
+	m.Unlock()
+	if tmpl == nil {
+		return nil
+	}
+	opts := []RuleOption{WithCost(tmpl.cost), WithCostFunc(tmpl.costFunc)}
+	if tmpl.rollover {
+		opts = append(opts, WithRollover(tmpl.maxCarry))
+	}
+	if tmpl.align != nil {
+		opts = append(opts, withAlignment(tmpl.align))
+	}
+	opts = append(opts, WithSoftLimit(tmpl.softLimit), WithWarmup(tmpl.warmup), WithRefillInterval(tmpl.every))
+	opts = append(opts, withBurst(tmpl.burst), WithOverdraft(tmpl.overdraft))
+	r := NewRuleRate(tmpl.rate, tmpl.window, opts...)
+	return m.add(s, h, key, r)
+}
+
+// SetOnExceeded registers a callback invoked with the original key whenever a token use is
+// denied because the rule's quota was exceeded. Registering a new callback replaces the previous one
+// and a nil callback disables it. The callback is invoked outside the manager's lock from whichever
+// goroutine made the denied request, so it may run concurrently and must be safe for concurrent use.
+func (m *KeyedManager[K]) SetOnExceeded(fn func(key K)) {
+	m.Lock()
+	m.onExceeded = fn
+	m.Unlock()
+}
+
+// WithDryRun sets whether a manager starts in dry run mode, as set by SetDryRun
+func WithDryRun(enabled bool) Option {
+	return func(c *config) {
+		c.dryRun = enabled
+	}
+}
+
+// SetDryRun sets whether the manager is in dry run mode, which can be changed while it is in use. In
+// dry run mode token uses which exceed their quota still count as denied and invoke the OnExceeded
+// callback, but return nil rather than an error and use no tokens. This allows limits to be sized
+// against real traffic before they are enforced. Errors other than an exceeded quota, such as
+// ErrRuleDoesNotExist, are still returned.
+func (m *KeyedManager[K]) SetDryRun(enabled bool) {
+	m.Lock()
+	m.dryRun = enabled
+	m.Unlock()
+}
+
+// Run starts the quota manager periodically updating the tracked quotas and evicting idle rules if
+// WithIdleTTL is set. Calling Run on a manager that is already running or closed is a no-op, as is
+// calling it on a manager that refills lazily and evicts nothing.
+func (m *KeyedManager[K]) Run() {
+	if m.lazy && m.idleTTL <= 0 {
+		return
+	}
+	m.Lock()
+	if m.done != nil || m.closed.Load() {
+		m.Unlock()
+		return
+	}
+	done, exited := make(chan struct{}), make(chan struct{})
+	m.done, m.exited = done, exited
+	if !m.lazy {
+		m.nextRefill = m.clock.Now().Add(m.updateRate)
+	}
+	m.Unlock()
+
+	// a nil channel is never selected, so only the enabled tickers fire
+	var refill, sweep <-chan time.Time
+	var tickers []Ticker
+	var timer Timer // schedules the next refill of queued rules
+	switch {
+	case m.lazy:
+	case m.queued:
+		timer = m.clock.NewTimer(m.queueWait())
+		refill = timer.C()
+	default:
+		ticker := m.clock.NewTicker(m.updateRate)
+		tickers = append(tickers, ticker)
+		refill = ticker.C()
+	}
+	if m.idleTTL > 0 {
+		ticker := m.clock.NewTicker(m.sweepInterval)
+		tickers = append(tickers, ticker)
+		sweep = ticker.C()
+	}
 	go func() {
+		defer func() {
+			for _, ticker := range tickers {
+				ticker.Stop()
+			}
+			if timer != nil {
+				timer.Stop()
+			}
+			close(exited)
+		}()
 		for {
 			select {
-			case <-ticker.C:
-				m.addTokens()
+			case <-refill:
+				m.reclaim.expire(m.clock.Now())
+				if timer == nil {
+					m.addTokens()
+					continue
+				}
+				timer = m.clock.NewTimer(m.refillQueued())
+				refill = timer.C()
+			case <-sweep:
+				m.evictIdle()
+			case <-done:
+				return
 			}
 		}
 	}()
 }
 
-// UseToken tries to use a token for a given string key and returns nil if used
-func (m *Manager) UseToken(key string) error {
+// RunContext runs the quota manager as Run does and blocks until ctx is done, at which point the
+// manager is stopped and ctx's error returned. This suits shutdown patterns such as errgroup. It
+// returns nil early if the manager is stopped by Stop, unless it refills lazily and evicts nothing,
+// in which case it only waits for ctx.
+func (m *KeyedManager[K]) RunContext(ctx context.Context) error {
+	m.Run()
 	m.Lock()
-	m.hash.WriteString(key)
-	r, exists := m.rules[m.hash.Sum64()]
-	if !exists {
-		m.hash.Reset()
-		m.Unlock()
-		return ErrRuleDoesNotExist
+	done := m.done
+	m.Unlock()
+
+	select {
+	case <-ctx.Done():
+		m.Stop()
+		return ctx.Err()
+	case <-done:
+		return nil
 	}
-	used := r.useToken()
-	if !used {
-		m.hash.Reset()
-		m.Unlock()
-		return ErrQuotaExceeded
+}
+
+// Stop halts the periodic token refill started by Run, waiting for it to finish, and closes the Events
+// channel. Rules keep their last known token counts so UseToken continues to work. Calling Stop more
+// than once is safe.
+func (m *KeyedManager[K]) Stop() {
+	m.Lock()
+	exited := m.exited
+	if m.done != nil {
+		close(m.done)
+		m.done, m.exited = nil, nil
+		m.nextRefill = time.Time{}
+	}
+	m.Unlock()
+	if exited != nil {
+		<-exited
+	}
+	if m.events != nil {
+		m.events.close()
+	}
+}
+
+// Close stops the manager as Stop does and implements io.Closer, so that it may be closed like other
+// resources. Afterwards Run is a no-op and using tokens returns ErrClosed, while token uses already in
+// flight complete as normal. Close is safe to call more than once and always returns nil.
+func (m *KeyedManager[K]) Close() error {
+	m.Lock()
+	m.closed.Store(true)
+	m.Unlock()
+	m.Stop()
+	return nil
+}
+
+// UseToken tries to use a token for a given key and returns nil if used. Rules with a cost set by
+// WithCost use that many tokens instead. ErrClosed is returned once the manager is closed.
+func (m *KeyedManager[K]) UseToken(key K) error {
+	_, _, err := m.useTokens(key, 0)
+	return m.allowOnFailure(err)
+}
+
+// charge is the tokens a use took in memory, so that exactly those can be given back
+type charge[K comparable] struct {
+	key    K
+	chain  []K  // every key charged when the use went through AddChildRule ancestors, in place of key
+	n      int  // tokens taken from each rule, zero if none were
+	global bool // whether n tokens were also taken from the global limit
+}
+
+// refund gives back the tokens of a charge to every rule they were taken from, skipping rules
+// which have since been removed
+func (m *KeyedManager[K]) refund(c charge[K]) {
+	if c.n == 0 {
+		return
+	}
+	if c.chain == nil {
+		m.ReturnTokens(c.key, c.n)
+	}
+	for _, key := range c.chain {
+		m.ReturnTokens(key, c.n)
+	}
+	if c.global {
+		m.globalMu.Lock()
+		m.global.Refill(m.clock.Now())
+		m.global.ReturnTokens(c.n)
+		m.globalMu.Unlock()
+	}
+}
+
+// UseTokenWithRemaining tries to use a token for a given key like UseToken and returns the tokens left
+// on its rule, read under the same lock as the use, such as to set rate limit headers without a
+// separate call to Remaining. Denied uses return 0 remaining along with the error UseToken would. The
+// tokens left are unknown, and -1 is returned, for allowlisted keys, rules kept by a backend and uses
+// allowed by failing open.
+func (m *KeyedManager[K]) UseTokenWithRemaining(key K) (int, error) {
+	remaining, _, err := m.useToken(key)
+	return remaining, err
+}
+
+// useToken uses a token for a key like UseTokenWithRemaining, also returning the tokens it took,
+// which are none for uses allowed without taking any such as in dry run mode
+func (m *KeyedManager[K]) useToken(key K) (remaining int, c charge[K], err error) {
+	remaining, c, err = m.useTokens(key, 0)
+	if err != nil {
+		if err = m.allowOnFailure(err); err != nil {
+			return 0, charge[K]{}, err
+		}
+		return -1, charge[K]{}, nil
+	}
+	return remaining, c, nil
+}
+
+// UseTokens tries to use n tokens for a given key and returns nil if used. Either all n
+// tokens are used or none are.
+func (m *KeyedManager[K]) UseTokens(key K, n int) error {
+	if n <= 0 {
+		return ErrInvalidTokenCount
+	}
+	_, _, err := m.useTokens(key, n)
+	return m.allowOnFailure(err)
+}
+
+// UseTokenCost tries to use cost tokens for a given string key, such as to charge expensive requests
+// more, and returns nil if used. Either all tokens are used or none are, so a cost above the most
+// tokens the rule can hold, plus any overdraft, never succeeds. A cost of zero is a use which is counted but not charged,
+// such as for a response served from cache, and is handled by Observe.
+func (m *KeyedManager[K]) UseTokenCost(key K, cost int) error {
+	if cost == 0 {
+		return m.Observe(key)
+	}
+	return m.UseTokens(key, cost)
+}
+
+// Observe records a use for a given key without using any tokens, so that stats and events reflect
+// traffic which is not charged to the quota. Since it needs no tokens it is never denied by the key's
+// rule, even one which has exceeded its quota, and counts as allowed. Denylisted keys are still
+// denied, and the errors of UseToken for missing rules or a closed or draining manager are returned.
+func (m *KeyedManager[K]) Observe(key K) error {
+	key = m.normalize(key)
+	if err := m.admitting(); err != nil {
+		return err
+	}
+	if l, ok := m.listed(key); ok {
+		if l == allowed {
+			return nil
+		}
+		return &KeyedQuotaExceededError[K]{Key: key}
+	}
+	h := m.hash(key)
+	s := m.shard(h)
+	s.Lock()
+	e := m.entryForUse(s, h, key)
+	if e == nil {
+		s.Unlock()
+		return m.allowOnFailure(&KeyedRuleNotFoundError[K]{Key: key})
+	}
+	now := m.clock.Now()
+	s.touch(e, now)
+	e.rule.Refill(now)
+	e.allowed.Add(1)
+	remaining := e.rule.Remaining()
+	s.Unlock()
+	m.publish(key, true, remaining, now)
+	return nil
+}
+
+// useTokens uses n tokens for a given string key, or the rule's cost if n is zero, and returns the
+// tokens left on its rule, or -1 if they are unknown, along with the tokens taken for it in memory
+func (m *KeyedManager[K]) useTokens(key K, n int) (remaining int, c charge[K], err error) {
+	key = m.normalize(key)
+	if err := m.admitting(); err != nil {
+		return 0, c, err
+	}
+	if l, ok := m.listed(key); ok {
+		if l == allowed {
+			return -1, c, nil
+		}
+		return 0, c, &KeyedQuotaExceededError[K]{Key: key}
+	}
+	h := m.hash(key)
+	s := m.shard(h)
+	if remaining, charged := m.useTokensShared(s, h, key, n); charged > 0 {
+		return remaining, charge[K]{key: key, n: charged}, nil
+	}
+	s.Lock()
+	e := m.entryForUse(s, h, key)
+	if e == nil {
+		s.Unlock()
+		return -1, c, &KeyedRuleNotFoundError[K]{Key: key}
 	}
-	m.hash.Reset()
+	now := m.clock.Now()
+	s.touch(e, now)
+	if e.disabled {
+		e.allowed.Add(1)
+		remaining = e.rule.Remaining()
+		s.Unlock()
+		m.publish(key, true, remaining, now)
+		return remaining, c, nil
+	}
+	if n == 0 {
+		n = cost(e.rule)
+	}
+	if e.hasParent {
+		s.Unlock()
+		return m.useChain(key, n)
+	}
+	r := e.rule
+	if rule, ok := r.(*Rule); ok && m.backend != nil {
+		rate, burst := rule.rate, rule.burst
+		s.Unlock()
+		return -1, c, m.useBackend(s, e, key, rate, burst, n)
+	}
+	r.Refill(now)
+	before := r.Remaining()
+	if r.UseTokens(n) {
+		if qe := m.useGlobal(key, n, now); qe != nil {
+			r.ReturnTokens(n)
+			e.denied.Add(1)
+			remaining = r.Remaining()
+			s.Unlock()
+			m.publish(key, false, remaining, now)
+			return remaining, c, m.deny(key, qe)
+		}
+		e.allowed.Add(1)
+		remaining = r.Remaining()
+		soft := crossedSoftLimit(r, before, remaining)
+		s.Unlock()
+		m.publish(key, true, remaining, now)
+		if soft {
+			m.softLimit(key, remaining)
+		}
+		return remaining, charge[K]{key: key, n: n, global: m.global != nil}, nil
+	}
+	e.denied.Add(1)
+	remaining = r.Remaining()
+	m.Lock()
+	retryAfter, binding, _ := r.RetryAfter(n, m.scheduleFor(e, now))
+	m.Unlock()
+	s.Unlock()
+	m.publish(key, false, remaining, now)
+	return remaining, c, m.deny(key, &KeyedQuotaExceededError[K]{Key: key, Rule: binding, RetryAfter: retryAfter})
+}
+
+// useTokensShared uses n tokens for a key, or the rule's cost if n is zero, holding only the read lock
+// of its shard and returns whether they were used. This is possible for a *Rule without a soft limit
+// enforced in memory which is up to date and already being refilled, in a shard which tracks no
+// recency, since using it changes nothing but its atomic count and stats. Otherwise, or if too few
+// tokens are available, nothing is used and the caller must take the shard's lock. The tokens left
+// are returned along with how many were used, zero if none were.
+func (m *KeyedManager[K]) useTokensShared(s *shard[K], h uint64, key K, n int) (int, int) {
+	if m.backend != nil || m.global != nil || m.tracksAccess(s) {
+		return 0, 0
+	}
+	s.RLock()
+	e := s.entry(h, key)
+	if e == nil || e.disabled || e.hasParent || !s.refilling(e) || !upToDate(e.rule) ||
+		e.rule.(*Rule).softLimit != 0 {
+		s.RUnlock()
+		return 0, 0
+	}
+	r := e.rule.(*Rule)
+	if n == 0 {
+		n = r.Cost()
+	}
+	if !r.UseTokens(n) {
+		s.RUnlock()
+		return 0, 0
+	}
+	e.allowed.Add(1)
+	remaining := r.Remaining()
+	s.RUnlock()
+	m.publish(key, true, remaining, m.clock.Now())
+	return remaining, n
+}
+
+// AllowAt returns whether a token use for a key at time t is allowed, using the rule's cost if so, as if
+// the manager's clock read t, such as to replay historical traffic deterministically. Tokens are only
+// accrued up to t by rules which refill lazily, as with NewManagerLazy, or roll over, and nothing is
+// accrued by a t before one already seen, so calls must be made with non-decreasing times. The use is
+// counted in the key's stats, but like UseTokensMulti only the key's own rule is used in memory and no
+// events or callbacks are published. Disabled rules and allowlisted keys are always allowed, and
+// denylisted keys never are. ErrClosed or ErrDraining is returned as by UseToken.
+func (m *KeyedManager[K]) AllowAt(key K, t time.Time) (bool, error) {
+	key = m.normalize(key)
+	if err := m.admitting(); err != nil {
+		return false, err
+	}
+	if l, ok := m.listed(key); ok {
+		return l == allowed, nil
+	}
+	h := m.hash(key)
+	s := m.shard(h)
+	s.Lock()
+	e := m.entryForUse(s, h, key)
+	if e == nil {
+		s.Unlock()
+		return false, &KeyedRuleNotFoundError[K]{Key: key}
+	}
+	s.touch(e, m.clock.Now())
+	if e.disabled {
+		e.allowed.Add(1)
+		s.Unlock()
+		return true, nil
+	}
+	e.rule.Refill(t)
+	if !e.rule.UseTokens(cost(e.rule)) {
+		e.denied.Add(1)
+		s.Unlock()
+		return false, nil
+	}
+	e.allowed.Add(1)
+	s.Unlock()
+	return true, nil
+}
+
+// deny reports a token use for a key which exceeded its quota, invoking the OnExceeded callback and
+// returning the error unless the manager is in dry run mode. No locks may be held.
+func (m *KeyedManager[K]) deny(key K, err *KeyedQuotaExceededError[K]) error {
+	m.Lock()
+	onExceeded, dryRun := m.onExceeded, m.dryRun
 	m.Unlock()
+	if m.logger != nil {
+		m.logger.Debugf("denied token use: %v", err)
+	}
+	if onExceeded != nil {
+		onExceeded(key)
+	}
+	if dryRun {
+		return nil
+	}
+	return err
+}
+
+// ReturnToken gives back a token for a given string key, such as when a request fails after using it
+// through no fault of the caller
+func (m *KeyedManager[K]) ReturnToken(key K) error {
+	return m.ReturnTokens(key, 1)
+}
+
+// ReturnTokens gives back n tokens for a given string key. The rule never holds more tokens than its
+// maximum however many are returned, so returning tokens that were not used has no effect on a full
+// rule.
+func (m *KeyedManager[K]) ReturnTokens(key K, n int) error {
+	key = m.normalize(key)
+	if n <= 0 {
+		return ErrInvalidTokenCount
+	}
+	h := m.hash(key)
+	s := m.shard(h)
+	s.Lock()
+	r, exists := s.rule(h, key)
+	if !exists {
+		s.Unlock()
+		return &KeyedRuleNotFoundError[K]{Key: key}
+	}
+	r.Refill(m.clock.Now())
+	r.ReturnTokens(n)
+	s.Unlock()
 	return nil
 }
 
-// addTokens runs through all rules and adds tokens to each one
-func (m *Manager) addTokens() {
+// WaitToken blocks until a token can be used for a given string key or the context is done. Callers
+// sleep until the token is expected to be available, or the next refill, between attempts, or for at
+// most the poll step set by WithWaitPolling. If the context deadline falls before then WaitToken
+// returns context.DeadlineExceeded without waiting.
+func (m *KeyedManager[K]) WaitToken(ctx context.Context, key K) error {
+	return m.wait(ctx, key, time.Time{})
+}
+
+// untilRefill returns the time until the next scheduled refill or the update interval if none is known
+func (m *KeyedManager[K]) untilRefill() time.Duration {
+	m.Lock()
+	wait := m.nextRefill.Sub(m.clock.Now())
+	m.Unlock()
+	if wait <= 0 {
+		wait = m.updateRate
+	}
+	return wait
+}
+
+// addTokens runs through all rules which may not be full and adds tokens to each one, locking one
+// shard at a time per worker. Rules which are full afterwards are skipped until they are next used, so
+// idle rules cost nothing.
+func (m *KeyedManager[K]) addTokens() {
+	m.eachShard(func(_ int, s *shard[K]) {
+		s.Lock()
+		for e := range s.active {
+			e.rule.AddToken()
+			if full(e.rule) {
+				delete(s.active, e)
+			}
+		}
+		// maps never shrink and ranging over one costs as much as its largest size, so rebuild the
+		// active set once most of it is gone
+		if len(s.active) < s.peak/4 {
+			active := make(map[*entry[K]]struct{}, len(s.active))
+			for e := range s.active {
+				active[e] = struct{}{}
+			}
+			s.active, s.peak = active, len(active)
+		}
+		s.Unlock()
+	})
 	m.Lock()
-	for _, r := range m.rules {
-		r.addToken()
+	if m.done != nil {
+		m.nextRefill = m.clock.Now().Add(m.updateRate)
 	}
 	m.Unlock()
 }
@@ -104,28 +1371,188 @@ func (m *Manager) addTokens() {
 // Rule represents a quota rule where queries per second and a window duration must be specified. If
 // QPS is 2 and a window of 3 seconds is specified then in a 3 second window, 6 queries are allowed.
 type Rule struct {
-	qps        int
+	rate       float64 // queries per second, which may be fractional for rules created by NewRuleRate
 	window     time.Duration
-	count      int // will always be capped to maxQueries and each use will decrement by 1
+	count      atomic.Int64 // will always be capped to burst and each use will decrement by 1
 	maxQueries int
-	addTokens  int
+	burst      int       // ceiling count is refilled up to, defaults to maxQueries
+	addTokens  float64   // tokens added per refill, may be fractional when the refill rate is below 1
+	accrued    float64   // fractional tokens carried over between refills
+	lazy       bool      // accrue tokens on use rather than from addToken
+	lastRefill time.Time // time tokens were last accrued when lazy
+
+	cost        int            // tokens used by UseToken, a single token if zero
+	costFunc    CostFunc       // tokens used by UseTokenFunc for a size, the size itself if nil
+	rollover    bool           // reset tokens at window boundaries, carrying over unused tokens
+	maxCarry    int            // most unused tokens carried into the next window
+	windowStart time.Time      // start of the current window when rolling over
+	align       *time.Location // aligns windows to the wall clock in this location if set
+	softLimit   float64        // fraction of tokens used before warning, never if zero
+	softCrossed bool           // a use has crossed the soft limit since the rule was last under it
+	warmup      time.Duration  // time a new rule takes to ramp up to its burst, none if zero
+	warmupLeft  time.Duration  // time left ramping up, ended early once the rule is full
+	interval    time.Duration  // time between refills by AddToken
+	every       time.Duration  // own refill interval, the manager's update interval if zero
+	queued      bool           // AddToken is called every own interval rather than every interval
+	elapsed     time.Duration  // time swept since the rule was last refilled on its own interval
+	overdraft   int            // tokens which may be used beyond those available, repaid by refills
+}
+
+// RuleOption configures a Rule at construction
+type RuleOption func(*Rule)
+
+// WithCost sets the number of tokens UseToken uses from a rule, such as for keys whose requests are
+// all expensive. Non-positive costs are ignored and the rule costs a single token.
+func WithCost(cost int) RuleOption {
+	return func(r *Rule) {
+		if cost > 0 {
+			r.cost = cost
+		}
+	}
+}
+
+// WithRollover makes a rule count queries in fixed windows rather than refilling continuously. At
+// each window boundary the rule is reset to its burst plus whatever was left unused in the previous
+// window, carrying over at most maxCarry tokens. The rule can therefore hold up to burst plus maxCarry
+// tokens, while a window with borrowed tokens starts the next one short by as many. A non-positive
+// maxCarry resets every window to exactly burst tokens.
+func WithRollover(maxCarry int) RuleOption {
+	return func(r *Rule) {
+		r.rollover = true
+		r.maxCarry = int(clampTokens(float64(maxCarry)))
+	}
 }
 
 // NewRule creates a quota rule given a qps and time window duration
-func NewRule(qps int, window time.Duration) *Rule {
-	maxQueries := int(window.Seconds() * float64(qps))
-	return &Rule{
-		qps:        qps,
-		window:     window,
-		count:      maxQueries,
-		maxQueries: maxQueries,
-		addTokens:  int(UpdateRate.Seconds() * float64(qps)),
+func NewRule(qps int, window time.Duration, opts ...RuleOption) *Rule {
+	return NewRuleRate(float64(qps), window, opts...)
+}
+
+// NewRuleRate creates a quota rule given a rate of queries per second, which may be below one, and a
+// time window duration. A rate of 0.5 over a minute allows 30 queries per minute, refilling one
+// token every two seconds.
+func NewRuleRate(rate float64, window time.Duration, opts ...RuleOption) *Rule {
+	r := newRule(rate, window, UpdateRate)
+	for _, opt := range opts {
+		opt(r)
+	}
+	return r
+}
+
+// NewRuleChecked creates a quota rule given a qps and time window duration, returning an error wrapping
+// ErrInvalidRule if the qps or window are non-positive or their product is too small to allow a single
+// query or too large to count
+func NewRuleChecked(qps int, window time.Duration, opts ...RuleOption) (*Rule, error) {
+	if err := checkRule(float64(qps), window); err != nil {
+		return nil, err
 	}
+	return NewRule(qps, window, opts...), nil
 }
 
-// QPS returns the queries per second of the rule
+// checkRule returns an error wrapping ErrInvalidRule if a rate and window do not make a usable rule
+func checkRule(rate float64, window time.Duration) error {
+	if rate <= 0 {
+		return fmt.Errorf("%w: qps must be positive, got %v", ErrInvalidRule, rate)
+	}
+	if window <= 0 {
+		return fmt.Errorf("%w: window must be positive, got %v", ErrInvalidRule, window)
+	}
+	queries := window.Seconds() * rate
+	if queries < 1 {
+		return fmt.Errorf("%w: window %v at %v qps allows no queries", ErrInvalidRule, window, rate)
+	}
+	if queries > MaxTokens {
+		return fmt.Errorf("%w: window %v at %v qps allows more than %d queries", ErrInvalidRule, window, rate, MaxTokens)
+	}
+	return nil
+}
+
+// NewRuleWithBurst creates a quota rule given a qps and time window duration which may accumulate up
+// to burst tokens. Tokens are still refilled at qps, so a burst of 50 with a qps of 10 allows short
+// spikes of 50 queries while sustaining 10 per second. A non-positive burst defaults to the window's
+// worth of queries as with NewRule.
+func NewRuleWithBurst(qps int, window time.Duration, burst int, opts ...RuleOption) *Rule {
+	return NewRule(qps, window, append(opts, withBurst(burst))...)
+}
+
+// withBurst sets the most tokens a rule can accumulate, ignoring non-positive bursts
+func withBurst(burst int) RuleOption {
+	return func(r *Rule) {
+		if burst > 0 {
+			r.burst = int(clampTokens(float64(burst)))
+			if r.warmup == 0 {
+				r.setCount(r.burst)
+			}
+		}
+	}
+}
+
+// newRule creates a quota rule which is refilled every updateRate
+func newRule(rate float64, window time.Duration, updateRate time.Duration) *Rule {
+	r := &Rule{}
+	r.init(rate, window, updateRate)
+	return r
+}
+
+// init sets up a zero rule with its window's worth of tokens which is refilled every updateRate
+func (r *Rule) init(rate float64, window time.Duration, updateRate time.Duration) {
+	maxQueries := int(clampTokens(window.Seconds() * rate))
+	r.rate = rate
+	r.window = window
+	r.maxQueries = maxQueries
+	r.burst = maxQueries
+	r.setCount(maxQueries)
+	r.setUpdateRate(updateRate)
+}
+
+// resize changes the rate and window of a rule, keeping the fraction of the most it can hold which is
+// available. A burst which defaulted to the window's worth of queries follows the new window.
+func (r *Rule) resize(rate float64, window time.Duration, updateRate time.Duration) {
+	oldMax, tokens := r.Max(), r.tokens()
+	maxQueries := int(clampTokens(window.Seconds() * rate))
+	if r.burst == r.maxQueries {
+		r.burst = maxQueries
+	}
+	r.rate = rate
+	r.window = window
+	r.maxQueries = maxQueries
+	r.setUpdateRate(updateRate)
+
+	max := r.Max()
+	if oldMax > 0 {
+		tokens = int(float64(tokens) / float64(oldMax) * float64(max))
+	}
+	if tokens > max {
+		tokens = max
+	}
+	r.setCount(tokens)
+}
+
+// setUpdateRate recomputes the tokens added per refill for a rule refilled every updateRate
+func (r *Rule) setUpdateRate(updateRate time.Duration) {
+	r.interval = updateRate
+	r.addTokens = clampTokens(r.period().Seconds() * r.rate)
+}
+
+// clampTokens limits a token amount to between 0 and MaxTokens
+func clampTokens(tokens float64) float64 {
+	switch {
+	case tokens < 0:
+		return 0
+	case tokens > MaxTokens:
+		return MaxTokens
+	}
+	return tokens
+}
+
+// QPS returns the queries per second of the rule, truncated for rules with a fractional rate
 func (r *Rule) QPS() int {
-	return r.qps
+	return int(r.rate)
+}
+
+// Rate returns the queries per second of the rule including any fraction
+func (r *Rule) Rate() float64 {
+	return r.rate
 }
 
 // Window returns the time window of the rule
@@ -133,20 +1560,287 @@ func (r *Rule) Window() time.Duration {
 	return r.window
 }
 
-func (r *Rule) addToken() {
-	if r.count == r.maxQueries {
+// Cost returns the number of tokens UseToken uses from the rule
+func (r *Rule) Cost() int {
+	if r.cost > 0 {
+		return r.cost
+	}
+	return 1
+}
+
+// cost returns the number of tokens UseToken uses from a limiter, which is a single token unless the
+// limiter has a Cost method
+func cost(l Limiter) int {
+	if c, ok := l.(interface{ Cost() int }); ok {
+		return c.Cost()
+	}
+	return 1
+}
+
+// Burst returns the most tokens the rule can accumulate
+func (r *Rule) Burst() int {
+	return r.burst
+}
+
+// Max returns the most tokens the rule can hold, which is its burst plus any carry when rolling over
+func (r *Rule) Max() int {
+	return r.carry(r.maxCarry)
+}
+
+// carry returns the tokens a rolling over rule starts a window with given the tokens left over from
+// the previous one
+func (r *Rule) carry(left int) int {
+	if left > r.maxCarry {
+		left = r.maxCarry
+	}
+	return int(clampTokens(float64(r.burst) + float64(left)))
+}
+
+// Full returns whether refilling the rule would add nothing, which is always the case for rules which
+// roll over since they are only refilled at window boundaries
+func (r *Rule) Full() bool {
+	return r.rollover || r.tokens() >= r.burst
+}
+
+// full returns whether refilling a limiter would add nothing, using its Full method if it has one
+func full(l Limiter) bool {
+	if f, ok := l.(interface{ Full() bool }); ok {
+		return f.Full()
+	}
+	return l.Remaining() >= l.Max()
+}
+
+// AddToken adds the refill amount to the rule. Rules which roll over are only refilled at window
+// boundaries by Refill.
+func (r *Rule) AddToken() {
+	if r.rollover {
+		return
+	}
+	if period := r.period(); period > r.interval && !r.queued {
+		// swept more often than the rule's own interval, so only refill once it has passed
+		r.elapsed += r.interval
+		if r.elapsed < period {
+			return
+		}
+		r.elapsed -= period
+	}
+	if r.warmupLeft > 0 {
+		r.accrue(r.earned(r.period()))
+		return
+	}
+	r.accrue(r.addTokens)
+}
+
+// SetSchedule sets the refill amount for the manager's update interval and whether the rule is refilled
+// lazily
+func (r *Rule) SetSchedule(sch Schedule) {
+	r.queued = sch.Queued
+	r.setUpdateRate(sch.UpdateRate)
+	r.lazy = sch.Lazy
+	r.lastRefill = sch.Now
+	r.windowStart = r.windowFor(sch.Now)
+}
+
+// Refill adds the tokens earned at the rule's qps between the last refill and now if the rule is
+// refilled lazily, or starts a new window if the rule rolls over and a window boundary has passed
+func (r *Rule) Refill(now time.Time) {
+	if r.rollover {
+		r.rollOver(now)
+		return
+	}
+	if !r.lazy {
+		return
+	}
+	elapsed := now.Sub(r.lastRefill)
+	if elapsed <= 0 {
+		return
+	}
+	r.lastRefill = now
+	r.accrue(r.earned(elapsed))
+}
+
+// rollOver starts a new window for every window boundary passed since the current window started
+func (r *Rule) rollOver(now time.Time) {
+	if r.windowStart.IsZero() {
+		r.windowStart = r.windowFor(now)
+		return
+	}
+	if r.window <= 0 {
+		return
+	}
+	if r.align != nil {
+		r.rollOverAligned(now)
+		return
+	}
+	windows := now.Sub(r.windowStart) / r.window
+	if windows <= 0 {
+		return
+	}
+	r.windowStart = r.windowStart.Add(windows * r.window)
+	for ; windows > 0; windows-- {
+		count := r.carry(r.tokens())
+		if count == r.tokens() {
+			break
+		}
+		r.setCount(count)
+	}
+}
+
+// accrue adds tokens to the rule. Only whole tokens are made available and any fraction is carried
+// over to the next call so that rules refilling less than one token at a time still recover.
+func (r *Rule) accrue(tokens float64) {
+	if r.tokens() >= r.burst {
+		r.accrued, r.warmupLeft = 0, 0
 		return
 	}
-	r.count += r.addTokens
-	if r.count > r.maxQueries {
-		r.count = r.maxQueries
+	r.accrued += tokens
+	whole := int(r.accrued)
+	r.accrued -= float64(whole)
+	if r.adjustCount(whole, r.floor(), r.burst) >= r.burst {
+		r.accrued, r.warmupLeft = 0, 0
+	}
+}
+
+// tokens returns the rule's count, which is negative while reservations have borrowed tokens
+func (r *Rule) tokens() int {
+	return int(r.count.Load())
+}
+
+// setCount sets the rule's count
+func (r *Rule) setCount(n int) {
+	r.count.Store(int64(n))
+}
+
+// adjustCount atomically adds n tokens to the rule's count, saturating at lo and hi, and returns the
+// new count. A count already outside the bounds is brought within them.
+func (r *Rule) adjustCount(n, lo, hi int) int {
+	for {
+		count := r.count.Load()
+		next := saturate(count, int64(n), int64(lo), int64(hi))
+		if r.count.CompareAndSwap(count, next) {
+			return int(next)
+		}
+	}
+}
+
+// saturate returns count plus n clamped to between lo and hi, without overflowing however large n is.
+// Every change to a rule's count goes through it, so none can leave the count out of bounds.
+func saturate(count, n, lo, hi int64) int64 {
+	next := count + n
+	switch {
+	case n > 0 && next < count:
+		next = hi
+	case n < 0 && next > count:
+		next = lo
 	}
+	return min(max(next, lo), hi)
 }
 
-func (r *Rule) useToken() bool {
-	if r.count == 0 {
+// floor returns the lowest count the rule can have, which is negative by at most the most it can hold
+// while reservations have borrowed tokens
+func (r *Rule) floor() int {
+	return -max(r.Max(), r.overdraft)
+}
+
+// UseTokens uses n tokens if they are all available, or within the rule's overdraft, and returns
+// whether they were used. Tokens are taken atomically, so concurrent calls holding only a read lock
+// never use more than are available.
+func (r *Rule) UseTokens(n int) bool {
+	if n < 0 {
 		return false
 	}
-	r.count--
-	return true
+	for {
+		count := r.count.Load()
+		if !r.covers(count, int64(n)) {
+			return false
+		}
+		if r.count.CompareAndSwap(count, saturate(count, -int64(n), -int64(r.overdraft), int64(r.Max()))) {
+			return true
+		}
+	}
+}
+
+// Borrow uses n tokens whether or not they are available, leaving the count no lower than the negative
+// of the most the rule can hold
+func (r *Rule) Borrow(n int) {
+	r.adjustCount(-n, r.floor(), r.Max())
+}
+
+// ReturnTokens gives back n tokens without exceeding the most the rule can hold
+func (r *Rule) ReturnTokens(n int) {
+	r.adjustCount(n, r.floor(), r.Max())
+}
+
+// SetTokens sets the number of tokens available, clamped to between zero and the most the rule can
+// hold
+func (r *Rule) SetTokens(n int) {
+	r.setCount(int(saturate(0, int64(n), 0, int64(r.Max()))))
+}
+
+// Remaining returns the number of tokens available, excluding any borrowed by reservations
+func (r *Rule) Remaining() int {
+	if count := r.tokens(); count > 0 {
+		return count
+	}
+	return 0
+}
+
+// RetryAfter returns how long until n tokens are available on the rule and false if they never will
+// be
+func (r *Rule) RetryAfter(n int, sch Schedule) (time.Duration, Limiter, bool) {
+	n -= r.overdraft
+	if r.rollover {
+		return r.rollOverAfter(n, sch.Now)
+	}
+	count := r.tokens()
+	need := float64(n-count) - r.accrued
+	if need <= 0 {
+		return 0, r, true
+	}
+	if n-count > r.burst {
+		return 0, r, false
+	}
+	switch {
+	case sch.Lazy && r.rate > 0:
+		return time.Duration(need / r.rate * float64(time.Second)), r, true
+	case !sch.NextRefill.IsZero() && r.addTokens > 0:
+		refills := math.Ceil(need / r.addTokens)
+		delay := sch.NextRefill.Sub(sch.Now) + r.untilOwnRefill(sch.UpdateRate) + time.Duration(refills-1)*r.period()
+		if delay < 0 {
+			delay = 0
+		}
+		return delay, r, true
+	}
+	return 0, r, false
+}
+
+// rollOverAfter returns how many window boundaries must pass before n tokens are available on a rule
+// which rolls over, as a duration from now
+func (r *Rule) rollOverAfter(n int, now time.Time) (time.Duration, Limiter, bool) {
+	if n <= r.tokens() {
+		return 0, r, true
+	}
+	if n > r.Max() || r.window <= 0 {
+		return 0, r, false
+	}
+	start := r.windowStart
+	if start.IsZero() {
+		start = r.windowFor(now)
+	}
+	boundary := r.nextWindow(start)
+	for count := r.tokens(); ; boundary = r.nextWindow(boundary) {
+		next := r.carry(count)
+		if next >= n {
+			break
+		}
+		if next == count {
+			return 0, r, false
+		}
+		count = next
+	}
+	delay := boundary.Sub(now)
+	if delay < 0 {
+		delay = 0
+	}
+	return delay, r, true
 }
@@ -1,10 +1,16 @@
 package main
 
 import (
+	"context"
+	"errors"
+	"io"
+	"math"
 	"strconv"
 	"sync"
 	"testing"
 	"time"
+
+	"github.com/OneOfOne/xxhash"
 )
 
 func TestQuotaInvalidUser(t *testing.T) {
@@ -15,7 +21,7 @@ func TestQuotaInvalidUser(t *testing.T) {
 	if err == nil {
 		t.Fatalf("Should have returned an error for an invalid user")
 	}
-	if err != ErrRuleDoesNotExist {
+	if !errors.Is(err, ErrRuleDoesNotExist) {
 		t.Fatalf("Should not have allowed a token use for user1")
 	}
 }
@@ -33,6 +39,153 @@ func TestQuotaValidUser(t *testing.T) {
 	}
 }
 
+func TestQuotaUseTokens(t *testing.T) {
+	m := NewManager()
+
+	user := "user1"
+	m.AddRule(user, NewRule(2, 5*time.Second))
+
+	if err := m.UseTokens(user, 0); err != ErrInvalidTokenCount {
+		t.Fatalf("Expected %v for zero tokens but got %v", ErrInvalidTokenCount, err)
+	}
+	if err := m.UseTokens(user, 7); err != nil {
+		t.Fatalf("Did not expect an error on valid user, %v", err)
+	}
+	if err := m.UseTokens(user, 4); !errors.Is(err, ErrQuotaExceeded) {
+		t.Fatalf("Expected %v using more tokens than available but got %v", ErrQuotaExceeded, err)
+	}
+
+	l, _ := m.GetRule(user)
+	if r := l.(*Rule); r.tokens() != 3 {
+		t.Fatalf("Expected a denied request to leave 3 tokens but got %d", r.tokens())
+	}
+}
+
+func TestQuotaUseTokenWithRemaining(t *testing.T) {
+	m := NewManager()
+	m.AddRule("user1", NewRule(1, 2*time.Second))
+	m.AddRule("org", NewRule(5, 1*time.Second))
+	m.AddChildRule("org", "user2", NewRule(1, 3*time.Second))
+
+	for _, expected := range []int{1, 0} {
+		if remaining, err := m.UseTokenWithRemaining("user1"); err != nil || remaining != expected {
+			t.Fatalf("Expected %d tokens remaining but got %d, %v", expected, remaining, err)
+		}
+	}
+	if remaining, err := m.UseTokenWithRemaining("user1"); !errors.Is(err, ErrQuotaExceeded) || remaining != 0 {
+		t.Fatalf("Expected %v with 0 remaining but got %d, %v", ErrQuotaExceeded, remaining, err)
+	}
+	if remaining, err := m.UseTokenWithRemaining("user2"); err != nil || remaining != 2 {
+		t.Fatalf("Expected the child's own 2 tokens remaining but got %d, %v", remaining, err)
+	}
+	if _, err := m.UseTokenWithRemaining("user3"); !errors.Is(err, ErrRuleDoesNotExist) {
+		t.Fatalf("Expected %v for a missing rule but got %v", ErrRuleDoesNotExist, err)
+	}
+
+	m.Allowlist("user1")
+	if remaining, err := m.UseTokenWithRemaining("user1"); err != nil || remaining != -1 {
+		t.Fatalf("Expected unknown tokens remaining for an allowlisted key but got %d, %v", remaining, err)
+	}
+}
+
+func TestQuotaUseTokenCost(t *testing.T) {
+	m := NewManager()
+
+	user := "user1"
+	m.AddRule(user, NewRule(1, 5*time.Second))
+	if err := m.UseTokenCost(user, 3); err != nil {
+		t.Fatalf("Did not expect an error on valid user, %v", err)
+	}
+	if err := m.UseTokenCost(user, 3); !errors.Is(err, ErrQuotaExceeded) {
+		t.Fatalf("Expected %v for a cost above the remaining tokens but got %v", ErrQuotaExceeded, err)
+	}
+	if remaining, _ := m.Remaining(user); remaining != 2 {
+		t.Fatalf("Expected a denied cost to use no tokens but got %d remaining", remaining)
+	}
+	if err := m.UseTokenCost(user, -1); err != ErrInvalidTokenCount {
+		t.Fatalf("Expected %v for a negative cost but got %v", ErrInvalidTokenCount, err)
+	}
+
+	expensive := "user2"
+	m.AddRule(expensive, NewRule(1, 5*time.Second, WithCost(2)))
+	m.UseToken(expensive)
+	m.UseToken(expensive)
+	if remaining, _ := m.Remaining(expensive); remaining != 1 {
+		t.Fatalf("Expected UseToken to use the rule's cost of 2 tokens but got %d remaining", remaining)
+	}
+	if err := m.UseToken(expensive); !errors.Is(err, ErrQuotaExceeded) {
+		t.Fatalf("Expected %v for a cost above the remaining tokens but got %v", ErrQuotaExceeded, err)
+	}
+}
+
+func TestQuotaUseTokenCostZero(t *testing.T) {
+	m := NewManager()
+
+	user := "user1"
+	m.AddRule(user, NewRule(1, 2*time.Second))
+	m.UseTokens(user, 2)
+	for i := 0; i < 3; i++ {
+		if err := m.UseTokenCost(user, 0); err != nil {
+			t.Fatalf("Did not expect an error for a zero cost on an exhausted rule, %v", err)
+		}
+	}
+	if stats, _ := m.Stats(user); stats.Allowed != 4 || stats.Denied != 0 || stats.Current != 0 {
+		t.Fatalf("Expected zero cost uses to be counted as allowed without using tokens but got %+v", stats)
+	}
+	if err := m.Observe("user2"); !errors.Is(err, ErrRuleDoesNotExist) {
+		t.Fatalf("Expected %v for a missing rule but got %v", ErrRuleDoesNotExist, err)
+	}
+}
+
+func TestQuotaOnExceeded(t *testing.T) {
+	m := NewManager()
+
+	user := "user1"
+	m.AddRule(user, NewRule(1, 1*time.Second))
+
+	var exceeded []string
+	m.SetOnExceeded(func(key string) {
+		exceeded = append(exceeded, key)
+	})
+
+	m.UseToken(user)
+	if len(exceeded) != 0 {
+		t.Fatalf("Did not expect the callback to fire on an allowed request")
+	}
+	m.UseToken(user)
+	if len(exceeded) != 1 || exceeded[0] != user {
+		t.Fatalf("Expected the callback to fire once for %s but got %v", user, exceeded)
+	}
+}
+
+func TestQuotaDryRun(t *testing.T) {
+	m := NewManager(WithDryRun(true))
+
+	var exceeded int
+	m.SetOnExceeded(func(key string) {
+		exceeded++
+	})
+
+	user := "user1"
+	m.AddRule(user, NewRule(1, 3*time.Second))
+	for i := 0; i < 5; i++ {
+		if err := m.UseToken(user); err != nil {
+			t.Fatalf("Did not expect an error in dry run mode, %v", err)
+		}
+	}
+	if stats, _ := m.Stats(user); exceeded != 2 || stats.Denied != 2 || stats.Current != 0 {
+		t.Fatalf("Expected 2 denials to be reported with no tokens left but got %d and %+v", exceeded, stats)
+	}
+	if err := m.UseToken("user2"); !errors.Is(err, ErrRuleDoesNotExist) {
+		t.Fatalf("Expected %v for a missing rule in dry run mode but got %v", ErrRuleDoesNotExist, err)
+	}
+
+	m.SetDryRun(false)
+	if err := m.UseToken(user); !errors.Is(err, ErrQuotaExceeded) {
+		t.Fatalf("Expected %v once dry run mode is disabled but got %v", ErrQuotaExceeded, err)
+	}
+}
+
 func TestQuotaCountMax(t *testing.T) {
 	m := NewManager()
 	m.Run()
@@ -41,30 +194,1286 @@ func TestQuotaCountMax(t *testing.T) {
 	m.AddRule("user2", NewRule(1, 1*time.Second))
 	m.AddRule("user3", NewRule(4, 2*time.Second))
 
-	m.Lock()
-	for k, r := range m.rules {
-		if r.count != r.maxQueries {
-			t.Fatalf("Expected %d tokens available but got %d, for %d", r.maxQueries, r.count, k)
+	for _, s := range m.shards {
+		s.Lock()
+		for _, e := range s.rules {
+			if r := e.rule.(*Rule); r.tokens() != r.maxQueries {
+				t.Fatalf("Expected %d tokens available but got %d, for %s", r.maxQueries, r.tokens(), e.key)
+			}
 		}
+		s.Unlock()
 	}
-	m.Unlock()
 }
 
-func BenchmarkQuotaUpdateMillionKeys(b *testing.B) {
+func TestQuotaShards(t *testing.T) {
+	for _, tc := range []struct {
+		n        int
+		expected int
+	}{
+		{0, 1},
+		{1, 1},
+		{3, 4},
+		{64, 64},
+	} {
+		m := NewManagerWithShards(tc.n)
+		if len(m.shards) != tc.expected {
+			t.Fatalf("Expected %d shards for %d but got %d", tc.expected, tc.n, len(m.shards))
+		}
+	}
+
+	m := NewManagerWithShards(4)
+	for i := 0; i < 100; i++ {
+		m.AddRule(strconv.Itoa(i), NewRule(1, 1*time.Second))
+	}
+	for i := 0; i < 100; i++ {
+		if err := m.UseToken(strconv.Itoa(i)); err != nil {
+			t.Fatalf("Did not expect an error on valid user %d, %v", i, err)
+		}
+	}
+}
+
+func TestQuotaCapacity(t *testing.T) {
+	m := NewManagerWithCapacity(3)
+	if len(m.shards) != 1 {
+		t.Fatalf("Expected a small capacity to use a single shard but got %d", len(m.shards))
+	}
+
+	m.AddRule("user1", NewRule(1, 5*time.Second))
+	m.AddRule("user2", NewRule(1, 5*time.Second))
+	m.AddRule("user3", NewRule(1, 5*time.Second))
+	m.UseToken("user1")
+	m.GetRule("user2")
+	m.AddRule("user4", NewRule(1, 5*time.Second))
+
+	if m.Len() != 3 {
+		t.Fatalf("Expected the manager to hold 3 rules but got %d", m.Len())
+	}
+	if _, err := m.GetRule("user3"); !errors.Is(err, ErrRuleDoesNotExist) {
+		t.Fatalf("Expected the least recently used rule to be evicted but got %v", err)
+	}
+
+	m.SetDefaultRule(NewRule(1, 5*time.Second))
+	m.UseToken("user3")
+	if remaining, _ := m.Remaining("user3"); remaining != 4 {
+		t.Fatalf("Expected the evicted key to start fresh from the default rule but got %d tokens", remaining)
+	}
+	if _, err := m.GetRule("user1"); !errors.Is(err, ErrRuleDoesNotExist) {
+		t.Fatalf("Expected user1 to be evicted by the default rule but got %v", err)
+	}
+
+	m.RemoveRule("user3")
+	m.Clear()
+	for i := 0; i < 10; i++ {
+		m.AddRule("user"+strconv.Itoa(i), NewRule(1, 5*time.Second))
+	}
+	if m.Len() != 3 {
+		t.Fatalf("Expected the manager to hold 3 rules after clearing but got %d", m.Len())
+	}
+
+	if m := NewManagerWithCapacity(1000); len(m.shards) != 32 || m.shards[0].capacity+m.shards[31].capacity != 63 {
+		t.Fatalf("Expected 1000 rules split across 32 shards but got %d shards", len(m.shards))
+	}
+}
+
+func TestQuotaRemoveRule(t *testing.T) {
 	m := NewManager()
 
-	for i := 0; i < 1000000; i++ {
-		m.AddRule(strconv.Itoa(i), NewRule(1, 30*time.Second))
+	user := "user1"
+	m.AddRule(user, NewRule(1, 5*time.Second))
+
+	if err := m.RemoveRule(user); err != nil {
+		t.Fatalf("Did not expect an error removing a valid user, %v", err)
 	}
-	b.ResetTimer()
-	for n := 0; n < b.N; n++ {
-		m.addTokens()
+	if err := m.UseToken(user); !errors.Is(err, ErrRuleDoesNotExist) {
+		t.Fatalf("Expected %v after removing rule but got %v", ErrRuleDoesNotExist, err)
+	}
+	if err := m.RemoveRule(user); !errors.Is(err, ErrRuleDoesNotExist) {
+		t.Fatalf("Expected %v removing a missing rule but got %v", ErrRuleDoesNotExist, err)
 	}
 }
 
-func BenchmarkQuotaUseMillionKeys(b *testing.B) {
+func TestQuotaHas(t *testing.T) {
+	m := NewManager()
+	m.hash = func(string) uint64 { return 0 }
+
+	m.AddRule("user1", NewRule(1, 5*time.Second))
+	if !m.Has("user1") {
+		t.Fatalf("Expected a rule to be registered for user1")
+	}
+	if m.Has("user2") {
+		t.Fatalf("Did not expect a rule for a key colliding with user1")
+	}
+	m.RemoveRule("user1")
+	if m.Has("user1") {
+		t.Fatalf("Did not expect a rule after removing it")
+	}
+}
+
+func TestQuotaGetOrCreateRule(t *testing.T) {
+	m := NewManager()
+
+	var calls int
+	factory := func() Limiter {
+		calls++
+		return NewRule(1, 5*time.Second)
+	}
+
+	user := "user1"
+	r, created := m.GetOrCreateRule(user, factory)
+	if !created || calls != 1 {
+		t.Fatalf("Expected the rule to be created by the factory")
+	}
+	m.UseToken(user)
+
+	existing, created := m.GetOrCreateRule(user, factory)
+	if created || calls != 1 {
+		t.Fatalf("Did not expect the factory to run for an existing rule")
+	}
+	if existing != r {
+		t.Fatalf("Expected the existing rule to be returned")
+	}
+	if remaining, _ := m.Remaining(user); remaining != 4 {
+		t.Fatalf("Expected the used token to be kept but got %d remaining", remaining)
+	}
+}
+
+func TestQuotaDefaultRule(t *testing.T) {
+	m := NewManager()
+	m.SetDefaultRule(NewRule(1, 2*time.Second))
+
+	for _, user := range []string{"user1", "user2"} {
+		if err := m.UseTokens(user, 2); err != nil {
+			t.Fatalf("Expected %s to get its own rule from the default, %v", user, err)
+		}
+		if err := m.UseToken(user); !errors.Is(err, ErrQuotaExceeded) {
+			t.Fatalf("Expected %v for %s but got %v", ErrQuotaExceeded, user, err)
+		}
+	}
+	if len(m.Keys()) != 2 {
+		t.Fatalf("Expected a rule to be registered for each key but got %v", m.Keys())
+	}
+
+	m.SetDefaultRule(nil)
+	if err := m.UseToken("user3"); !errors.Is(err, ErrRuleDoesNotExist) {
+		t.Fatalf("Expected %v without a default rule but got %v", ErrRuleDoesNotExist, err)
+	}
+}
+
+func TestQuotaRemaining(t *testing.T) {
+	m := NewManager()
+
+	user := "user1"
+	m.AddRule(user, NewRule(1, 5*time.Second))
+	m.UseToken(user)
+
+	remaining, err := m.Remaining(user)
+	if err != nil {
+		t.Fatalf("Did not expect an error on valid user, %v", err)
+	}
+	if remaining != 4 {
+		t.Fatalf("Expected 4 tokens remaining but got %d", remaining)
+	}
+
+	if _, err := m.Remaining("user2"); !errors.Is(err, ErrRuleDoesNotExist) {
+		t.Fatalf("Expected %v for a missing rule but got %v", ErrRuleDoesNotExist, err)
+	}
+}
+
+func TestQuotaUsageFraction(t *testing.T) {
+	m := NewManager()
+	m.AddRule("user1", NewRule(1, 4*time.Second))
+	m.AddRule("user2", NewRule(0, 4*time.Second))
+	m.UseToken("user1")
+
+	if remaining, err := m.RemainingFraction("user1"); err != nil || remaining != 0.75 {
+		t.Fatalf("Expected 0.75 of the tokens remaining but got %v, %v", remaining, err)
+	}
+	if usage, err := m.UsageFraction("user1"); err != nil || usage != 0.25 {
+		t.Fatalf("Expected 0.25 of the tokens used but got %v, %v", usage, err)
+	}
+	if usage, err := m.UsageFraction("user2"); err != nil || usage != 1 {
+		t.Fatalf("Expected a rule without tokens to be fully used but got %v, %v", usage, err)
+	}
+	if _, err := m.UsageFraction("user3"); !errors.Is(err, ErrRuleDoesNotExist) {
+		t.Fatalf("Expected %v for a missing rule but got %v", ErrRuleDoesNotExist, err)
+	}
+}
+
+func TestQuotaPeek(t *testing.T) {
+	m := NewManager()
+
+	user := "user1"
+	m.AddRule(user, NewRule(1, 2*time.Second))
+	m.UseToken(user)
+
+	for i := 0; i < 2; i++ {
+		if ok, err := m.Peek(user); err != nil || !ok {
+			t.Fatalf("Expected a token to be available but got %t, %v", ok, err)
+		}
+	}
+	if remaining, _ := m.Remaining(user); remaining != 1 {
+		t.Fatalf("Expected peeking not to use tokens but got %d remaining", remaining)
+	}
+
+	m.UseToken(user)
+	if ok, _ := m.Peek(user); ok {
+		t.Fatalf("Did not expect a token to be available once used")
+	}
+	if _, err := m.Peek("user2"); !errors.Is(err, ErrRuleDoesNotExist) {
+		t.Fatalf("Expected %v for a missing rule but got %v", ErrRuleDoesNotExist, err)
+	}
+}
+
+func TestQuotaReturnTokens(t *testing.T) {
+	m := NewManager()
+
+	user := "user1"
+	m.AddRule(user, NewRule(1, 5*time.Second))
+	m.UseTokens(user, 3)
+
+	if err := m.ReturnToken(user); err != nil {
+		t.Fatalf("Did not expect an error on valid user, %v", err)
+	}
+	if remaining, _ := m.Remaining(user); remaining != 3 {
+		t.Fatalf("Expected 3 tokens remaining after a return but got %d", remaining)
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			m.ReturnTokens(user, 2)
+		}()
+	}
+	wg.Wait()
+	if remaining, _ := m.Remaining(user); remaining != 5 {
+		t.Fatalf("Expected concurrent returns to be capped at 5 tokens but got %d", remaining)
+	}
+
+	if err := m.ReturnTokens(user, 0); err != ErrInvalidTokenCount {
+		t.Fatalf("Expected %v for a non-positive count but got %v", ErrInvalidTokenCount, err)
+	}
+	if err := m.ReturnToken("user2"); !errors.Is(err, ErrRuleDoesNotExist) {
+		t.Fatalf("Expected %v for a missing rule but got %v", ErrRuleDoesNotExist, err)
+	}
+}
+
+func TestQuotaSetTokens(t *testing.T) {
+	m := NewManager()
+
+	user := "user1"
+	m.AddRule(user, NewRule(1, 5*time.Second))
+	m.UseTokens(user, 5)
+
+	if err := m.AddTokens(user, 2); err != nil {
+		t.Fatalf("Did not expect an error on valid user, %v", err)
+	}
+	if remaining, _ := m.Remaining(user); remaining != 2 {
+		t.Fatalf("Expected 2 tokens after adding 2 but got %d", remaining)
+	}
+	m.AddTokens(user, 10)
+	if remaining, _ := m.Remaining(user); remaining != 5 {
+		t.Fatalf("Expected added tokens to be capped at 5 but got %d", remaining)
+	}
+
+	for _, tc := range []struct{ n, expected int }{{3, 3}, {-1, 0}, {100, 5}} {
+		if err := m.SetTokens(user, tc.n); err != nil {
+			t.Fatalf("Did not expect an error on valid user, %v", err)
+		}
+		if remaining, _ := m.Remaining(user); remaining != tc.expected {
+			t.Fatalf("Expected setting %d tokens to leave %d but got %d", tc.n, tc.expected, remaining)
+		}
+	}
+
+	if err := m.AddTokens(user, 0); err != ErrInvalidTokenCount {
+		t.Fatalf("Expected %v for a non-positive count but got %v", ErrInvalidTokenCount, err)
+	}
+	if err := m.AddTokens("user2", 1); !errors.Is(err, ErrRuleDoesNotExist) {
+		t.Fatalf("Expected %v for a missing rule but got %v", ErrRuleDoesNotExist, err)
+	}
+	if err := m.SetTokens("user2", 1); !errors.Is(err, ErrRuleDoesNotExist) {
+		t.Fatalf("Expected %v for a missing rule but got %v", ErrRuleDoesNotExist, err)
+	}
+}
+
+func TestQuotaWaitToken(t *testing.T) {
+	m := NewManager()
+	m.Run()
+	defer m.Stop()
+
+	user := "user1"
+	m.AddRule(user, NewRule(1, 1*time.Second))
+	m.UseToken(user)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	if err := m.WaitToken(ctx, user); err != nil {
+		t.Fatalf("Did not expect an error waiting for a token, %v", err)
+	}
+
+	if err := m.WaitToken(ctx, "user2"); !errors.Is(err, ErrRuleDoesNotExist) {
+		t.Fatalf("Expected %v for a missing rule but got %v", ErrRuleDoesNotExist, err)
+	}
+}
+
+func TestQuotaUpdateRate(t *testing.T) {
+	m := NewManager(WithUpdateRate(10 * time.Millisecond))
+	m.Run()
+	defer m.Stop()
+
+	user := "user1"
+	r := NewRule(100, 100*time.Millisecond)
+	m.AddRule(user, r)
+	if err := m.UseTokens(user, 10); err != nil {
+		t.Fatalf("Did not expect an error on valid user, %v", err)
+	}
+
+	if r.addTokens != 1 {
+		t.Fatalf("Expected 1 token per 10ms refill at 100 qps but got %v", r.addTokens)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	for i := 0; i < 3; i++ {
+		if err := m.WaitToken(ctx, user); err != nil {
+			t.Fatalf("Did not expect an error waiting for a token, %v", err)
+		}
+	}
+
+	if NewManager(WithUpdateRate(0)).updateRate != UpdateRate {
+		t.Fatalf("Expected a non-positive update rate to fall back to %v", UpdateRate)
+	}
+}
+
+func TestQuotaWaitTokenDeadline(t *testing.T) {
 	m := NewManager()
+
+	user := "user1"
+	m.AddRule(user, NewRule(1, 1*time.Second))
+	m.UseToken(user)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if err := m.WaitToken(ctx, user); err != context.DeadlineExceeded {
+		t.Fatalf("Expected %v waiting on an exhausted rule but got %v", context.DeadlineExceeded, err)
+	}
+}
+
+func TestQuotaUpdateRule(t *testing.T) {
+	m := NewManager()
+
+	user := "user1"
+	m.AddRule(user, NewRule(1, 10*time.Second))
+	for i := 0; i < 5; i++ {
+		if err := m.UseToken(user); err != nil {
+			t.Fatalf("Did not expect an error on valid user, %v", err)
+		}
+	}
+
+	r := NewRule(2, 2*time.Second)
+	if err := m.UpdateRule(user, r); err != nil {
+		t.Fatalf("Did not expect an error updating a valid user, %v", err)
+	}
+	if r.tokens() != 2 {
+		t.Fatalf("Expected half of the new rule's 4 tokens to be available but got %d", r.tokens())
+	}
+
+	if err := m.UpdateRule("user2", NewRule(1, time.Second)); !errors.Is(err, ErrRuleDoesNotExist) {
+		t.Fatalf("Expected %v updating a missing rule but got %v", ErrRuleDoesNotExist, err)
+	}
+}
+
+func TestQuotaSetQPS(t *testing.T) {
+	m := NewManager()
+
+	user := "user1"
+	m.AddRule(user, NewRule(1, 10*time.Second))
+	m.UseTokens(user, 5)
+
+	r, err := m.SetQPS(user, 2)
+	if err != nil {
+		t.Fatalf("Did not expect an error setting the qps of a valid user, %v", err)
+	}
+	if r.QPS() != 2 || r.Max() != 20 {
+		t.Fatalf("Expected 2 qps holding 20 tokens but got %v", r)
+	}
+	if remaining, _ := m.Remaining(user); remaining != 10 {
+		t.Fatalf("Expected half of the 20 tokens to be available but got %d", remaining)
+	}
+
+	if _, err := m.SetQPS(user, 0); !errors.Is(err, ErrInvalidRule) {
+		t.Fatalf("Expected %v for a non-positive qps but got %v", ErrInvalidRule, err)
+	}
+	if r.QPS() != 2 {
+		t.Fatalf("Did not expect an invalid qps to change the rule but got %v", r)
+	}
+	if _, err := m.SetQPS("user2", 1); !errors.Is(err, ErrRuleDoesNotExist) {
+		t.Fatalf("Expected %v for a missing rule but got %v", ErrRuleDoesNotExist, err)
+	}
+	m.AddRule("sliding", NewSlidingWindowRule(1, time.Second))
+	if _, err := m.SetQPS("sliding", 1); !errors.Is(err, ErrInvalidRule) {
+		t.Fatalf("Expected %v for a rule which is not a *Rule but got %v", ErrInvalidRule, err)
+	}
+}
+
+func TestQuotaSetWindow(t *testing.T) {
+	m := NewManager()
+
+	m.AddRule("user1", NewRule(2, 10*time.Second))
+	m.UseTokens("user1", 15)
+	r, err := m.SetWindow("user1", 2*time.Second)
+	if err != nil {
+		t.Fatalf("Did not expect an error setting the window of a valid user, %v", err)
+	}
+	if r.Window() != 2*time.Second || r.Burst() != 4 {
+		t.Fatalf("Expected a 2s window bursting to 4 tokens but got %v", r)
+	}
+	if remaining, _ := m.Remaining("user1"); remaining != 1 {
+		t.Fatalf("Expected a quarter of the 4 tokens to be available but got %d", remaining)
+	}
+
+	m.AddRule("user2", NewRuleWithBurst(1, 10*time.Second, 3))
+	if r, _ := m.SetWindow("user2", 20*time.Second); r.Burst() != 3 || r.Remaining() != 3 {
+		t.Fatalf("Expected an explicit burst of 3 to be kept but got %v", r)
+	}
+	if _, err := m.SetWindow("user1", 0); !errors.Is(err, ErrInvalidRule) {
+		t.Fatalf("Expected %v for a non-positive window but got %v", ErrInvalidRule, err)
+	}
+}
+
+func TestQuotaReset(t *testing.T) {
+	m := NewManager()
+
+	m.AddRule("user1", NewRule(1, 3*time.Second))
+	m.AddRule("user2", NewRuleWithBurst(1, 3*time.Second, 5))
+	m.UseTokens("user1", 3)
+	m.UseTokens("user2", 4)
+
+	if err := m.Reset("user1"); err != nil {
+		t.Fatalf("Did not expect an error resetting a valid user, %v", err)
+	}
+	if remaining, _ := m.Remaining("user1"); remaining != 3 {
+		t.Fatalf("Expected a drained rule to be full after a reset but got %d", remaining)
+	}
+	if remaining, _ := m.Remaining("user2"); remaining != 1 {
+		t.Fatalf("Did not expect resetting one rule to refill another but got %d", remaining)
+	}
+	if err := m.Reset("user3"); !errors.Is(err, ErrRuleDoesNotExist) {
+		t.Fatalf("Expected %v for a missing rule but got %v", ErrRuleDoesNotExist, err)
+	}
+
+	m.UseTokens("user1", 3)
+	m.ResetAll()
+	for key, expected := range map[string]int{"user1": 3, "user2": 5} {
+		if remaining, _ := m.Remaining(key); remaining != expected {
+			t.Fatalf("Expected %s to be full with %d tokens after resetting all but got %d", key, expected, remaining)
+		}
+	}
+}
+
+func TestQuotaAllowAt(t *testing.T) {
+	clock := newFakeClock()
+	m := NewManagerLazy(WithClock(clock))
+
+	user := "user1"
+	m.AddRule(user, NewRule(1, 2*time.Second))
+	m.UseTokens(user, 2)
+
+	start := clock.Now()
+	for i, tc := range []struct {
+		at      time.Duration
+		allowed bool
+	}{
+		{0, false},
+		{time.Second, true},
+		{time.Second, false},
+		{1500 * time.Millisecond, false},
+		{2 * time.Second, true},
+		{5 * time.Second, true},
+		{5 * time.Second, true},
+		{5 * time.Second, false},
+		{4 * time.Second, false},
+	} {
+		allowed, err := m.AllowAt(user, start.Add(tc.at))
+		if err != nil {
+			t.Fatalf("Did not expect an error replaying a valid user, %v", err)
+		}
+		if allowed != tc.allowed {
+			t.Fatalf("Expected use %d at %v to be allowed %t but got %t", i, tc.at, tc.allowed, allowed)
+		}
+	}
+
+	if stats, _ := m.Stats(user); stats.Allowed != 5 || stats.Denied != 5 {
+		t.Fatalf("Expected 5 allowed including the initial use and 5 denied but got %+v", stats)
+	}
+	if _, err := m.AllowAt("user2", start); !errors.Is(err, ErrRuleDoesNotExist) {
+		t.Fatalf("Expected %v for a missing rule but got %v", ErrRuleDoesNotExist, err)
+	}
+}
+
+func TestQuotaClose(t *testing.T) {
+	clock := newFakeClock()
+	m := NewManager(WithClock(clock))
+	m.Run()
+	m.AddRule("user1", NewRule(1, 5*time.Second))
+
+	var closer io.Closer = m
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 10; j++ {
+				if err := m.UseToken("user1"); err != nil && err != ErrClosed && !errors.Is(err, ErrQuotaExceeded) {
+					t.Errorf("Did not expect an error using tokens while closing, %v", err)
+				}
+			}
+		}()
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := closer.Close(); err != nil {
+				t.Errorf("Did not expect an error closing, %v", err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	if clock.Waiters() != 0 {
+		t.Fatalf("Expected the refill ticker to be stopped but got %d waiters", clock.Waiters())
+	}
+	if err := m.UseToken("user1"); err != ErrClosed {
+		t.Fatalf("Expected %v once closed but got %v", ErrClosed, err)
+	}
+	if _, err := m.AllowAt("user1", clock.Now()); err != ErrClosed {
+		t.Fatalf("Expected %v replaying a use once closed but got %v", ErrClosed, err)
+	}
+	m.Run()
+	if clock.Waiters() != 0 {
+		t.Fatalf("Did not expect a closed manager to run but got %d waiters", clock.Waiters())
+	}
+}
+
+func TestQuotaDisable(t *testing.T) {
+	m := NewManager()
+
+	user := "user1"
+	m.AddRule(user, NewRule(1, 3*time.Second))
+	m.UseTokens(user, 2)
+
+	if err := m.Disable(user); err != nil {
+		t.Fatalf("Did not expect an error on valid user, %v", err)
+	}
+	for i := 0; i < 5; i++ {
+		if err := m.UseToken(user); err != nil {
+			t.Fatalf("Did not expect an error while disabled, %v", err)
+		}
+	}
+	if remaining, _ := m.Remaining(user); remaining != 1 {
+		t.Fatalf("Expected no tokens to be used while disabled but got %d remaining", remaining)
+	}
+
+	if err := m.Enable(user); err != nil {
+		t.Fatalf("Did not expect an error on valid user, %v", err)
+	}
+	m.UseToken(user)
+	if err := m.UseToken(user); !errors.Is(err, ErrQuotaExceeded) {
+		t.Fatalf("Expected %v once enabled but got %v", ErrQuotaExceeded, err)
+	}
+
+	if err := m.Disable("user2"); !errors.Is(err, ErrRuleDoesNotExist) {
+		t.Fatalf("Expected %v for a missing rule but got %v", ErrRuleDoesNotExist, err)
+	}
+	if err := m.Enable("user2"); !errors.Is(err, ErrRuleDoesNotExist) {
+		t.Fatalf("Expected %v for a missing rule but got %v", ErrRuleDoesNotExist, err)
+	}
+}
+
+func TestQuotaKeys(t *testing.T) {
+	m := NewManager()
+	expected := map[string]bool{"user1": true, "user2": true, "user3": true}
+	for key := range expected {
+		m.AddRule(key, NewRule(1, 1*time.Second))
+	}
+
+	keys := m.Keys()
+	if len(keys) != len(expected) {
+		t.Fatalf("Expected %d keys but got %v", len(expected), keys)
+	}
+	for _, key := range keys {
+		if !expected[key] {
+			t.Fatalf("Did not expect key %s", key)
+		}
+	}
+
+	var visited int
+	m.Range(func(key string, r Limiter) bool {
+		visited++
+		return false
+	})
+	if visited != 1 {
+		t.Fatalf("Expected Range to stop after the first rule but visited %d", visited)
+	}
+}
+
+func TestQuotaLenClear(t *testing.T) {
+	m := NewManager()
+	m.Run()
+	defer m.Stop()
+
+	for i := 0; i < 100; i++ {
+		m.AddRule("user"+strconv.Itoa(i), NewRule(1, 5*time.Second))
+	}
+	if m.Len() != 100 {
+		t.Fatalf("Expected 100 rules but got %d", m.Len())
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(2)
+		go func(i int) {
+			defer wg.Done()
+			for j := 0; j < 100; j++ {
+				err := m.UseToken("user" + strconv.Itoa(j))
+				if err != nil && !errors.Is(err, ErrRuleDoesNotExist) && !errors.Is(err, ErrQuotaExceeded) {
+					t.Errorf("Did not expect an error using a token, %v", err)
+				}
+			}
+		}(i)
+		go func() {
+			defer wg.Done()
+			m.Clear()
+		}()
+	}
+	wg.Wait()
+
+	if m.Len() != 0 {
+		t.Fatalf("Expected no rules after clearing but got %d", m.Len())
+	}
+	m.AddRule("user1", NewRule(1, 5*time.Second))
+	if err := m.UseToken("user1"); err != nil {
+		t.Fatalf("Did not expect an error on a rule added after clearing, %v", err)
+	}
+	if m.Len() != 1 {
+		t.Fatalf("Expected 1 rule but got %d", m.Len())
+	}
+}
+
+func TestQuotaStop(t *testing.T) {
+	m := NewManager()
+	m.Run()
+	m.Run()
+
+	user := "user1"
+	m.AddRule(user, NewRule(1, 5*time.Second))
+
+	m.Stop()
+	m.Stop()
+
+	if err := m.UseToken(user); err != nil {
+		t.Fatalf("Did not expect an error after stopping the manager, %v", err)
+	}
+
+	// the manager can be restarted after it has been stopped
+	m.Run()
+	m.Stop()
+}
+
+func TestQuotaLazy(t *testing.T) {
+	clock := newFakeClock()
+	m := NewManagerLazy(WithClock(clock))
+	m.Run()
+
+	user := "user1"
+	m.AddRule(user, NewRule(100, 100*time.Millisecond))
+	if err := m.UseTokens(user, 10); err != nil {
+		t.Fatalf("Did not expect an error on valid user, %v", err)
+	}
+	if err := m.UseToken(user); !errors.Is(err, ErrQuotaExceeded) {
+		t.Fatalf("Expected %v for a drained rule but got %v", ErrQuotaExceeded, err)
+	}
+
+	clock.Advance(30 * time.Millisecond)
+	if remaining, _ := m.Remaining(user); remaining != 3 {
+		t.Fatalf("Expected 3 tokens to accrue lazily after 30ms but got %d", remaining)
+	}
+	if clock.Waiters() != 0 {
+		t.Fatalf("Did not expect a lazy manager to start a ticker")
+	}
+}
+
+func TestQuotaClockRefill(t *testing.T) {
+	clock := newFakeClock()
+	m := NewManager(WithClock(clock))
+	m.Run()
+	defer m.Stop()
+
+	user := "user1"
+	m.AddRule(user, NewRule(1, 5*time.Second))
+	m.UseTokens(user, 5)
+
+	clock.Advance(UpdateRate)
+	deadline := time.Now().Add(time.Second)
+	for {
+		remaining, _ := m.Remaining(user)
+		if remaining == 1 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("Expected a single refill from the fake ticker but got %d tokens", remaining)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestQuotaRunTwice(t *testing.T) {
+	clock := newFakeClock()
+	m := NewManager(WithClock(clock))
+	m.Run()
+	m.Run()
+	defer m.Stop()
+
+	if clock.Waiters() != 1 {
+		t.Fatalf("Expected a single ticker after running twice but got %d", clock.Waiters())
+	}
+
+	user := "user1"
+	m.AddRule(user, NewRule(1, 5*time.Second))
+	m.UseTokens(user, 5)
+
+	clock.Advance(UpdateRate)
+	deadline := time.Now().Add(time.Second)
+	for remaining, _ := m.Remaining(user); remaining == 0; remaining, _ = m.Remaining(user) {
+		if time.Now().After(deadline) {
+			t.Fatalf("Expected a refill from the fake ticker")
+		}
+		time.Sleep(time.Millisecond)
+	}
+	time.Sleep(10 * time.Millisecond)
+	if remaining, _ := m.Remaining(user); remaining != 1 {
+		t.Fatalf("Expected a single refill per tick after running twice but got %d tokens", remaining)
+	}
+}
+
+func TestQuotaRunContext(t *testing.T) {
+	clock := newFakeClock()
+	m := NewManager(WithClock(clock))
+
+	user := "user1"
+	m.AddRule(user, NewRule(1, 5*time.Second))
+	m.UseTokens(user, 5)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	errc := make(chan error, 1)
+	go func() {
+		errc <- m.RunContext(ctx)
+	}()
+	for clock.Waiters() == 0 {
+		time.Sleep(time.Millisecond)
+	}
+	cancel()
+
+	select {
+	case err := <-errc:
+		if err != context.Canceled {
+			t.Fatalf("Expected %v once the context was cancelled but got %v", context.Canceled, err)
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("Expected RunContext to return once the context was cancelled")
+	}
+	if clock.Waiters() != 0 {
+		t.Fatalf("Expected the ticker to be stopped once the context was cancelled")
+	}
+	clock.Advance(10 * UpdateRate)
+	time.Sleep(10 * time.Millisecond)
+	if remaining, _ := m.Remaining(user); remaining != 0 {
+		t.Fatalf("Did not expect refills once the context was cancelled but got %d tokens", remaining)
+	}
+
+	go func() {
+		errc <- m.RunContext(context.Background())
+	}()
+	for clock.Waiters() == 0 {
+		time.Sleep(time.Millisecond)
+	}
+	m.Stop()
+	if err := <-errc; err != nil {
+		t.Fatalf("Did not expect an error when stopped, %v", err)
+	}
+}
+
+func TestQuotaWaitTokenClock(t *testing.T) {
+	clock := newFakeClock()
+	m := NewManagerLazy(WithClock(clock))
+
+	user := "user1"
+	m.AddRule(user, NewRule(1, 1*time.Second))
+	m.UseToken(user)
+
+	errc := make(chan error, 1)
+	go func() {
+		errc <- m.WaitToken(context.Background(), user)
+	}()
+	for clock.Waiters() == 0 {
+		time.Sleep(time.Millisecond)
+	}
+	clock.Advance(UpdateRate)
+
+	select {
+	case err := <-errc:
+		if err != nil {
+			t.Fatalf("Did not expect an error waiting for a token, %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("Expected WaitToken to return once the clock advanced")
+	}
+}
+
+func TestRuleLazyRefill(t *testing.T) {
+	r := NewRule(2, 5*time.Second)
+	now := time.Now()
+	r.SetSchedule(Schedule{Now: now, Lazy: true, UpdateRate: UpdateRate})
+	r.UseTokens(r.maxQueries)
+
+	for _, tc := range []struct {
+		elapsed  time.Duration
+		expected int
+	}{
+		{0, 0},
+		{1500 * time.Millisecond, 3},
+		{1750 * time.Millisecond, 3},
+		{2 * time.Second, 4},
+		{time.Minute, 10},
+	} {
+		r.Refill(now.Add(tc.elapsed))
+		if r.tokens() != tc.expected {
+			t.Fatalf("Expected %d tokens after %v but got %d", tc.expected, tc.elapsed, r.tokens())
+		}
+	}
+}
+
+func TestNewRuleChecked(t *testing.T) {
+	for _, tc := range []struct {
+		qps    int
+		window time.Duration
+		valid  bool
+	}{
+		{2, 5 * time.Second, true},
+		{0, 5 * time.Second, false},
+		{-1, 5 * time.Second, false},
+		{2, 0, false},
+		{2, -time.Second, false},
+		{1, 100 * time.Millisecond, false},
+		{math.MaxInt32, 24 * time.Hour, false},
+	} {
+		r, err := NewRuleChecked(tc.qps, tc.window)
+		if tc.valid && (err != nil || r == nil) {
+			t.Fatalf("Did not expect an error for %d qps over %v, %v", tc.qps, tc.window, err)
+		}
+		if !tc.valid && !errors.Is(err, ErrInvalidRule) {
+			t.Fatalf("Expected %v for %d qps over %v but got %v", ErrInvalidRule, tc.qps, tc.window, err)
+		}
+	}
+}
+
+func TestRuleOverflow(t *testing.T) {
+	for _, tc := range []struct {
+		qps       int
+		window    time.Duration
+		max       int
+		addTokens float64
+	}{
+		{math.MaxInt32, 24 * time.Hour, MaxTokens, MaxTokens},
+		{math.MaxInt32, time.Second, MaxTokens, MaxTokens},
+		{1000000, 30 * 24 * time.Hour, MaxTokens, 1000000},
+		{-5, time.Second, 0, 0},
+		{5, -time.Second, 0, 5},
+	} {
+		r := NewRule(tc.qps, tc.window)
+		if r.maxQueries != tc.max || r.tokens() != tc.max || r.addTokens != tc.addTokens {
+			t.Fatalf("Expected %d qps over %v to clamp to %d max and %v per refill but got %d, %d and %v",
+				tc.qps, tc.window, tc.max, tc.addTokens, r.maxQueries, r.tokens(), r.addTokens)
+		}
+		r.UseTokens(1)
+		r.AddToken()
+		if r.tokens() < 0 || r.tokens() > r.burst {
+			t.Fatalf("Expected count to stay within [0, %d] but got %d", r.burst, r.tokens())
+		}
+	}
+}
+
+func TestRuleCountSaturates(t *testing.T) {
+	r := NewRule(1000000, 30*24*time.Hour)
+	max := r.Max()
+
+	r.ReturnTokens(math.MaxInt)
+	if r.tokens() != max {
+		t.Fatalf("Expected returning tokens to a full rule to leave %d but got %d", max, r.tokens())
+	}
+	r.Borrow(math.MaxInt)
+	if r.tokens() != -max {
+		t.Fatalf("Expected borrowing to stop at %d but got %d", -max, r.tokens())
+	}
+	r.Borrow(math.MaxInt)
+	r.ReturnTokens(math.MaxInt)
+	if r.tokens() != max {
+		t.Fatalf("Expected returning tokens to a borrowed rule to saturate at %d but got %d", max, r.tokens())
+	}
+	r.ReturnTokens(math.MinInt)
+	if r.tokens() != -max {
+		t.Fatalf("Expected returning negative tokens to stop at %d but got %d", -max, r.tokens())
+	}
+
+	for _, n := range []int{math.MinInt, -1, 0, max, max + 1, math.MaxInt} {
+		r.SetTokens(n)
+		if r.tokens() < 0 || r.tokens() > max {
+			t.Fatalf("Expected setting %d tokens to stay within [0, %d] but got %d", n, max, r.tokens())
+		}
+	}
+	if r.UseTokens(-1) || r.tokens() != max {
+		t.Fatalf("Did not expect a negative token use to add tokens but got %d", r.tokens())
+	}
+	if r.UseTokens(math.MaxInt) || !r.UseTokens(max) || r.tokens() != 0 {
+		t.Fatalf("Expected only the tokens available to be used but got %d", r.tokens())
+	}
+}
+
+func TestRuleCountSaturatesConcurrently(t *testing.T) {
+	r := NewRule(1, 10*time.Second)
+	max := r.Max()
+
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			for j := 0; j < 1000; j++ {
+				switch (i + j) % 4 {
+				case 0:
+					r.ReturnTokens(math.MaxInt)
+				case 1:
+					r.UseTokens(1)
+				case 2:
+					r.Borrow(math.MaxInt / 2)
+				case 3:
+					r.SetTokens(math.MinInt)
+				}
+				if count := r.tokens(); count < -max || count > max {
+					t.Errorf("Expected count to stay within [%d, %d] but got %d", -max, max, count)
+					return
+				}
+			}
+		}(i)
+	}
+	wg.Wait()
+}
+
+func TestRuleWithBurst(t *testing.T) {
+	r := NewRuleWithBurst(10, 1*time.Second, 50)
+	if r.Burst() != 50 || r.tokens() != 50 {
+		t.Fatalf("Expected a burst of 50 tokens but got burst %d and count %d", r.Burst(), r.tokens())
+	}
+	if !r.UseTokens(50) {
+		t.Fatalf("Expected the full burst to be usable at once")
+	}
+
+	for i := 1; i <= 6; i++ {
+		r.AddToken()
+		if expected := 10 * i; i < 6 && r.tokens() != expected {
+			t.Fatalf("Expected refills at 10 qps to reach %d but got %d", expected, r.tokens())
+		}
+	}
+	if r.tokens() != 50 {
+		t.Fatalf("Expected refills to be capped at the burst of 50 but got %d", r.tokens())
+	}
+
+	if r := NewRuleWithBurst(10, 2*time.Second, 0); r.Burst() != 20 {
+		t.Fatalf("Expected the burst to default to the window's 20 queries but got %d", r.Burst())
+	}
+}
+
+func TestRuleFractionalRefill(t *testing.T) {
+	// refilling every 500ms at 1 qps adds half a token at a time
+	r := newRule(1, 2*time.Second, 500*time.Millisecond)
+	for r.UseTokens(1) {
+	}
+
+	expected := []int{0, 1, 1, 2, 2, 2}
+	for i, count := range expected {
+		r.AddToken()
+		if r.tokens() != count {
+			t.Fatalf("Expected %d tokens after refill %d but got %d", count, i+1, r.tokens())
+		}
+	}
+}
+
+func TestRuleRate(t *testing.T) {
+	r := NewRuleRate(0.5, 10*time.Second)
+	if r.Max() != 5 || r.QPS() != 0 || r.Rate() != 0.5 {
+		t.Fatalf("Expected 5 tokens at a rate of 0.5 but got %d tokens at %v", r.Max(), r.Rate())
+	}
+	for r.UseTokens(1) {
+	}
+
+	expected := []int{0, 1, 1, 2}
+	for i, count := range expected {
+		r.AddToken()
+		if r.tokens() != count {
+			t.Fatalf("Expected %d tokens after refill %d but got %d", count, i+1, r.tokens())
+		}
+	}
+
+	now := time.Now()
+	r.SetSchedule(Schedule{Now: now, Lazy: true, UpdateRate: UpdateRate})
+	if delay, _, _ := r.RetryAfter(3, Schedule{Now: now, Lazy: true}); delay != 2*time.Second {
+		t.Fatalf("Expected to wait 2s for a token at a rate of 0.5 but got %v", delay)
+	}
+	r.Refill(now.Add(3 * time.Second))
+	if r.tokens() != 3 {
+		t.Fatalf("Expected 3 tokens after 3s at a rate of 0.5 but got %d", r.tokens())
+	}
+}
+
+func TestRuleRollover(t *testing.T) {
+	// 10 queries per 10 second window carrying over at most 5 unused
+	r := NewRule(1, 10*time.Second, WithRollover(5))
+	now := time.Now()
+	r.SetSchedule(Schedule{Now: now, UpdateRate: UpdateRate})
+
+	r.UseTokens(7)
+	r.AddToken()
+	if r.tokens() != 3 {
+		t.Fatalf("Expected no refill between window boundaries but got %d tokens", r.tokens())
+	}
+	if _, _, ok := r.RetryAfter(r.Max()+1, Schedule{Now: now}); ok {
+		t.Fatalf("Expected more than %d tokens to never be available", r.Max())
+	}
+	if delay, _, _ := r.RetryAfter(4, Schedule{Now: now.Add(4 * time.Second)}); delay != 6*time.Second {
+		t.Fatalf("Expected to wait 6s for the next window but got %v", delay)
+	}
+
+	for _, tc := range []struct {
+		elapsed  time.Duration
+		use      int
+		expected int
+	}{
+		{9 * time.Second, 0, 3},
+		{10 * time.Second, 13, 13}, // 3 left over
+		{25 * time.Second, 0, 10},  // nothing left over
+		{30 * time.Second, 0, 15},  // carry capped at 5
+		{55 * time.Second, 0, 15},
+	} {
+		r.Refill(now.Add(tc.elapsed))
+		if r.tokens() != tc.expected {
+			t.Fatalf("Expected %d tokens after %v but got %d", tc.expected, tc.elapsed, r.tokens())
+		}
+		r.UseTokens(tc.use)
+	}
+	if r.Max() != 15 {
+		t.Fatalf("Expected the rule to hold its burst plus carry of 15 tokens but got %d", r.Max())
+	}
+}
+
+func TestQuotaRollover(t *testing.T) {
+	clock := newFakeClock()
+	m := NewManager(WithClock(clock))
+
+	user := "user1"
+	m.AddRule(user, NewRuleWithBurst(1, 10*time.Second, 4, WithRollover(2)))
+	m.UseTokens(user, 3)
+
+	clock.Advance(10 * time.Second)
+	if remaining, _ := m.Remaining(user); remaining != 5 {
+		t.Fatalf("Expected the burst of 4 plus 1 carried over but got %d", remaining)
+	}
+	m.UseTokens(user, 5)
+	err := m.UseToken(user)
+	var qerr *QuotaExceededError
+	if !errors.As(err, &qerr) || qerr.RetryAfter != 10*time.Second {
+		t.Fatalf("Expected %v retrying after the next window but got %v", ErrQuotaExceeded, err)
+	}
+}
+
+func TestQuotaHashCollision(t *testing.T) {
+	defer func(h func(string) uint64) { hashKey = h }(hashKey)
+	hashKey = func(string) uint64 { return 1 }
+
+	m := NewManager()
+	m.AddRule("user1", NewRule(1, 1*time.Second))
+	m.AddRule("user2", NewRule(2, 1*time.Second))
+	m.AddRule("user3", NewRule(3, 1*time.Second))
+
+	for _, tc := range []struct {
+		key string
+		qps int
+	}{
+		{"user1", 1},
+		{"user2", 2},
+		{"user3", 3},
+	} {
+		r, err := m.GetRule(tc.key)
+		if err != nil {
+			t.Fatalf("Did not expect an error on colliding user %s, %v", tc.key, err)
+		}
+		if r.(*Rule).QPS() != tc.qps {
+			t.Fatalf("Expected %s to have its own rule with qps %d but got %d", tc.key, tc.qps, r.(*Rule).QPS())
+		}
+	}
+
+	m.UseToken("user1")
+	if err := m.UseToken("user1"); !errors.Is(err, ErrQuotaExceeded) {
+		t.Fatalf("Expected %v for user1 but got %v", ErrQuotaExceeded, err)
+	}
+	if err := m.UseToken("user2"); err != nil {
+		t.Fatalf("Colliding user2 should not share a quota with user1, %v", err)
+	}
+
+	if err := m.RemoveRule("user2"); err != nil {
+		t.Fatalf("Did not expect an error removing colliding user2, %v", err)
+	}
+	if _, err := m.GetRule("user2"); !errors.Is(err, ErrRuleDoesNotExist) {
+		t.Fatalf("Expected %v for removed user2 but got %v", ErrRuleDoesNotExist, err)
+	}
+	for _, key := range []string{"user1", "user3"} {
+		if _, err := m.GetRule(key); err != nil {
+			t.Fatalf("Removing user2 should not remove %s, %v", key, err)
+		}
+	}
+	if _, err := m.GetRule("user4"); !errors.Is(err, ErrRuleDoesNotExist) {
+		t.Fatalf("Expected %v for an unknown colliding user but got %v", ErrRuleDoesNotExist, err)
+	}
+}
+
+func TestQuotaHashStable(t *testing.T) {
+	// rules are keyed by the same hash that the streaming hasher produced
+	key := "user1"
+	h := xxhash.New64()
+	h.WriteString(key)
+	if h.Sum64() != xxhash.ChecksumString64(key) {
+		t.Fatalf("Expected stateless hash to match streaming hash for %s", key)
+	}
+}
+
+func TestQuotaConcurrentUseSingleKey(t *testing.T) {
+	// the fake clock never refills, so exactly the rule's tokens are used
+	m := NewManager(WithClock(newFakeClock()))
+	m.Run()
+	defer m.Stop()
+
+	user := "user1"
+	m.AddRule(user, NewRule(100, 10*time.Second))
+
+	var wg sync.WaitGroup
+	for i := 0; i < 50; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 100; j++ {
+				m.UseToken(user)
+				m.Remaining(user)
+			}
+		}()
+	}
+	wg.Wait()
+
+	stats, _ := m.Stats(user)
+	if stats.Allowed != 1000 || stats.Denied != 4000 || stats.Current != 0 {
+		t.Fatalf("Expected 1000 allowed, 4000 denied and no tokens left but got %+v", stats)
+	}
+}
+
+func BenchmarkQuotaUseSingleKeyParallel(b *testing.B) {
+	m := NewManager()
+	m.Run()
+	defer m.Stop()
+
+	user := "user1"
+	m.AddRule(user, NewRule(math.MaxInt32/1000, 1000*time.Second))
+	m.UseToken(user)
+
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			m.UseToken(user)
+		}
+	})
+}
+
+func BenchmarkQuotaUpdateMillionKeys(b *testing.B) {
+	m := NewManager()
+
+	for i := 0; i < 1000000; i++ {
+		m.AddRule(strconv.Itoa(i), NewRule(1, 30*time.Second))
+	}
+	b.ResetTimer()
+	for n := 0; n < b.N; n++ {
+		m.addTokens()
+	}
+}
+
+func BenchmarkQuotaUpdateMillionKeysMostlyIdle(b *testing.B) {
+	m := NewManager()
+
+	numKeys := 1000000
+	for i := 0; i < numKeys; i++ {
+		m.AddRule(strconv.Itoa(i), NewRule(1, 30*time.Second))
+	}
+	m.addTokens()
+
+	// 1% of keys are in use each refill
+	b.ResetTimer()
+	for n := 0; n < b.N; n++ {
+		b.StopTimer()
+		for i := 0; i < numKeys/100; i++ {
+			m.UseToken(strconv.Itoa((n*numKeys/100 + i) % numKeys))
+		}
+		b.StartTimer()
+		m.addTokens()
+	}
+}
+
+func BenchmarkQuotaReadHeavy(b *testing.B) {
+	m := NewManager()
+	m.Run()
+	defer m.Stop()
+
+	numKeys := 1024
+	keys := make([]string, numKeys)
+	for i := range keys {
+		keys[i] = strconv.Itoa(i)
+		m.AddRule(keys[i], NewRule(1000, 5*time.Second))
+	}
+
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		var i int
+		for pb.Next() {
+			key := keys[i%numKeys]
+			switch i % 10 {
+			case 0:
+				m.UseToken(key)
+			case 1, 2, 3:
+				m.GetRule(key)
+			default:
+				m.Remaining(key)
+			}
+			i++
+		}
+	})
+}
+
+func BenchmarkQuotaUseMillionKeys(b *testing.B) {
+	benchmarkQuotaUseMillionKeys(b, NewManager())
+}
+
+func BenchmarkQuotaUseMillionKeysSingleShard(b *testing.B) {
+	benchmarkQuotaUseMillionKeys(b, NewManagerWithShards(1))
+}
+
+func benchmarkQuotaUseMillionKeys(b *testing.B, m *Manager) {
 	m.Run()
+	defer m.Stop()
 
 	numKeys := 1000000
 	for i := 0; i < numKeys; i++ {
@@ -0,0 +1,116 @@
+package main
+
+import (
+	"container/heap"
+	"sync"
+	"time"
+)
+
+// reclaimer holds the reservations awaiting a commit, ordered by their deadlines, so the tokens of
+// those never committed can be returned
+type reclaimer struct {
+	sync.Mutex
+	pending []*Reservation
+}
+
+// CommitBy has the reservation's token returned to its rule as by Cancel unless Commit is called by t,
+// so a caller which crashes or loses track of the reservation does not leak capacity. A running
+// manager reclaims expired reservations on each refill, so tokens are returned within an update
+// interval of the deadline, or of the shortest refill interval with WithRefillQueue. Managers which
+// refill lazily reclaim them on the next Reserve instead. Calling CommitBy again moves the deadline.
+func (res *Reservation) CommitBy(t time.Time) {
+	if !res.ok {
+		return
+	}
+	res.s.Lock()
+	settled := res.canceled || res.committed
+	res.s.Unlock()
+	if !settled {
+		res.reclaim.schedule(res, t)
+	}
+}
+
+// Commit finalizes the use of the reserved token, after which it is never reclaimed and Cancel has no
+// effect
+func (res *Reservation) Commit() {
+	if !res.ok {
+		return
+	}
+	res.s.Lock()
+	if !res.canceled {
+		res.committed = true
+	}
+	res.s.Unlock()
+	res.reclaim.unschedule(res)
+}
+
+// schedule queues a reservation to be reclaimed at deadline, moving it if already queued
+func (rc *reclaimer) schedule(res *Reservation, deadline time.Time) {
+	rc.Lock()
+	res.deadline = deadline
+	if res.index >= 0 {
+		heap.Fix(rc, res.index)
+	} else {
+		heap.Push(rc, res)
+	}
+	rc.Unlock()
+}
+
+// unschedule removes a reservation from the queue if it is queued
+func (rc *reclaimer) unschedule(res *Reservation) {
+	rc.Lock()
+	if res.index >= 0 {
+		heap.Remove(rc, res.index)
+	}
+	rc.Unlock()
+}
+
+// expire returns the tokens of every reservation whose deadline is before or at now and has not been
+// committed or canceled
+func (rc *reclaimer) expire(now time.Time) {
+	var expired []*Reservation
+	rc.Lock()
+	for len(rc.pending) > 0 && !rc.pending[0].deadline.After(now) {
+		expired = append(expired, heap.Pop(rc).(*Reservation))
+	}
+	rc.Unlock()
+
+	// shards are locked once the reclaimer is released so the two are never held together
+	for _, res := range expired {
+		res.s.Lock()
+		if !res.canceled && !res.committed {
+			res.canceled = true
+			res.r.ReturnTokens(1)
+		}
+		res.s.Unlock()
+	}
+}
+
+func (rc *reclaimer) Len() int {
+	return len(rc.pending)
+}
+
+func (rc *reclaimer) Less(i, j int) bool {
+	return rc.pending[i].deadline.Before(rc.pending[j].deadline)
+}
+
+func (rc *reclaimer) Swap(i, j int) {
+	rc.pending[i], rc.pending[j] = rc.pending[j], rc.pending[i]
+	rc.pending[i].index = i
+	rc.pending[j].index = j
+}
+
+func (rc *reclaimer) Push(x interface{}) {
+	res := x.(*Reservation)
+	res.index = len(rc.pending)
+	rc.pending = append(rc.pending, res)
+}
+
+func (rc *reclaimer) Pop() interface{} {
+	last := len(rc.pending) - 1
+	res := rc.pending[last]
+	rc.pending[last] = nil
+	rc.pending = rc.pending[:last]
+	res.index = -1
+	return res
+}
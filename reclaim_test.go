@@ -0,0 +1,54 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestReservationCommitBy(t *testing.T) {
+	h := NewHarness(t)
+	h.AddRule("user1", NewRule(1, 10*time.Second))
+	h.UseTokens("user1", 8)
+
+	leaked, _ := h.Reserve("user1")
+	leaked.CommitBy(h.Clock.Now().Add(1500 * time.Millisecond))
+	committed, _ := h.Reserve("user1")
+	committed.CommitBy(h.Clock.Now().Add(time.Second))
+	committed.Commit()
+	h.AssertRemaining("user1", 0)
+
+	h.Advance(time.Second)
+	h.AssertRemaining("user1", 1)
+	h.Advance(time.Second)
+	h.AssertRemaining("user1", 3)
+
+	leaked.Cancel()
+	committed.Cancel()
+	h.AssertRemaining("user1", 3)
+	if n := h.reclaim.Len(); n != 0 {
+		t.Fatalf("Expected no reservations awaiting a commit but got %d", n)
+	}
+}
+
+func TestReservationCommitByLazy(t *testing.T) {
+	clock := newFakeClock()
+	m := NewManagerLazy(WithClock(clock))
+	m.AddRule("user1", NewRule(1, 3*time.Second))
+	m.AddRule("user2", NewRule(1, 10*time.Second))
+	m.UseTokens("user2", 9)
+
+	res, _ := m.Reserve("user2")
+	res.CommitBy(clock.Now().Add(time.Second))
+	res.CommitBy(clock.Now().Add(2 * time.Second))
+
+	clock.Advance(time.Second)
+	m.Reserve("user1")
+	if remaining, _ := m.Remaining("user2"); remaining != 1 {
+		t.Fatalf("Did not expect a reservation to be reclaimed before its moved deadline but got %d tokens", remaining)
+	}
+	clock.Advance(time.Second)
+	m.Reserve("user1")
+	if remaining, _ := m.Remaining("user2"); remaining != 3 {
+		t.Fatalf("Expected the next reservation to reclaim an expired one but got %d tokens", remaining)
+	}
+}
@@ -0,0 +1,101 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+// RedisEvaler runs a Lua script on a Redis server. Clients such as go-redis can be adapted with a
+// small wrapper:
+//
+//	type evaler struct{ *redis.Client }
+//
+//	func (c evaler) Eval(ctx context.Context, script string, keys []string, args ...interface{}) (interface{}, error) {
+//		return c.Client.Eval(ctx, script, keys, args...).Result()
+//	}
+type RedisEvaler interface {
+	Eval(ctx context.Context, script string, keys []string, args ...interface{}) (interface{}, error)
+}
+
+// redisTokenBucket refills and uses tokens from a bucket stored as a hash of its tokens and the time
+// in milliseconds they were last refilled. It returns whether the tokens were used and how many
+// milliseconds until they are expected to be available, or -1 if they never will be.
+const redisTokenBucket = `
+local rate = tonumber(ARGV[1])
+local burst = tonumber(ARGV[2])
+local n = tonumber(ARGV[3])
+local now = tonumber(ARGV[4])
+
+local state = redis.call("HMGET", KEYS[1], "tokens", "ts")
+local tokens = tonumber(state[1])
+local ts = tonumber(state[2])
+if tokens == nil or ts == nil then
+	tokens = burst
+	ts = now
+end
+if now > ts then
+	tokens = math.min(burst, tokens + (now - ts) * rate / 1000)
+	ts = now
+end
+
+local ok = 0
+local wait = -1
+if tokens >= n then
+	tokens = tokens - n
+	ok = 1
+	wait = 0
+elseif n <= burst and rate > 0 then
+	wait = math.ceil((n - tokens) * 1000 / rate)
+end
+
+redis.call("HSET", KEYS[1], "tokens", tostring(tokens), "ts", ts)
+if rate > 0 then
+	redis.call("PEXPIRE", KEYS[1], math.ceil(burst * 1000 / rate) + 1000)
+end
+return {ok, wait}
+`
+
+// RedisBackend is a Backend storing token buckets in Redis. Each use runs a Lua script so that
+// refilling and using tokens is atomic across every manager sharing the server. Buckets are refilled
+// from the time of the manager using them, so the clocks of those managers should be synchronized.
+type RedisBackend struct {
+	client  RedisEvaler
+	prefix  string
+	timeout time.Duration
+}
+
+// NewRedisBackend creates a Backend storing each key's bucket in Redis under prefix followed by the
+// key. Each use is abandoned after timeout, while a non-positive timeout waits indefinitely.
+func NewRedisBackend(client RedisEvaler, prefix string, timeout time.Duration) *RedisBackend {
+	return &RedisBackend{client: client, prefix: prefix, timeout: timeout}
+}
+
+// UseTokens implements Backend
+func (b *RedisBackend) UseTokens(key string, rate float64, burst, n int, now time.Time) (bool, time.Duration, error) {
+	ctx := context.Background()
+	if b.timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, b.timeout)
+		defer cancel()
+	}
+
+	millis := now.UnixNano() / int64(time.Millisecond)
+	reply, err := b.client.Eval(ctx, redisTokenBucket, []string{b.prefix + key}, rate, burst, n, millis)
+	if err != nil {
+		return false, 0, err
+	}
+	values, ok := reply.([]interface{})
+	if !ok || len(values) != 2 {
+		return false, 0, fmt.Errorf("unexpected reply %v", reply)
+	}
+	used, ok1 := values[0].(int64)
+	wait, ok2 := values[1].(int64)
+	if !ok1 || !ok2 {
+		return false, 0, fmt.Errorf("unexpected reply %v", reply)
+	}
+	if wait < 0 {
+		return false, 0, nil
+	}
+	return used == 1, time.Duration(wait) * time.Millisecond, nil
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+// fakeEvaler records the last script evaluation and replies with a fixed value
+type fakeEvaler struct {
+	keys        []string
+	args        []interface{}
+	hasDeadline bool
+
+	reply interface{}
+	err   error
+}
+
+func (c *fakeEvaler) Eval(ctx context.Context, script string, keys []string, args ...interface{}) (interface{}, error) {
+	_, c.hasDeadline = ctx.Deadline()
+	c.keys = keys
+	c.args = args
+	return c.reply, c.err
+}
+
+func TestRedisBackend(t *testing.T) {
+	c := &fakeEvaler{reply: []interface{}{int64(1), int64(0)}}
+	b := NewRedisBackend(c, "quota:", time.Second)
+
+	now := time.Unix(10, 0)
+	ok, _, err := b.UseTokens("user1", 2, 10, 3, now)
+	if err != nil || !ok {
+		t.Fatalf("Expected tokens to be used but got %v, %v", ok, err)
+	}
+	if len(c.keys) != 1 || c.keys[0] != "quota:user1" {
+		t.Fatalf("Expected the prefixed key quota:user1 but got %v", c.keys)
+	}
+	if len(c.args) != 4 || c.args[0] != 2.0 || c.args[1] != 10 || c.args[2] != 3 || c.args[3] != int64(10000) {
+		t.Fatalf("Expected rate, burst, count and milliseconds as arguments but got %v", c.args)
+	}
+	if !c.hasDeadline {
+		t.Fatalf("Expected the timeout to set a deadline")
+	}
+
+	for _, tc := range []struct {
+		reply      interface{}
+		ok         bool
+		retryAfter time.Duration
+		err        bool
+	}{
+		{[]interface{}{int64(0), int64(1500)}, false, 1500 * time.Millisecond, false},
+		{[]interface{}{int64(0), int64(-1)}, false, 0, false},
+		{[]interface{}{int64(1)}, false, 0, true},
+		{"OK", false, 0, true},
+	} {
+		c.reply = tc.reply
+		ok, retryAfter, err := b.UseTokens("user1", 2, 10, 3, now)
+		if ok != tc.ok || retryAfter != tc.retryAfter || (err != nil) != tc.err {
+			t.Fatalf("Expected %v, %v and error %v for reply %v but got %v, %v and %v",
+				tc.ok, tc.retryAfter, tc.err, tc.reply, ok, retryAfter, err)
+		}
+	}
+
+	c.err = errors.New("connection refused")
+	if _, _, err := b.UseTokens("user1", 2, 10, 3, now); err != c.err {
+		t.Fatalf("Expected the client error but got %v", err)
+	}
+}
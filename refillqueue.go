@@ -0,0 +1,135 @@
+package main
+
+import (
+	"container/heap"
+	"math/rand/v2"
+	"time"
+)
+
+// WithRefillQueue has a running manager refill each rule one update interval after it was last used
+// or refilled, instead of sweeping every rule which may not be full on a shared ticker. Rules wait in a
+// queue ordered by when they are next due, so refill work is proportional to the number of rules in
+// use and a rule's refills are spaced from its own first use rather than from the manager's ticks.
+func WithRefillQueue() Option {
+	return func(c *config) {
+		c.queued = true
+	}
+}
+
+// WithRefillJitter refills each rule on a phase of its own, as WithRefillQueue does, offset by a random
+// fraction of the update interval up to jitter. Rules drained together then regain tokens at different
+// instants rather than all at once, smoothing the aggregate throughput across keys. A rule keeps its
+// phase until it is full, taking a new one the next time it is used. Jitter is clamped to at most 1,
+// and a non-positive jitter queues refills without offsets.
+func WithRefillJitter(jitter float64) Option {
+	return func(c *config) {
+		c.queued = true
+		c.jitter = min(max(jitter, 0), 1)
+	}
+}
+
+// refillQueue is a min-heap of the entries of a shard which may not be full, ordered by when they are
+// next due a refill
+type refillQueue[K comparable] struct {
+	entries  []*entry[K]
+	interval time.Duration
+	jitter   time.Duration // most an entry's refills are offset by, none if zero
+}
+
+func (q *refillQueue[K]) Len() int {
+	return len(q.entries)
+}
+
+func (q *refillQueue[K]) Less(i, j int) bool {
+	return q.entries[i].due.Before(q.entries[j].due)
+}
+
+func (q *refillQueue[K]) Swap(i, j int) {
+	q.entries[i], q.entries[j] = q.entries[j], q.entries[i]
+	q.entries[i].index = i
+	q.entries[j].index = j
+}
+
+func (q *refillQueue[K]) Push(x interface{}) {
+	e := x.(*entry[K])
+	e.index = len(q.entries)
+	q.entries = append(q.entries, e)
+}
+
+func (q *refillQueue[K]) Pop() interface{} {
+	last := len(q.entries) - 1
+	e := q.entries[last]
+	q.entries[last] = nil
+	q.entries = q.entries[:last]
+	e.due = time.Time{}
+	return e
+}
+
+// schedule adds an entry to the queue due one of its refill intervals from now, plus a random offset
+// if jittered, unless it is already queued
+func (q *refillQueue[K]) schedule(e *entry[K], now time.Time) {
+	if e.due.IsZero() {
+		e.due = now.Add(refillInterval(e.rule, q.interval))
+		if q.jitter > 0 {
+			e.due = e.due.Add(rand.N(q.jitter))
+		}
+		heap.Push(q, e)
+	}
+}
+
+// unschedule removes an entry from the queue if it is queued
+func (q *refillQueue[K]) unschedule(e *entry[K]) {
+	if !e.due.IsZero() {
+		heap.Remove(q, e.index)
+	}
+}
+
+// refill adds tokens to every entry due by now, requeuing those which are still not full, and returns
+// when the next entry is due or the zero time if none are queued. Like a ticker, refills missed by
+// more than an entry's interval are dropped rather than caught up on.
+func (q *refillQueue[K]) refill(now time.Time) time.Time {
+	for len(q.entries) > 0 {
+		e := q.entries[0]
+		if e.due.After(now) {
+			return e.due
+		}
+		e.rule.AddToken()
+		if full(e.rule) {
+			heap.Pop(q)
+			continue
+		}
+		interval := refillInterval(e.rule, q.interval)
+		e.due = e.due.Add(interval)
+		if !e.due.After(now) {
+			e.due = now.Add(interval)
+		}
+		heap.Fix(q, 0)
+	}
+	return time.Time{}
+}
+
+// refillQueued refills every queued rule which is due, locking one shard at a time per worker, and
+// returns how long until the next one is due. Rules are first due at least an interval after they are
+// queued, so nothing queued later can be due before the shortest interval has passed and that is the
+// longest it waits.
+func (m *KeyedManager[K]) refillQueued() time.Duration {
+	now := m.clock.Now()
+	dues := make([]time.Time, len(m.shards))
+	m.eachShard(func(i int, s *shard[K]) {
+		s.Lock()
+		dues[i] = s.queue.refill(now)
+		s.Unlock()
+	})
+	next := now.Add(m.queueWait())
+	for _, due := range dues {
+		if !due.IsZero() && due.Before(next) {
+			next = due
+		}
+	}
+	m.Lock()
+	if m.done != nil {
+		m.nextRefill = next
+	}
+	m.Unlock()
+	return next.Sub(now)
+}
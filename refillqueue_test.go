@@ -0,0 +1,158 @@
+package main
+
+import (
+	"errors"
+	"strconv"
+	"testing"
+	"time"
+)
+
+// queued returns the number of rules waiting in the refill queues of a manager
+func queued(m *Manager) int {
+	var n int
+	for _, s := range m.shards {
+		s.Lock()
+		n += s.queue.Len()
+		s.Unlock()
+	}
+	return n
+}
+
+func TestRefillQueue(t *testing.T) {
+	clock := newFakeClock()
+	m := NewManager(WithClock(clock), WithRefillQueue())
+
+	m.AddRule("user1", NewRule(1, 2*time.Second))
+	m.UseTokens("user1", 2)
+	clock.Advance(500 * time.Millisecond)
+	m.AddRule("user2", NewRule(1, 2*time.Second))
+	m.UseTokens("user2", 2)
+
+	clock.Advance(500 * time.Millisecond)
+	if wait := m.refillQueued(); wait != 500*time.Millisecond {
+		t.Fatalf("Expected the next refill to be due in 500ms but got %v", wait)
+	}
+	if remaining, _ := m.Remaining("user1"); remaining != 1 {
+		t.Fatalf("Expected user1 to be refilled a second after use but got %d tokens", remaining)
+	}
+	if remaining, _ := m.Remaining("user2"); remaining != 0 {
+		t.Fatalf("Did not expect user2 to be refilled before it is due but got %d tokens", remaining)
+	}
+
+	clock.Advance(500 * time.Millisecond)
+	m.refillQueued()
+	if remaining, _ := m.Remaining("user2"); remaining != 1 {
+		t.Fatalf("Expected user2 to be refilled a second after use but got %d tokens", remaining)
+	}
+
+	clock.Advance(500 * time.Millisecond)
+	m.refillQueued()
+	if n := queued(m); n != 1 {
+		t.Fatalf("Expected only the rule which is not full to be queued but got %d", n)
+	}
+	m.RemoveRule("user2")
+	if n := queued(m); n != 0 {
+		t.Fatalf("Expected a removed rule to leave the queue but got %d queued", n)
+	}
+}
+
+func TestRefillQueueRetryAfter(t *testing.T) {
+	clock := newFakeClock()
+	m := NewManager(WithClock(clock), WithRefillQueue())
+	m.Run()
+	defer m.Stop()
+
+	clock.Advance(300 * time.Millisecond)
+	m.AddRule("user1", NewRule(1, 2*time.Second))
+	clock.Advance(400 * time.Millisecond)
+	m.UseTokens("user1", 2)
+
+	err := m.UseToken("user1")
+	var qerr *QuotaExceededError
+	if !errors.As(err, &qerr) || qerr.RetryAfter != 600*time.Millisecond {
+		t.Fatalf("Expected %v retrying after the rule's own refill but got %v", ErrQuotaExceeded, err)
+	}
+}
+
+func TestRefillQueueRun(t *testing.T) {
+	clock := newFakeClock()
+	m := NewManager(WithClock(clock), WithRefillQueue())
+	m.Run()
+
+	m.AddRule("user1", NewRule(1, 5*time.Second))
+	m.UseTokens("user1", 5)
+
+	clock.Advance(UpdateRate)
+	deadline := time.Now().Add(time.Second)
+	for remaining, _ := m.Remaining("user1"); remaining == 0; remaining, _ = m.Remaining("user1") {
+		if time.Now().After(deadline) {
+			t.Fatalf("Expected a refill from the queue")
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	m.Stop()
+	if clock.Waiters() != 0 {
+		t.Fatalf("Expected the refill timer to be stopped but got %d waiters", clock.Waiters())
+	}
+}
+
+func TestRefillJitter(t *testing.T) {
+	clock := newFakeClock()
+	m := NewManager(WithClock(clock), WithUpdateRate(time.Second), WithRefillJitter(0.5))
+
+	start := clock.Now()
+	for i := 0; i < 100; i++ {
+		key := strconv.Itoa(i)
+		m.AddRule(key, NewRule(1, 5*time.Second))
+		m.UseToken(key)
+	}
+
+	phases := make(map[time.Time]bool)
+	for _, s := range m.shards {
+		for _, e := range s.queue.entries {
+			if e.due.Before(start.Add(time.Second)) || !e.due.Before(start.Add(1500*time.Millisecond)) {
+				t.Fatalf("Expected a refill due within half an interval after a second but got %v", e.due.Sub(start))
+			}
+			phases[e.due] = true
+		}
+	}
+	if len(phases) < 2 {
+		t.Fatalf("Expected rules to be refilled on different phases but got %d", len(phases))
+	}
+
+	if wait := m.refillQueued(); wait > time.Second {
+		t.Fatalf("Did not expect to wait longer than an interval but got %v", wait)
+	}
+	clock.Advance(1500 * time.Millisecond)
+	m.refillQueued()
+	for i := 0; i < 100; i++ {
+		if remaining, _ := m.Remaining(strconv.Itoa(i)); remaining != 5 {
+			t.Fatalf("Expected every rule to be refilled within the jitter but got %d tokens", remaining)
+		}
+	}
+}
+
+func BenchmarkRefillQueueMillionKeysMostlyIdle(b *testing.B) {
+	clock := newFakeClock()
+	m := NewManager(WithClock(clock), WithRefillQueue())
+
+	numKeys := 1000000
+	for i := 0; i < numKeys; i++ {
+		m.AddRule(strconv.Itoa(i), NewRule(1, 30*time.Second))
+	}
+	clock.Advance(UpdateRate)
+	m.refillQueued()
+
+	// 1% of keys are in use each refill
+	b.ResetTimer()
+	for n := 0; n < b.N; n++ {
+		b.StopTimer()
+		for i := 0; i < numKeys/100; i++ {
+			m.UseToken(strconv.Itoa((n*numKeys/100 + i) % numKeys))
+		}
+		clock.Advance(UpdateRate)
+		b.StartTimer()
+		m.refillQueued()
+	}
+}
@@ -0,0 +1,160 @@
+package main
+
+import (
+	"sync"
+	"time"
+)
+
+// Reservation holds a token for a rule which may only become usable after a delay. A reservation that
+// is not OK holds nothing and should be discarded.
+type Reservation struct {
+	s        sync.Locker // the shard of the rule
+	r        Limiter
+	clock    Clock
+	ok       bool
+	at       time.Time // time at which the held token may be acted upon
+	canceled bool
+
+	reclaim   *reclaimer // the manager's reclaimer of reservations past their CommitBy deadline
+	deadline  time.Time  // guarded by the reclaimer
+	index     int        // position in the reclaimer's queue, -1 if not queued
+	committed bool
+}
+
+// Reserve holds a token for a given key. If a token is available it is used immediately and
+// the reservation has no delay, otherwise the reservation holds a token from a future refill. The
+// delay is computed from the rule's refill rate and the next scheduled refill, so the manager must be
+// running or refill lazily for a future token to be reserved. At most a full window of tokens may be
+// held in advance. The reservation of an allowlisted key or a disabled rule holds no token, so it has
+// no delay and Cancel has no effect.
+func (m *KeyedManager[K]) Reserve(key K) (*Reservation, error) {
+	key = m.normalize(key)
+	if err := m.admitting(); err != nil {
+		return nil, err
+	}
+	now := m.clock.Now()
+	if l, ok := m.listed(key); ok {
+		if l == allowed {
+			return m.unheld(now), nil
+		}
+		return nil, &KeyedQuotaExceededError[K]{Key: key}
+	}
+	if m.lazy {
+		m.reclaim.expire(now)
+	}
+	h := m.hash(key)
+	s := m.shard(h)
+	s.Lock()
+	e := m.entryForUse(s, h, key)
+	if e == nil {
+		s.Unlock()
+		return nil, &KeyedRuleNotFoundError[K]{Key: key}
+	}
+	s.touch(e, now)
+	if e.disabled {
+		s.Unlock()
+		return m.unheld(now), nil
+	}
+	r := e.rule
+	r.Refill(now)
+
+	res := &Reservation{s: s, r: r, clock: m.clock, at: now, reclaim: &m.reclaim, index: -1}
+	if r.UseTokens(1) {
+		res.ok = true
+		s.Unlock()
+		return res, nil
+	}
+
+	// count may already be negative from previous reservations, so this token is only available
+	// once that debt plus itself has been refilled
+	m.Lock()
+	delay, _, ok := r.RetryAfter(1, m.scheduleFor(e, now))
+	m.Unlock()
+	if !ok {
+		s.Unlock()
+		return res, nil
+	}
+	res.at = now.Add(delay)
+	res.ok = true
+	r.Borrow(1)
+	s.Unlock()
+	return res, nil
+}
+
+// unheld returns a reservation which is OK now without holding any token, and so is already committed
+func (m *KeyedManager[K]) unheld(now time.Time) *Reservation {
+	return &Reservation{s: new(sync.Mutex), clock: m.clock, ok: true, at: now, reclaim: &m.reclaim, index: -1,
+		committed: true}
+}
+
+// RetryAfter returns how long until a token is available for a given key, or 0 if one is available
+// now, such as for a Retry-After header or client backoff. It is computed from the rule's refill rate
+// and the next scheduled refill as for Reserve, so a reservation made instead would have the same delay.
+// Only the key's own rule is consulted, and a disabled rule or allowlisted key is always available. If
+// no token will ever become available, such as when the manager is not running or the key is
+// denylisted, a QuotaExceededError with no RetryAfter is returned.
+func (m *KeyedManager[K]) RetryAfter(key K) (time.Duration, error) {
+	key = m.normalize(key)
+	if l, ok := m.listed(key); ok {
+		if l == allowed {
+			return 0, nil
+		}
+		return 0, &KeyedQuotaExceededError[K]{Key: key}
+	}
+	now := m.clock.Now()
+	h := m.hash(key)
+	s := m.shard(h)
+	s.Lock()
+	e := s.entry(h, key)
+	if e == nil {
+		s.Unlock()
+		return 0, &KeyedRuleNotFoundError[K]{Key: key}
+	}
+	if e.disabled {
+		s.Unlock()
+		return 0, nil
+	}
+	r := e.rule
+	r.Refill(now)
+	m.Lock()
+	delay, binding, ok := r.RetryAfter(1, m.scheduleFor(e, now))
+	m.Unlock()
+	s.Unlock()
+	if !ok {
+		return 0, &KeyedQuotaExceededError[K]{Key: key, Rule: binding}
+	}
+	return delay, nil
+}
+
+// OK returns whether the reservation holds a token
+func (res *Reservation) OK() bool {
+	return res.ok
+}
+
+// Delay returns how long the caller must wait before acting on the reservation. A reservation that is
+// not OK returns 0.
+func (res *Reservation) Delay() time.Duration {
+	if !res.ok {
+		return 0
+	}
+	delay := res.at.Sub(res.clock.Now())
+	if delay < 0 {
+		return 0
+	}
+	return delay
+}
+
+// Cancel returns the held token to the rule if the caller decides not to proceed. Calling Cancel more
+// than once is safe, and it has no effect on a committed reservation.
+func (res *Reservation) Cancel() {
+	if !res.ok {
+		return
+	}
+	res.s.Lock()
+	if !res.canceled && !res.committed {
+		res.canceled = true
+		res.r.ReturnTokens(1)
+	}
+	res.s.Unlock()
+	res.reclaim.unschedule(res)
+}
@@ -0,0 +1,136 @@
+package main
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestReserveImmediate(t *testing.T) {
+	m := NewManager()
+
+	user := "user1"
+	m.AddRule(user, NewRule(1, 1*time.Second))
+
+	res, err := m.Reserve(user)
+	if err != nil {
+		t.Fatalf("Did not expect an error on valid user, %v", err)
+	}
+	if !res.OK() || res.Delay() != 0 {
+		t.Fatalf("Expected an immediate reservation but got ok %t and delay %v", res.OK(), res.Delay())
+	}
+
+	res.Cancel()
+	res.Cancel()
+	if remaining, _ := m.Remaining(user); remaining != 1 {
+		t.Fatalf("Expected canceled reservation to return its token but got %d remaining", remaining)
+	}
+
+	if _, err := m.Reserve("user2"); !errors.Is(err, ErrRuleDoesNotExist) {
+		t.Fatalf("Expected %v for a missing rule but got %v", ErrRuleDoesNotExist, err)
+	}
+}
+
+func TestReserveDisabled(t *testing.T) {
+	m := NewManager()
+	m.AddRule("user1", NewRule(1, 2*time.Second))
+	m.UseTokens("user1", 2)
+	m.Disable("user1")
+
+	for range 3 {
+		res, err := m.Reserve("user1")
+		if err != nil {
+			t.Fatalf("Did not expect an error reserving for a disabled rule, %v", err)
+		}
+		if !res.OK() || res.Delay() != 0 {
+			t.Fatalf("Expected an immediate reservation for a disabled rule but got ok %t and delay %v", res.OK(), res.Delay())
+		}
+		res.Cancel()
+	}
+	m.Enable("user1")
+	if remaining, _ := m.Remaining("user1"); remaining != 0 {
+		t.Fatalf("Did not expect reservations on a disabled rule to take or return tokens but got %d", remaining)
+	}
+}
+
+func TestReserveFuture(t *testing.T) {
+	m := NewManager()
+
+	user := "user1"
+	m.AddRule(user, NewRule(1, 1*time.Second))
+	m.UseToken(user)
+
+	res, _ := m.Reserve(user)
+	if res.OK() {
+		t.Fatalf("Should not reserve a future token when the manager is not running")
+	}
+
+	m.Run()
+	defer m.Stop()
+
+	res, _ = m.Reserve(user)
+	if !res.OK() {
+		t.Fatalf("Expected a future token to be reserved")
+	}
+	if delay := res.Delay(); delay <= 0 || delay > UpdateRate {
+		t.Fatalf("Expected a delay within the next refill but got %v", delay)
+	}
+
+	if next, _ := m.Reserve(user); next.OK() {
+		t.Fatalf("Should not reserve more than a full window of tokens in advance")
+	}
+
+	res.Cancel()
+	l, _ := m.GetRule(user)
+	if r := l.(*Rule); r.tokens() != 0 {
+		t.Fatalf("Expected canceled reservation to repay its debt but got count %d", r.tokens())
+	}
+}
+
+func TestReserveLazy(t *testing.T) {
+	m := NewManagerLazy()
+
+	user := "user1"
+	m.AddRule(user, NewRule(1, 1*time.Second))
+	m.UseToken(user)
+
+	res, _ := m.Reserve(user)
+	if !res.OK() {
+		t.Fatalf("Expected a future token to be reserved from a lazy manager")
+	}
+	if delay := res.Delay(); delay <= 0 || delay > time.Second {
+		t.Fatalf("Expected a delay within a second at 1 qps but got %v", delay)
+	}
+}
+
+func TestRetryAfter(t *testing.T) {
+	clock := newFakeClock()
+	m := NewManagerLazy(WithClock(clock))
+
+	user := "user1"
+	m.AddRule(user, NewRule(2, 1*time.Second))
+	if wait, err := m.RetryAfter(user); err != nil || wait != 0 {
+		t.Fatalf("Expected a token to be available now but got %v, %v", wait, err)
+	}
+
+	m.UseTokens(user, 2)
+	wait, err := m.RetryAfter(user)
+	if err != nil {
+		t.Fatalf("Did not expect an error on valid user, %v", err)
+	}
+	res, _ := m.Reserve(user)
+	if wait != 500*time.Millisecond || res.Delay() != wait {
+		t.Fatalf("Expected a reservation to wait as long as retrying after %v but got %v", wait, res.Delay())
+	}
+
+	if _, err := m.RetryAfter("user2"); !errors.Is(err, ErrRuleDoesNotExist) {
+		t.Fatalf("Expected %v for a missing rule but got %v", ErrRuleDoesNotExist, err)
+	}
+
+	stopped := NewManager()
+	stopped.AddRule(user, NewRule(1, 1*time.Second))
+	stopped.UseToken(user)
+	if _, err := stopped.RetryAfter(user); !errors.Is(err, ErrQuotaExceeded) {
+		t.Fatalf("Expected %v when no token will become available but got %v", ErrQuotaExceeded, err)
+	}
+}
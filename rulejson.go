@@ -0,0 +1,121 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+)
+
+// ruleJSON is the serialized form of a *Rule. Fields derived from these, such as the tokens added per
+// refill, are recomputed when it is decoded.
+type ruleJSON struct {
+	QPS       float64  `json:"qps"`
+	Window    duration `json:"window"`
+	Burst     int      `json:"burst,omitempty"`
+	Count     *int     `json:"count,omitempty"` // tokens available, a full burst if unset
+	Cost      int      `json:"cost,omitempty"`
+	Rollover  bool     `json:"rollover,omitempty"`
+	MaxCarry  int      `json:"max_carry,omitempty"`
+	Align     string   `json:"align,omitempty"` // name of the location windows are aligned in
+	SoftLimit float64  `json:"soft_limit,omitempty"`
+	Warmup    duration `json:"warmup,omitempty"`
+	Refill    duration `json:"refill_interval,omitempty"` // own refill interval if set
+	Overdraft int      `json:"overdraft,omitempty"`
+}
+
+// json returns the serialized form of the rule
+func (r *Rule) json() ruleJSON {
+	count := r.tokens()
+	rj := ruleJSON{
+		QPS:       r.rate,
+		Window:    duration(r.window),
+		Burst:     r.burst,
+		Count:     &count,
+		Cost:      r.cost,
+		Rollover:  r.rollover,
+		MaxCarry:  r.maxCarry,
+		SoftLimit: r.softLimit,
+		Warmup:    duration(r.warmup),
+		Refill:    duration(r.every),
+		Overdraft: r.overdraft,
+	}
+	if r.align != nil {
+		rj.Align = r.align.String()
+	}
+	return rj
+}
+
+// restore resets a rule to the serialized one as if it were created by NewRuleRate, returning an error
+// if its windows are aligned in an unknown location. Counts are clamped to between zero and the most
+// the rule can hold.
+func (rj ruleJSON) restore(r *Rule) error {
+	var loc *time.Location
+	if rj.Align != "" {
+		var err error
+		if loc, err = time.LoadLocation(rj.Align); err != nil {
+			return err
+		}
+	}
+	*r = Rule{}
+	r.init(rj.QPS, time.Duration(rj.Window), UpdateRate)
+	opts := []RuleOption{WithCost(rj.Cost), WithWarmup(time.Duration(rj.Warmup)), withBurst(rj.Burst), WithSoftLimit(rj.SoftLimit), WithRefillInterval(time.Duration(rj.Refill)), WithOverdraft(rj.Overdraft)}
+	if rj.Rollover {
+		opts = append(opts, WithRollover(rj.MaxCarry))
+	}
+	if loc != nil {
+		opts = append(opts, withAlignment(loc))
+	}
+	for _, opt := range opts {
+		opt(r)
+	}
+	if rj.Count != nil {
+		r.SetTokens(*rj.Count)
+	}
+	return nil
+}
+
+// duration is a time.Duration encoded in nanoseconds which may also be decoded from a string parsed by
+// time.ParseDuration
+type duration time.Duration
+
+func (d *duration) UnmarshalJSON(data []byte) error {
+	var s string
+	if err := json.Unmarshal(data, &s); err != nil {
+		return json.Unmarshal(data, (*int64)(d))
+	}
+	parsed, err := time.ParseDuration(s)
+	if err != nil {
+		return err
+	}
+	*d = duration(parsed)
+	return nil
+}
+
+// String summarizes the rule's rate, window and tokens available out of the most it can hold
+func (r *Rule) String() string {
+	return fmt.Sprintf("%g qps over %v, %d/%d tokens", r.rate, r.window, r.Remaining(), r.Max())
+}
+
+// MarshalJSON encodes the rule's parameters and tokens available, such as for a config file. The
+// window is encoded in nanoseconds but may also be decoded from a string such as "5s". Aligned
+// windows are encoded by location name, so only locations time.LoadLocation can find round trip.
+func (r *Rule) MarshalJSON() ([]byte, error) {
+	return json.Marshal(r.json())
+}
+
+// UnmarshalJSON decodes a rule encoded by MarshalJSON, recomputing the fields derived from its
+// parameters. A rule without a count starts with a full burst of tokens, and one whose qps and window
+// would be rejected by NewRuleChecked returns an error wrapping ErrInvalidRule.
+func (r *Rule) UnmarshalJSON(data []byte) error {
+	var rj ruleJSON
+	if err := json.Unmarshal(data, &rj); err != nil {
+		return err
+	}
+	if err := checkRule(rj.QPS, time.Duration(rj.Window)); err != nil {
+		return err
+	}
+	if err := rj.restore(r); err != nil {
+		return fmt.Errorf("%w: %v", ErrInvalidRule, err)
+	}
+	return nil
+}
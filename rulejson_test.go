@@ -0,0 +1,57 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestRuleString(t *testing.T) {
+	r := NewRule(2, 5*time.Second)
+	r.UseTokens(3)
+	if s, expected := r.String(), "2 qps over 5s, 7/10 tokens"; s != expected {
+		t.Fatalf("Expected %q but got %q", expected, s)
+	}
+}
+
+func TestRuleJSON(t *testing.T) {
+	r := NewRuleWithBurst(2, 5*time.Second, 4, WithCost(2), WithRollover(3))
+	r.UseTokens(3)
+
+	data, err := json.Marshal(r)
+	if err != nil {
+		t.Fatalf("Did not expect an error marshaling a rule, %v", err)
+	}
+	var decoded Rule
+	if err := json.Unmarshal(data, &decoded); err != nil {
+		t.Fatalf("Did not expect an error unmarshaling a rule, %v", err)
+	}
+	if decoded.rate != 2 || decoded.window != 5*time.Second || decoded.Burst() != 4 ||
+		decoded.Cost() != 2 || !decoded.rollover || decoded.Max() != 7 || decoded.Remaining() != 1 {
+		t.Fatalf("Expected the rule to round trip but got %s from %s", decoded.String(), data)
+	}
+	if decoded.maxQueries != 10 || decoded.addTokens != 2 {
+		t.Fatalf("Expected derived fields to be recomputed but got %d max queries and %v tokens per refill",
+			decoded.maxQueries, decoded.addTokens)
+	}
+}
+
+func TestRuleJSONConfig(t *testing.T) {
+	var r Rule
+	if err := json.Unmarshal([]byte(`{"qps": 0.5, "window": 10000000000}`), &r); err != nil {
+		t.Fatalf("Did not expect an error unmarshaling a rule, %v", err)
+	}
+	if r.Remaining() != 5 || r.Max() != 5 {
+		t.Fatalf("Expected a rule without a count to start full but got %s", r.String())
+	}
+
+	for _, data := range []string{`{"qps": 0, "window": 1000000000}`, `{"qps": 1}`} {
+		if err := json.Unmarshal([]byte(data), &r); !errors.Is(err, ErrInvalidRule) {
+			t.Fatalf("Expected %v for %s but got %v", ErrInvalidRule, data, err)
+		}
+	}
+	if err := json.Unmarshal([]byte(`{"qps": "fast"}`), &r); err == nil {
+		t.Fatalf("Expected an error for malformed JSON")
+	}
+}
@@ -0,0 +1,139 @@
+package main
+
+import (
+	"time"
+)
+
+// TimeRange is a daily span of time from Start up to but excluding End, both measured from midnight.
+// A range whose End is before its Start wraps past midnight, such as 22:00 to 06:00.
+type TimeRange struct {
+	Start time.Duration
+	End   time.Duration
+}
+
+// Contains returns whether the time of day of t falls within the range
+func (tr TimeRange) Contains(t time.Time) bool {
+	hour, min, sec := t.Clock()
+	day := time.Duration(hour)*time.Hour + time.Duration(min)*time.Minute +
+		time.Duration(sec)*time.Second + time.Duration(t.Nanosecond())
+	if tr.End < tr.Start {
+		return day >= tr.Start || day < tr.End
+	}
+	return day >= tr.Start && day < tr.End
+}
+
+// ScheduledRule switches between rules by time of day, such as 100 qps during business hours and 20
+// qps otherwise. The rule in effect is chosen from the manager's clock whenever the rule is refilled
+// before a use, as the first whose range contains the time of day in the rule's location, and the
+// fallback rule is used when no range matches. Every sub-rule keeps refilling while it is not in
+// effect. The sub-rules belong to the ScheduledRule and must not be added to a manager on their own.
+type ScheduledRule struct {
+	loc     *time.Location
+	ranges  []TimeRange
+	rules   []*Rule // the fallback followed by the rule for each range
+	current *Rule
+}
+
+// NewScheduledRule creates a rule which enforces fallback until ranges are added with During. Times of
+// day are read in loc, or in local time if loc is nil.
+func NewScheduledRule(fallback *Rule, loc *time.Location) *ScheduledRule {
+	if loc == nil {
+		loc = time.Local
+	}
+	return &ScheduledRule{loc: loc, rules: []*Rule{fallback}, current: fallback}
+}
+
+// During enforces r at times of day within tr, taking precedence over ranges added after it, and returns
+// the scheduled rule
+func (sr *ScheduledRule) During(tr TimeRange, r *Rule) *ScheduledRule {
+	sr.ranges = append(sr.ranges, tr)
+	sr.rules = append(sr.rules, r)
+	return sr
+}
+
+// Current returns the rule in effect as of the last refill
+func (sr *ScheduledRule) Current() *Rule {
+	return sr.current
+}
+
+// UseTokens uses n tokens from the rule in effect if it has n available
+func (sr *ScheduledRule) UseTokens(n int) bool {
+	return sr.current.UseTokens(n)
+}
+
+// Borrow uses n tokens from the rule in effect whether or not they are available
+func (sr *ScheduledRule) Borrow(n int) {
+	sr.current.Borrow(n)
+}
+
+// ReturnTokens gives back n tokens to the rule in effect
+func (sr *ScheduledRule) ReturnTokens(n int) {
+	sr.current.ReturnTokens(n)
+}
+
+// SetTokens sets the tokens available on the rule in effect
+func (sr *ScheduledRule) SetTokens(n int) {
+	sr.current.SetTokens(n)
+}
+
+// Full returns whether refilling would add nothing to any of the sub-rules
+func (sr *ScheduledRule) Full() bool {
+	for _, r := range sr.rules {
+		if !r.Full() {
+			return false
+		}
+	}
+	return true
+}
+
+// Remaining returns the tokens available on the rule in effect
+func (sr *ScheduledRule) Remaining() int {
+	return sr.current.Remaining()
+}
+
+// Max returns the most tokens the rule in effect can hold
+func (sr *ScheduledRule) Max() int {
+	return sr.current.Max()
+}
+
+// Cost returns the tokens used by UseToken on the rule in effect
+func (sr *ScheduledRule) Cost() int {
+	return sr.current.Cost()
+}
+
+// AddToken refills every sub-rule
+func (sr *ScheduledRule) AddToken() {
+	for _, r := range sr.rules {
+		r.AddToken()
+	}
+}
+
+// Refill brings every sub-rule up to date as of now and selects the rule in effect
+func (sr *ScheduledRule) Refill(now time.Time) {
+	for _, r := range sr.rules {
+		r.Refill(now)
+	}
+	sr.current = sr.rules[0]
+	local := now.In(sr.loc)
+	for i, tr := range sr.ranges {
+		if tr.Contains(local) {
+			sr.current = sr.rules[i+1]
+			break
+		}
+	}
+}
+
+// SetSchedule configures every sub-rule for the manager's refill schedule and selects the rule in
+// effect
+func (sr *ScheduledRule) SetSchedule(sch Schedule) {
+	for _, r := range sr.rules {
+		r.SetSchedule(sch)
+	}
+	sr.Refill(sch.Now)
+}
+
+// RetryAfter returns how long until n tokens are available on the rule in effect, without accounting
+// for a switch to another rule in the meantime
+func (sr *ScheduledRule) RetryAfter(n int, sch Schedule) (time.Duration, Limiter, bool) {
+	return sr.current.RetryAfter(n, sch)
+}
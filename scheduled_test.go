@@ -0,0 +1,69 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestTimeRange(t *testing.T) {
+	day := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	business := TimeRange{Start: 9 * time.Hour, End: 17 * time.Hour}
+	night := TimeRange{Start: 22 * time.Hour, End: 6 * time.Hour}
+
+	testData := []struct {
+		tr       TimeRange
+		at       time.Duration
+		expected bool
+	}{
+		{business, 9 * time.Hour, true},
+		{business, 17*time.Hour - time.Nanosecond, true},
+		{business, 17 * time.Hour, false},
+		{business, 3 * time.Hour, false},
+		{night, 23 * time.Hour, true},
+		{night, 5 * time.Hour, true},
+		{night, 6 * time.Hour, false},
+		{night, 12 * time.Hour, false},
+	}
+	for _, tc := range testData {
+		if tc.tr.Contains(day.Add(tc.at)) != tc.expected {
+			t.Fatalf("Expected %v for %v in %+v", tc.expected, tc.at, tc.tr)
+		}
+	}
+}
+
+func TestScheduledRule(t *testing.T) {
+	// the fake clock starts at midnight UTC, which is 19:00 the day before five hours west
+	clock := newFakeClock()
+	m := NewManagerLazy(WithClock(clock))
+
+	business := NewRule(5, time.Second)
+	night := NewRule(1, 2*time.Second)
+	sr := NewScheduledRule(night, time.FixedZone("UTC-5", -5*60*60)).
+		During(TimeRange{Start: 9 * time.Hour, End: 17 * time.Hour}, business)
+
+	user := "user1"
+	m.AddRule(user, sr)
+	if err := m.UseTokens(user, 2); err != nil {
+		t.Fatalf("Did not expect an error using the fallback rule, %v", err)
+	}
+	if err := m.UseToken(user); err == nil {
+		t.Fatalf("Expected the fallback rule to be exhausted")
+	}
+	if sr.Current() != night {
+		t.Fatalf("Expected the fallback rule to be in effect at 19:00")
+	}
+
+	clock.Advance(14 * time.Hour)
+	if err := m.UseTokens(user, 5); err != nil {
+		t.Fatalf("Did not expect an error using the business hours rule, %v", err)
+	}
+	if sr.Current() != business {
+		t.Fatalf("Expected the business hours rule to be in effect at 09:00")
+	}
+	if remaining, _ := m.Remaining(user); remaining != 0 {
+		t.Fatalf("Expected the business hours rule to be exhausted but got %d tokens", remaining)
+	}
+	if night.Remaining() != 2 {
+		t.Fatalf("Expected the fallback rule to refill while not in effect but got %d", night.Remaining())
+	}
+}
@@ -0,0 +1,127 @@
+package main
+
+import (
+	"time"
+)
+
+// SlidingWindowRule limits a key to a number of queries over the trailing window by recording the time
+// of every query made within it. Unlike Rule, which refills a counter and so allows up to twice its
+// limit in bursts straddling a refill, the limit holds over every window-long span of time. The
+// tradeoff is memory: a timestamp is kept for each query in the window rather than a fixed handful of
+// counters, so it suits rules with modest limits.
+type SlidingWindowRule struct {
+	qps    int
+	window time.Duration
+	limit  int
+	times  []time.Time // times of queries within the window, oldest first
+	last   time.Time   // time of the most recent refill
+}
+
+// NewSlidingWindowRule creates a sliding window rule allowing qps times the window's seconds queries
+// within any window
+func NewSlidingWindowRule(qps int, window time.Duration) *SlidingWindowRule {
+	return &SlidingWindowRule{
+		qps:    qps,
+		window: window,
+		limit:  int(window.Seconds() * float64(qps)),
+	}
+}
+
+// QPS returns the queries per second of the rule
+func (r *SlidingWindowRule) QPS() int {
+	return r.qps
+}
+
+// Window returns the time window of the rule
+func (r *SlidingWindowRule) Window() time.Duration {
+	return r.window
+}
+
+// UseTokens records n queries if they fit within the limit and returns whether they were recorded
+func (r *SlidingWindowRule) UseTokens(n int) bool {
+	if len(r.times)+n > r.limit {
+		return false
+	}
+	r.Borrow(n)
+	return true
+}
+
+// Borrow records n queries at the time of the last refill. Queries recorded beyond the limit delay
+// any further queries until they leave the window.
+func (r *SlidingWindowRule) Borrow(n int) {
+	for i := 0; i < n; i++ {
+		r.times = append(r.times, r.last)
+	}
+}
+
+// ReturnTokens forgets the n most recent queries
+func (r *SlidingWindowRule) ReturnTokens(n int) {
+	if n > len(r.times) {
+		n = len(r.times)
+	}
+	r.times = r.times[:len(r.times)-n]
+}
+
+// Remaining returns how many more queries fit within the window
+func (r *SlidingWindowRule) Remaining() int {
+	if remaining := r.limit - len(r.times); remaining > 0 {
+		return remaining
+	}
+	return 0
+}
+
+// SetTokens sets how many more queries fit within the window by forgetting the oldest queries or
+// recording queries at the time of the last refill
+func (r *SlidingWindowRule) SetTokens(n int) {
+	if n > r.limit {
+		n = r.limit
+	}
+	if n < 0 {
+		n = 0
+	}
+	used := r.limit - n
+	if len(r.times) > used {
+		r.times = r.times[len(r.times)-used:]
+		return
+	}
+	r.Borrow(used - len(r.times))
+}
+
+// Max returns the most queries allowed within the window
+func (r *SlidingWindowRule) Max() int {
+	return r.limit
+}
+
+// AddToken is a no-op since queries leave the window as time passes rather than on refills
+func (r *SlidingWindowRule) AddToken() {}
+
+// Refill evicts queries which have left the window as of now
+func (r *SlidingWindowRule) Refill(now time.Time) {
+	r.last = now
+	i := 0
+	for i < len(r.times) && now.Sub(r.times[i]) >= r.window {
+		i++
+	}
+	r.times = r.times[i:]
+}
+
+// SetSchedule records the time the rule was added to a manager
+func (r *SlidingWindowRule) SetSchedule(sch Schedule) {
+	r.last = sch.Now
+}
+
+// RetryAfter returns how long until enough of the oldest queries leave the window for n more
+func (r *SlidingWindowRule) RetryAfter(n int, sch Schedule) (time.Duration, Limiter, bool) {
+	if n > r.limit {
+		return 0, r, false
+	}
+	expire := len(r.times) + n - r.limit
+	if expire <= 0 {
+		return 0, r, true
+	}
+	delay := r.times[expire-1].Add(r.window).Sub(sch.Now)
+	if delay < 0 {
+		delay = 0
+	}
+	return delay, r, true
+}
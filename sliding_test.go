@@ -0,0 +1,69 @@
+package main
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestSlidingWindowRule(t *testing.T) {
+	clock := newFakeClock()
+	m := NewManager(WithClock(clock))
+
+	user := "user1"
+	m.AddRule(user, NewSlidingWindowRule(3, 1*time.Second))
+
+	if err := m.UseTokens(user, 2); err != nil {
+		t.Fatalf("Did not expect an error on valid user, %v", err)
+	}
+	clock.Advance(500 * time.Millisecond)
+	if err := m.UseToken(user); err != nil {
+		t.Fatalf("Did not expect an error on valid user, %v", err)
+	}
+
+	clock.Advance(400 * time.Millisecond)
+	var qe *QuotaExceededError
+	if err := m.UseToken(user); !errors.As(err, &qe) {
+		t.Fatalf("Expected %v with a full window but got %v", ErrQuotaExceeded, err)
+	}
+	if qe.RetryAfter != 100*time.Millisecond {
+		t.Fatalf("Expected the oldest queries to leave the window in 100ms but got %v", qe.RetryAfter)
+	}
+
+	// the first two queries leave the window while the third remains in it
+	clock.Advance(100 * time.Millisecond)
+	if remaining, _ := m.Remaining(user); remaining != 2 {
+		t.Fatalf("Expected 2 queries to have left the window but got %d remaining", remaining)
+	}
+	if err := m.UseTokens(user, 3); !errors.Is(err, ErrQuotaExceeded) {
+		t.Fatalf("Expected the query still in the window to count against the limit but got %v", err)
+	}
+	if err := m.UseTokens(user, 2); err != nil {
+		t.Fatalf("Did not expect an error once queries left the window, %v", err)
+	}
+}
+
+func TestSlidingWindowRuleReturnTokens(t *testing.T) {
+	r := NewSlidingWindowRule(2, 1*time.Second)
+	r.UseTokens(2)
+	r.ReturnTokens(5)
+	if remaining := r.Remaining(); remaining != 2 {
+		t.Fatalf("Expected returned queries to be forgotten but got %d remaining", remaining)
+	}
+}
+
+func TestSlidingWindowRuleSetTokens(t *testing.T) {
+	r := NewSlidingWindowRule(5, 1*time.Second)
+	for _, n := range []int{2, 4, 0, 5, -1, 9} {
+		r.SetTokens(n)
+		expected := n
+		if expected < 0 {
+			expected = 0
+		} else if expected > 5 {
+			expected = 5
+		}
+		if remaining := r.Remaining(); remaining != expected {
+			t.Fatalf("Expected setting %d tokens to leave %d but got %d", n, expected, remaining)
+		}
+	}
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+)
+
+// snapshotVersion is the current version of the snapshot format. Fields may be added without
+// changing it since unknown fields are ignored, but it must be bumped whenever the meaning of an
+// existing field changes.
+const snapshotVersion = 1
+
+// snapshot is the serialized state of a manager
+type snapshot[K comparable] struct {
+	Version int               `json:"version"`
+	Rules   []snapshotRule[K] `json:"rules"`
+}
+
+// snapshotRule is the serialized state of a single *Rule
+type snapshotRule[K comparable] struct {
+	Key K `json:"key"`
+	ruleJSON
+}
+
+// Snapshot writes the key, parameters and current tokens of every registered *Rule to w as JSON so
+// that they may be restored by Restore, such as across a restart. Other limiters are not included.
+// Each shard is written as it is visited, so the snapshot is not a single point in time. Keys are
+// written as encoding/json writes them, so keys which are not strings must round trip through it.
+func (m *KeyedManager[K]) Snapshot(w io.Writer) error {
+	now := m.clock.Now()
+	snap := snapshot[K]{Version: snapshotVersion}
+	for _, s := range m.shards {
+		s.Lock()
+		for _, e := range s.rules {
+			for ; e != nil; e = e.next {
+				r, ok := e.rule.(*Rule)
+				if !ok {
+					continue
+				}
+				r.Refill(now)
+				snap.Rules = append(snap.Rules, snapshotRule[K]{Key: e.key, ruleJSON: r.json()})
+			}
+		}
+		s.Unlock()
+	}
+	return json.NewEncoder(w).Encode(snap)
+}
+
+// Restore reads a snapshot written by Snapshot from r and adds its rules, replacing any existing rule
+// for the same key. Restored counts are clamped to between zero and the most the rule can hold, and
+// tokens held by reservations at the time of the snapshot are not restored. A snapshot which cannot
+// be read, was written by a newer version or aligns a rule in an unknown location returns an error
+// wrapping ErrInvalidSnapshot and no rules are restored.
+func (m *KeyedManager[K]) Restore(r io.Reader) error {
+	var snap snapshot[K]
+	if err := json.NewDecoder(r).Decode(&snap); err != nil {
+		return fmt.Errorf("%w: %v", ErrInvalidSnapshot, err)
+	}
+	if snap.Version < 1 || snap.Version > snapshotVersion {
+		return fmt.Errorf("%w: unsupported version %d", ErrInvalidSnapshot, snap.Version)
+	}
+
+	rules := make([]*Rule, len(snap.Rules))
+	for i, sr := range snap.Rules {
+		rules[i] = &Rule{}
+		if err := sr.restore(rules[i]); err != nil {
+			return fmt.Errorf("%w: key %s: %v", ErrInvalidSnapshot, formatKey(sr.Key), err)
+		}
+	}
+	for i, sr := range snap.Rules {
+		m.AddRule(sr.Key, rules[i])
+	}
+	return nil
+}
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSnapshotRestore(t *testing.T) {
+	m := NewManager()
+	m.AddRule("user1", NewRule(1, 5*time.Second))
+	m.AddRule("user2", NewRuleWithBurst(1, 5*time.Second, 8, WithRollover(3)))
+	m.AddRule("user3", NewSlidingWindowRule(1, 5*time.Second))
+	m.UseTokens("user1", 2)
+	m.UseTokens("user2", 6)
+
+	var buf bytes.Buffer
+	if err := m.Snapshot(&buf); err != nil {
+		t.Fatalf("Did not expect an error taking a snapshot, %v", err)
+	}
+
+	restored := NewManager()
+	if err := restored.Restore(&buf); err != nil {
+		t.Fatalf("Did not expect an error restoring a snapshot, %v", err)
+	}
+	if restored.Len() != 2 {
+		t.Fatalf("Expected only the 2 *Rule rules to be restored but got %d", restored.Len())
+	}
+	for key, expected := range map[string]int{"user1": 3, "user2": 2} {
+		if remaining, _ := restored.Remaining(key); remaining != expected {
+			t.Fatalf("Expected %d tokens restored for %s but got %d", expected, key, remaining)
+		}
+	}
+	r, _ := restored.GetRule("user2")
+	if rule := r.(*Rule); rule.Burst() != 8 || !rule.rollover || rule.maxCarry != 3 {
+		t.Fatalf("Expected the burst and rollover of user2 to be restored but got %+v", rule)
+	}
+}
+
+func TestRestoreClamp(t *testing.T) {
+	m := NewManager()
+	snap := `{"version":1,"rules":[{"key":"user1","qps":1,"window":5000000000,"burst":5,"count":50},` +
+		`{"key":"user2","qps":1,"window":5000000000,"burst":5,"count":-3,"future":true}]}`
+	if err := m.Restore(strings.NewReader(snap)); err != nil {
+		t.Fatalf("Did not expect an error restoring a snapshot, %v", err)
+	}
+	for key, expected := range map[string]int{"user1": 5, "user2": 0} {
+		r, _ := m.GetRule(key)
+		if count := r.(*Rule).tokens(); count != expected {
+			t.Fatalf("Expected %s to be clamped to %d tokens but got %d", key, expected, count)
+		}
+	}
+}
+
+func TestRestoreInvalid(t *testing.T) {
+	for _, snap := range []string{
+		`not json`,
+		`{"rules":[]}`,
+		`{"version":2,"rules":[]}`,
+	} {
+		m := NewManager()
+		if err := m.Restore(strings.NewReader(snap)); !errors.Is(err, ErrInvalidSnapshot) {
+			t.Fatalf("Expected %v for snapshot %s but got %v", ErrInvalidSnapshot, snap, err)
+		}
+	}
+}
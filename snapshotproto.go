@@ -0,0 +1,289 @@
+package main
+
+import (
+	"encoding"
+	"fmt"
+	"io"
+	"math"
+
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+// Field numbers of the messages in snapshot.proto
+const (
+	protoSnapshotVersion = 1
+	protoSnapshotRules   = 2
+
+	protoRuleKey            = 1
+	protoRuleQPS            = 2
+	protoRuleWindow         = 3
+	protoRuleBurst          = 4
+	protoRuleCount          = 5
+	protoRuleCost           = 6
+	protoRuleRollover       = 7
+	protoRuleMaxCarry       = 8
+	protoRuleAlign          = 9
+	protoRuleSoftLimit      = 10
+	protoRuleWarmup         = 11
+	protoRuleRefillInterval = 12
+	protoRuleOverdraft      = 13
+	protoRuleAllowed        = 14
+	protoRuleDenied         = 15
+)
+
+// protoRule is the decoded form of a Rule message
+type protoRule struct {
+	key             string
+	rule            ruleJSON
+	allowed, denied uint64
+}
+
+// SnapshotProto writes the same rules as Snapshot to w, along with their allowed and denied counts, as
+// a Snapshot message of snapshot.proto, such as for frequent snapshots of many rules where JSON would
+// be too large or slow. Each shard is encoded under its lock and written once unlocked, so the
+// snapshot is streamed rather than held in memory. Keys which are not strings are written by their
+// MarshalText method, and an error is returned for keys without one.
+func (m *KeyedManager[K]) SnapshotProto(w io.Writer) error {
+	now := m.clock.Now()
+	b := protowire.AppendTag(nil, protoSnapshotVersion, protowire.VarintType)
+	b = protowire.AppendVarint(b, snapshotVersion)
+	var rule []byte
+	for _, s := range m.shards {
+		var err error
+		s.Lock()
+		for _, e := range s.rules {
+			for ; e != nil && err == nil; e = e.next {
+				r, ok := e.rule.(*Rule)
+				if !ok {
+					continue
+				}
+				r.Refill(now)
+				if rule, err = appendProtoRule(rule[:0], e, r); err == nil {
+					b = protowire.AppendTag(b, protoSnapshotRules, protowire.BytesType)
+					b = protowire.AppendBytes(b, rule)
+				}
+			}
+		}
+		s.Unlock()
+		if err != nil {
+			return err
+		}
+		if _, err := w.Write(b); err != nil {
+			return err
+		}
+		b = b[:0]
+	}
+	return nil
+}
+
+// appendProtoRule appends the Rule message for an entry's rule to b, leaving out fields at their
+// default
+func appendProtoRule[K comparable](b []byte, e *entry[K], r *Rule) ([]byte, error) {
+	key, err := keyText(e.key)
+	if err != nil {
+		return nil, err
+	}
+	rj := r.json()
+	b = appendProtoString(b, protoRuleKey, key)
+	if rj.QPS != 0 {
+		b = protowire.AppendTag(b, protoRuleQPS, protowire.Fixed64Type)
+		b = protowire.AppendFixed64(b, math.Float64bits(rj.QPS))
+	}
+	b = appendProtoInt(b, protoRuleWindow, int64(rj.Window))
+	b = appendProtoInt(b, protoRuleBurst, int64(rj.Burst))
+	b = protowire.AppendTag(b, protoRuleCount, protowire.VarintType)
+	b = protowire.AppendVarint(b, uint64(*rj.Count))
+	b = appendProtoInt(b, protoRuleCost, int64(rj.Cost))
+	if rj.Rollover {
+		b = appendProtoInt(b, protoRuleRollover, 1)
+	}
+	b = appendProtoInt(b, protoRuleMaxCarry, int64(rj.MaxCarry))
+	b = appendProtoString(b, protoRuleAlign, rj.Align)
+	if rj.SoftLimit != 0 {
+		b = protowire.AppendTag(b, protoRuleSoftLimit, protowire.Fixed64Type)
+		b = protowire.AppendFixed64(b, math.Float64bits(rj.SoftLimit))
+	}
+	b = appendProtoInt(b, protoRuleWarmup, int64(rj.Warmup))
+	b = appendProtoInt(b, protoRuleRefillInterval, int64(rj.Refill))
+	b = appendProtoInt(b, protoRuleOverdraft, int64(rj.Overdraft))
+	b = appendProtoInt(b, protoRuleAllowed, int64(e.allowed.Load()))
+	b = appendProtoInt(b, protoRuleDenied, int64(e.denied.Load()))
+	return b, nil
+}
+
+// appendProtoInt appends a varint field to b unless it is zero
+func appendProtoInt(b []byte, num protowire.Number, v int64) []byte {
+	if v == 0 {
+		return b
+	}
+	b = protowire.AppendTag(b, num, protowire.VarintType)
+	return protowire.AppendVarint(b, uint64(v))
+}
+
+// appendProtoString appends a string field to b unless it is empty
+func appendProtoString(b []byte, num protowire.Number, s string) []byte {
+	if s == "" {
+		return b
+	}
+	b = protowire.AppendTag(b, num, protowire.BytesType)
+	return protowire.AppendString(b, s)
+}
+
+// keyText returns the text a key is written as in a protobuf snapshot
+func keyText[K comparable](key K) (string, error) {
+	switch k := any(key).(type) {
+	case string:
+		return k, nil
+	case encoding.TextMarshaler:
+		text, err := k.MarshalText()
+		return string(text), err
+	}
+	return "", fmt.Errorf("%T keys do not implement encoding.TextMarshaler", key)
+}
+
+// RestoreProto reads a snapshot written by SnapshotProto from r and adds its rules with their allowed
+// and denied counts, replacing any existing rule for the same key. Rules are restored as by Restore,
+// with the same errors for invalid snapshots. Fields unknown to this version are skipped, so snapshots
+// written by later versions with added fields can still be read.
+func (m *KeyedManager[K]) RestoreProto(r io.Reader) error {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrInvalidSnapshot, err)
+	}
+	version, prs, err := parseProtoSnapshot(data)
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrInvalidSnapshot, err)
+	}
+	if version < 1 || version > snapshotVersion {
+		return fmt.Errorf("%w: unsupported version %d", ErrInvalidSnapshot, version)
+	}
+
+	keys := make([]K, len(prs))
+	rules := make([]*Rule, len(prs))
+	for i, pr := range prs {
+		if keys[i], err = parseKey[K](pr.key); err != nil {
+			return fmt.Errorf("%w: key %q: %v", ErrInvalidSnapshot, pr.key, err)
+		}
+		keys[i] = m.normalize(keys[i])
+		rules[i] = &Rule{}
+		if err := pr.rule.restore(rules[i]); err != nil {
+			return fmt.Errorf("%w: key %q: %v", ErrInvalidSnapshot, pr.key, err)
+		}
+	}
+	for i, pr := range prs {
+		h := m.hash(keys[i])
+		s := m.shard(h)
+		s.Lock()
+		e := m.add(s, h, keys[i], rules[i])
+		e.hasParent = false
+		e.allowed.Store(pr.allowed)
+		e.denied.Store(pr.denied)
+		s.Unlock()
+	}
+	return nil
+}
+
+// parseProtoSnapshot decodes a Snapshot message into its version and rules
+func parseProtoSnapshot(b []byte) (uint64, []protoRule, error) {
+	var version uint64
+	var rules []protoRule
+	for len(b) > 0 {
+		num, typ, n := protowire.ConsumeTag(b)
+		if n < 0 {
+			return 0, nil, protowire.ParseError(n)
+		}
+		b = b[n:]
+		switch {
+		case num == protoSnapshotVersion && typ == protowire.VarintType:
+			version, n = protowire.ConsumeVarint(b)
+		case num == protoSnapshotRules && typ == protowire.BytesType:
+			var msg []byte
+			if msg, n = protowire.ConsumeBytes(b); n >= 0 {
+				pr, err := parseProtoRule(msg)
+				if err != nil {
+					return 0, nil, err
+				}
+				rules = append(rules, pr)
+			}
+		default:
+			n = protowire.ConsumeFieldValue(num, typ, b)
+		}
+		if n < 0 {
+			return 0, nil, protowire.ParseError(n)
+		}
+		b = b[n:]
+	}
+	return version, rules, nil
+}
+
+// parseProtoRule decodes a Rule message
+func parseProtoRule(b []byte) (protoRule, error) {
+	var pr protoRule
+	for len(b) > 0 {
+		num, typ, n := protowire.ConsumeTag(b)
+		if n < 0 {
+			return pr, protowire.ParseError(n)
+		}
+		b = b[n:]
+		switch typ {
+		case protowire.VarintType:
+			var v uint64
+			v, n = protowire.ConsumeVarint(b)
+			pr.setVarint(num, v)
+		case protowire.Fixed64Type:
+			var v uint64
+			v, n = protowire.ConsumeFixed64(b)
+			switch num {
+			case protoRuleQPS:
+				pr.rule.QPS = math.Float64frombits(v)
+			case protoRuleSoftLimit:
+				pr.rule.SoftLimit = math.Float64frombits(v)
+			}
+		case protowire.BytesType:
+			var v string
+			v, n = protowire.ConsumeString(b)
+			switch num {
+			case protoRuleKey:
+				pr.key = v
+			case protoRuleAlign:
+				pr.rule.Align = v
+			}
+		default:
+			n = protowire.ConsumeFieldValue(num, typ, b)
+		}
+		if n < 0 {
+			return pr, protowire.ParseError(n)
+		}
+		b = b[n:]
+	}
+	return pr, nil
+}
+
+// setVarint sets the varint field num of a rule, ignoring unknown fields
+func (pr *protoRule) setVarint(num protowire.Number, v uint64) {
+	switch num {
+	case protoRuleWindow:
+		pr.rule.Window = duration(v)
+	case protoRuleBurst:
+		pr.rule.Burst = int(int64(v))
+	case protoRuleCount:
+		count := int(int64(v))
+		pr.rule.Count = &count
+	case protoRuleCost:
+		pr.rule.Cost = int(int64(v))
+	case protoRuleRollover:
+		pr.rule.Rollover = v != 0
+	case protoRuleMaxCarry:
+		pr.rule.MaxCarry = int(int64(v))
+	case protoRuleWarmup:
+		pr.rule.Warmup = duration(v)
+	case protoRuleRefillInterval:
+		pr.rule.Refill = duration(v)
+	case protoRuleOverdraft:
+		pr.rule.Overdraft = int(int64(v))
+	case protoRuleAllowed:
+		pr.allowed = v
+	case protoRuleDenied:
+		pr.denied = v
+	}
+}
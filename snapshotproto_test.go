@@ -0,0 +1,293 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"math"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"google.golang.org/protobuf/encoding/protowire"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protodesc"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/types/descriptorpb"
+	"google.golang.org/protobuf/types/dynamicpb"
+)
+
+func TestSnapshotProto(t *testing.T) {
+	m := NewManager()
+	m.AddRule("user1", NewRule(1, 5*time.Second, WithCost(2), WithOverdraft(4)))
+	m.AddRule("user2", NewRuleWithBurst(1, 5*time.Second, 8, WithRollover(3)))
+	m.AddRule("user3", NewSlidingWindowRule(1, 5*time.Second))
+	m.UseToken("user1")
+	m.UseTokens("user2", 6)
+	m.UseTokens("user2", 6)
+
+	var buf bytes.Buffer
+	if err := m.SnapshotProto(&buf); err != nil {
+		t.Fatalf("Did not expect an error taking a snapshot, %v", err)
+	}
+	restored := NewManager()
+	if err := restored.RestoreProto(&buf); err != nil {
+		t.Fatalf("Did not expect an error restoring a snapshot, %v", err)
+	}
+	if restored.Len() != 2 {
+		t.Fatalf("Expected only the 2 *Rule rules to be restored but got %d", restored.Len())
+	}
+	for key, expected := range map[string]int{"user1": 3, "user2": 2} {
+		if remaining, _ := restored.Remaining(key); remaining != expected {
+			t.Fatalf("Expected %d tokens restored for %s but got %d", expected, key, remaining)
+		}
+	}
+	r, _ := restored.GetRule("user1")
+	if rule := r.(*Rule); rule.cost != 2 || rule.overdraft != 4 {
+		t.Fatalf("Expected the cost and overdraft of user1 to be restored but got %+v", rule)
+	}
+	r, _ = restored.GetRule("user2")
+	if rule := r.(*Rule); rule.Burst() != 8 || !rule.rollover || rule.maxCarry != 3 {
+		t.Fatalf("Expected the burst and rollover of user2 to be restored but got %+v", rule)
+	}
+	if stats, _ := restored.Stats("user2"); stats.Allowed != 1 || stats.Denied != 1 {
+		t.Fatalf("Expected the stats of user2 to be restored but got %+v", stats)
+	}
+}
+
+func TestSnapshotProtoSize(t *testing.T) {
+	m := NewManager()
+	for i := 0; i < 1000; i++ {
+		m.AddRule("user"+strconv.Itoa(i), NewRule(10, time.Minute))
+	}
+	var js, pb bytes.Buffer
+	m.Snapshot(&js)
+	m.SnapshotProto(&pb)
+	if pb.Len() >= js.Len()/2 {
+		t.Fatalf("Expected the protobuf snapshot to be under half the %d bytes of JSON but got %d", js.Len(), pb.Len())
+	}
+}
+
+func TestRestoreProtoUnknownFields(t *testing.T) {
+	rule := protowire.AppendTag(nil, protoRuleKey, protowire.BytesType)
+	rule = protowire.AppendString(rule, "user1")
+	rule = protowire.AppendTag(rule, protoRuleWindow, protowire.VarintType)
+	rule = protowire.AppendVarint(rule, uint64(time.Second))
+	rule = protowire.AppendTag(rule, protoRuleQPS, protowire.Fixed64Type)
+	rule = protowire.AppendFixed64(rule, math.Float64bits(5))
+	rule = protowire.AppendTag(rule, 99, protowire.BytesType)
+	rule = protowire.AppendString(rule, "added later")
+
+	snap := protowire.AppendTag(nil, protoSnapshotVersion, protowire.VarintType)
+	snap = protowire.AppendVarint(snap, snapshotVersion)
+	snap = protowire.AppendTag(snap, protoSnapshotRules, protowire.BytesType)
+	snap = protowire.AppendBytes(snap, rule)
+	snap = protowire.AppendTag(snap, 99, protowire.VarintType)
+	snap = protowire.AppendVarint(snap, 1)
+
+	m := NewManager()
+	if err := m.RestoreProto(bytes.NewReader(snap)); err != nil {
+		t.Fatalf("Did not expect an error restoring a snapshot with unknown fields, %v", err)
+	}
+	if remaining, _ := m.Remaining("user1"); remaining != 5 {
+		t.Fatalf("Expected user1 to be restored with a full burst of 5 tokens but got %d", remaining)
+	}
+}
+
+func TestRestoreProtoInvalid(t *testing.T) {
+	future := protowire.AppendTag(nil, protoSnapshotVersion, protowire.VarintType)
+	future = protowire.AppendVarint(future, snapshotVersion+1)
+	for _, snap := range [][]byte{
+		{0xff},
+		nil,
+		future,
+	} {
+		m := NewManager()
+		if err := m.RestoreProto(bytes.NewReader(snap)); !errors.Is(err, ErrInvalidSnapshot) {
+			t.Fatalf("Expected %v for snapshot %x but got %v", ErrInvalidSnapshot, snap, err)
+		}
+	}
+}
+
+// protoScalars maps the scalar types used by snapshot.proto to their descriptor types
+var protoScalars = map[string]descriptorpb.FieldDescriptorProto_Type{
+	"bool":   descriptorpb.FieldDescriptorProto_TYPE_BOOL,
+	"double": descriptorpb.FieldDescriptorProto_TYPE_DOUBLE,
+	"int64":  descriptorpb.FieldDescriptorProto_TYPE_INT64,
+	"string": descriptorpb.FieldDescriptorProto_TYPE_STRING,
+	"uint32": descriptorpb.FieldDescriptorProto_TYPE_UINT32,
+	"uint64": descriptorpb.FieldDescriptorProto_TYPE_UINT64,
+}
+
+// loadSnapshotSchema builds the descriptor of snapshot.proto from its messages and fields, which is
+// all the schema declares, so that snapshots can be checked against it without protoc
+func loadSnapshotSchema(t *testing.T) protoreflect.FileDescriptor {
+	t.Helper()
+	src, err := os.ReadFile("snapshot.proto")
+	if err != nil {
+		t.Fatalf("Did not expect an error reading the schema, %v", err)
+	}
+	message := regexp.MustCompile(`^message (\w+) \{$`)
+	field := regexp.MustCompile(`^(repeated |optional )?(\w+) (\w+) = (\d+);`)
+	fd := &descriptorpb.FileDescriptorProto{
+		Name:    proto.String("snapshot.proto"),
+		Package: proto.String("quota"),
+		Syntax:  proto.String("proto3"),
+	}
+	var msg *descriptorpb.DescriptorProto
+	for _, line := range strings.Split(string(src), "\n") {
+		line = strings.TrimSpace(line)
+		if m := message.FindStringSubmatch(line); m != nil {
+			msg = &descriptorpb.DescriptorProto{Name: proto.String(m[1])}
+			fd.MessageType = append(fd.MessageType, msg)
+			continue
+		}
+		m := field.FindStringSubmatch(line)
+		if m == nil {
+			continue
+		}
+		if msg == nil {
+			t.Fatalf("Expected field %s of the schema to be within a message", m[3])
+		}
+		num, _ := strconv.Atoi(m[4])
+		f := &descriptorpb.FieldDescriptorProto{
+			Name:     proto.String(m[3]),
+			JsonName: proto.String(m[3]),
+			Number:   proto.Int32(int32(num)),
+			Label:    descriptorpb.FieldDescriptorProto_LABEL_OPTIONAL.Enum(),
+		}
+		if typ, ok := protoScalars[m[2]]; ok {
+			f.Type = typ.Enum()
+		} else {
+			f.Type = descriptorpb.FieldDescriptorProto_TYPE_MESSAGE.Enum()
+			f.TypeName = proto.String(".quota." + m[2])
+		}
+		switch m[1] {
+		case "repeated ":
+			f.Label = descriptorpb.FieldDescriptorProto_LABEL_REPEATED.Enum()
+		case "optional ":
+			f.Proto3Optional = proto.Bool(true)
+			f.OneofIndex = proto.Int32(int32(len(msg.OneofDecl)))
+			msg.OneofDecl = append(msg.OneofDecl, &descriptorpb.OneofDescriptorProto{Name: proto.String("_" + m[3])})
+		}
+		msg.Field = append(msg.Field, f)
+	}
+	file, err := protodesc.NewFile(fd, nil)
+	if err != nil {
+		t.Fatalf("Did not expect an error building the schema, %v", err)
+	}
+	return file
+}
+
+func TestSnapshotProtoSchema(t *testing.T) {
+	schema := loadSnapshotSchema(t)
+	snapshotDesc := schema.Messages().ByName("Snapshot")
+	ruleDesc := schema.Messages().ByName("Rule")
+	if snapshotDesc == nil || ruleDesc == nil {
+		t.Fatalf("Expected the schema to declare Snapshot and Rule messages")
+	}
+
+	m := NewManager()
+	m.AddRule("user1", NewRule(2, 5*time.Second, WithCost(2), WithOverdraft(4), WithRollover(3),
+		WithSoftLimit(0.5), WithWarmup(time.Minute), WithRefillInterval(2*time.Second)))
+	m.AddRule("user2", NewAlignedRule(10, time.Hour, time.UTC))
+	m.UseToken("user1")
+	m.UseTokens("user2", 11)
+
+	var buf bytes.Buffer
+	if err := m.SnapshotProto(&buf); err != nil {
+		t.Fatalf("Did not expect an error taking a snapshot, %v", err)
+	}
+	snap := dynamicpb.NewMessage(snapshotDesc)
+	if err := proto.Unmarshal(buf.Bytes(), snap); err != nil {
+		t.Fatalf("Did not expect an error parsing a snapshot with the schema, %v", err)
+	}
+	if len(snap.GetUnknown()) != 0 {
+		t.Fatalf("Expected every field of the snapshot to be in the schema but got unknown %x", snap.GetUnknown())
+	}
+	field := func(msg protoreflect.Message, name string) protoreflect.Value {
+		f := msg.Descriptor().Fields().ByName(protoreflect.Name(name))
+		if f == nil {
+			t.Fatalf("Expected the schema to declare %s.%s", msg.Descriptor().Name(), name)
+		}
+		return msg.Get(f)
+	}
+	if v := field(snap, "version").Uint(); v != snapshotVersion {
+		t.Fatalf("Expected version %d but got %d", snapshotVersion, v)
+	}
+	rules := map[string]protoreflect.Message{}
+	list := field(snap, "rules").List()
+	for i := 0; i < list.Len(); i++ {
+		rule := list.Get(i).Message()
+		if len(rule.GetUnknown()) != 0 {
+			t.Fatalf("Expected every field of a rule to be in the schema but got unknown %x", rule.GetUnknown())
+		}
+		rules[field(rule, "key").String()] = rule
+	}
+	if len(rules) != 2 {
+		t.Fatalf("Expected 2 rules in the snapshot but got %d", len(rules))
+	}
+
+	user1 := rules["user1"]
+	for name, expected := range map[string]int64{
+		"window": int64(5 * time.Second), "burst": 10, "cost": 2, "max_carry": 3,
+		"warmup": int64(time.Minute), "refill_interval": int64(2 * time.Second), "overdraft": 4,
+	} {
+		if v := field(user1, name).Int(); v != expected {
+			t.Fatalf("Expected %s of user1 to be %d but got %d", name, expected, v)
+		}
+	}
+	if qps, soft := field(user1, "qps").Float(), field(user1, "soft_limit").Float(); qps != 2 || soft != 0.5 {
+		t.Fatalf("Expected the qps and soft limit of user1 but got %v and %v", qps, soft)
+	}
+	if !field(user1, "rollover").Bool() || field(user1, "allowed").Uint() != 1 {
+		t.Fatalf("Expected the rollover and stats of user1 but got %v", user1)
+	}
+	user2 := rules["user2"]
+	if align, denied := field(user2, "align").String(), field(user2, "denied").Uint(); align != "UTC" || denied != 1 {
+		t.Fatalf("Expected the alignment and stats of user2 but got %v", user2)
+	}
+	if count := user2.Descriptor().Fields().ByName("count"); !user2.Has(count) || user2.Get(count).Int() != 10 {
+		t.Fatalf("Expected the count of user2 to be set to a full 10 tokens but got %v", user2)
+	}
+}
+
+func TestRestoreProtoSchema(t *testing.T) {
+	schema := loadSnapshotSchema(t)
+	snap := dynamicpb.NewMessage(schema.Messages().ByName("Snapshot"))
+	rule := dynamicpb.NewMessage(schema.Messages().ByName("Rule"))
+	set := func(msg protoreflect.Message, name string, v protoreflect.Value) {
+		msg.Set(msg.Descriptor().Fields().ByName(protoreflect.Name(name)), v)
+	}
+	set(rule, "key", protoreflect.ValueOfString("user1"))
+	set(rule, "qps", protoreflect.ValueOfFloat64(2))
+	set(rule, "window", protoreflect.ValueOfInt64(int64(5*time.Second)))
+	set(rule, "count", protoreflect.ValueOfInt64(3))
+	set(rule, "cost", protoreflect.ValueOfInt64(2))
+	set(rule, "overdraft", protoreflect.ValueOfInt64(4))
+	set(rule, "allowed", protoreflect.ValueOfUint64(7))
+	set(snap, "version", protoreflect.ValueOfUint32(snapshotVersion))
+	rules := snap.Mutable(snap.Descriptor().Fields().ByName("rules")).List()
+	rules.Append(protoreflect.ValueOfMessage(rule))
+
+	b, err := proto.Marshal(snap)
+	if err != nil {
+		t.Fatalf("Did not expect an error encoding a snapshot with the schema, %v", err)
+	}
+	m := NewManager()
+	if err := m.RestoreProto(bytes.NewReader(b)); err != nil {
+		t.Fatalf("Did not expect an error restoring a snapshot encoded with the schema, %v", err)
+	}
+	if remaining, _ := m.Remaining("user1"); remaining != 3 {
+		t.Fatalf("Expected 3 tokens restored for user1 but got %d", remaining)
+	}
+	r, _ := m.GetRule("user1")
+	if rule := r.(*Rule); rule.Max() != 10 || rule.cost != 2 || rule.overdraft != 4 {
+		t.Fatalf("Expected the rate, cost and overdraft of user1 to be restored but got %+v", rule)
+	}
+	if stats, _ := m.Stats("user1"); stats.Allowed != 7 {
+		t.Fatalf("Expected the stats of user1 to be restored but got %+v", stats)
+	}
+}
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"math"
+)
+
+// WithSoftLimit sets the fraction of a rule's tokens which may be used before the manager's
+// OnSoftLimit callback is invoked, such as 0.8 to warn once 80% of the quota is used. Uses past the
+// soft limit are still allowed up to the rule's full quota. Fractions outside (0, 1] are ignored.
+func WithSoftLimit(fraction float64) RuleOption {
+	return func(r *Rule) {
+		if fraction > 0 && fraction <= 1 {
+			r.softLimit = fraction
+		}
+	}
+}
+
+// SetOnSoftLimit registers a callback invoked with the original key and its remaining tokens
+// whenever a token use takes a rule set up with WithSoftLimit past its soft limit. The callback fires
+// once per crossing, so it fires again only after the rule has refilled back under its soft limit
+// before a later use crosses it. Registering a new callback replaces the previous one and a nil
+// callback disables it. Like the OnExceeded callback it is invoked outside the manager's lock and must
+// be safe for concurrent use.
+func (m *KeyedManager[K]) SetOnSoftLimit(fn func(key K, remaining int)) {
+	m.Lock()
+	m.onSoftLimit = fn
+	m.Unlock()
+}
+
+// overSoftLimit returns whether a rule with a soft limit has used at least that fraction of its tokens
+// when it has remaining tokens available. The tokens which may be used are rounded up, allowing for
+// fractions like 0.7 which are not exact in floating point.
+func (r *Rule) overSoftLimit(remaining int) bool {
+	max := r.Max()
+	return max-remaining >= int(math.Ceil(r.softLimit*float64(max)-1e-9))
+}
+
+// crossedSoftLimit records a use which took a limiter from before to after remaining tokens and
+// returns whether it crossed the limiter's soft limit for the first time since it was last under it.
+// The limiter's shard must be locked.
+func crossedSoftLimit(l Limiter, before, after int) bool {
+	r, ok := l.(*Rule)
+	if !ok || r.softLimit == 0 {
+		return false
+	}
+	if !r.overSoftLimit(before) {
+		r.softCrossed = false
+	}
+	if r.softCrossed || !r.overSoftLimit(after) {
+		return false
+	}
+	r.softCrossed = true
+	return true
+}
+
+// softLimit reports a token use for a key which crossed its soft limit. No locks may be held.
+func (m *KeyedManager[K]) softLimit(key K, remaining int) {
+	m.Lock()
+	onSoftLimit := m.onSoftLimit
+	m.Unlock()
+	if onSoftLimit != nil {
+		onSoftLimit(key, remaining)
+	}
+}
@@ -0,0 +1,43 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestSoftLimit(t *testing.T) {
+	clock := newFakeClock()
+	m := NewManagerLazy(WithClock(clock))
+
+	var warnings []int
+	m.SetOnSoftLimit(func(key string, remaining int) {
+		warnings = append(warnings, remaining)
+	})
+
+	user := "user1"
+	m.AddRule(user, NewRule(1, 10*time.Second, WithSoftLimit(0.8)))
+	m.UseTokens(user, 7)
+	if len(warnings) != 0 {
+		t.Fatalf("Did not expect a warning under the soft limit but got %v", warnings)
+	}
+	for i := 0; i < 3; i++ {
+		if err := m.UseToken(user); err != nil {
+			t.Fatalf("Did not expect an error past the soft limit, %v", err)
+		}
+	}
+	if len(warnings) != 1 || warnings[0] != 2 {
+		t.Fatalf("Expected a single warning with 2 tokens remaining but got %v", warnings)
+	}
+
+	clock.Advance(6 * time.Second)
+	m.UseTokens(user, 4)
+	if len(warnings) != 2 || warnings[1] != 2 {
+		t.Fatalf("Expected another warning after refilling under the soft limit but got %v", warnings)
+	}
+
+	clock.Advance(10 * time.Second)
+	m.UseTokens(user, 9)
+	if len(warnings) != 3 || warnings[2] != 1 {
+		t.Fatalf("Expected a warning when a single use crosses the soft limit but got %v", warnings)
+	}
+}
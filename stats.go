@@ -0,0 +1,136 @@
+package main
+
+// RuleStats summarizes the usage of a key's rule. Allowed and Denied count token uses since the rule
+// was added or its stats were last reset, while Current and Max describe its tokens.
+type RuleStats struct {
+	Allowed uint64
+	Denied  uint64
+	Current int
+	Max     int
+}
+
+// stats returns the usage of an entry. The entry's shard must be locked, if only for reading when the
+// rule is up to date.
+func (e *entry[K]) stats() RuleStats {
+	return RuleStats{
+		Allowed: e.allowed.Load(),
+		Denied:  e.denied.Load(),
+		Current: e.rule.Remaining(),
+		Max:     e.rule.Max(),
+	}
+}
+
+// Stats returns the usage of the rule for a specified key
+func (m *KeyedManager[K]) Stats(key K) (RuleStats, error) {
+	key = m.normalize(key)
+	h := m.hash(key)
+	s := m.shard(h)
+	s.RLock()
+	e := s.entry(h, key)
+	if e == nil {
+		s.RUnlock()
+		return RuleStats{}, &KeyedRuleNotFoundError[K]{Key: key}
+	}
+	if upToDate(e.rule) {
+		stats := e.stats()
+		s.RUnlock()
+		return stats, nil
+	}
+	s.RUnlock()
+
+	s.Lock()
+	e = s.entry(h, key)
+	if e == nil {
+		s.Unlock()
+		return RuleStats{}, &KeyedRuleNotFoundError[K]{Key: key}
+	}
+	e.rule.Refill(m.clock.Now())
+	stats := e.stats()
+	s.Unlock()
+	return stats, nil
+}
+
+// StatsAll returns the usage of every registered rule by key. Each shard is copied out under its read
+// lock, so token uses on a shard only wait while it is being copied and never on the rest of the scan.
+// The stats are therefore consistent within a shard but not a single point in time across shards.
+// Rules which must be refilled to be up to date, such as lazily refilled ones, are refilled afterwards
+// under the shard's write lock.
+func (m *KeyedManager[K]) StatsAll() map[K]RuleStats {
+	all := make(map[K]RuleStats)
+	var stale []*entry[K]
+	for _, s := range m.shards {
+		stale = stale[:0]
+		s.RLock()
+		for _, e := range s.rules {
+			for ; e != nil; e = e.next {
+				if upToDate(e.rule) {
+					all[e.key] = e.stats()
+				} else {
+					stale = append(stale, e)
+				}
+			}
+		}
+		s.RUnlock()
+		if len(stale) == 0 {
+			continue
+		}
+
+		s.Lock()
+		now := m.clock.Now()
+		for _, e := range stale {
+			// the entry may have been removed or replaced while the shard was unlocked
+			if s.entry(e.hash, e.key) == e {
+				e.rule.Refill(now)
+				all[e.key] = e.stats()
+			}
+		}
+		s.Unlock()
+	}
+	return all
+}
+
+// StatsReset returns the usage of the rule for a specified key and zeroes its allowed and
+// denied counts, which is useful for periodic reporting. The counts are read and zeroed in one step
+// under the shard's lock, so each token use is reported by exactly one call. Tokens are not affected.
+func (m *KeyedManager[K]) StatsReset(key K) (RuleStats, error) {
+	key = m.normalize(key)
+	h := m.hash(key)
+	s := m.shard(h)
+	s.Lock()
+	e := s.entry(h, key)
+	if e == nil {
+		s.Unlock()
+		return RuleStats{}, &KeyedRuleNotFoundError[K]{Key: key}
+	}
+	e.rule.Refill(m.clock.Now())
+	stats := e.resetStats()
+	s.Unlock()
+	return stats, nil
+}
+
+// StatsResetAll returns the usage of every registered rule by key and zeroes their allowed and denied
+// counts, such as on each tick of a billing period. Each shard is read and reset under its lock, one
+// shard at a time, so every token use is reported by exactly one call although the stats are not a
+// single point in time across shards. Tokens are not affected.
+func (m *KeyedManager[K]) StatsResetAll() map[K]RuleStats {
+	all := make(map[K]RuleStats)
+	for _, s := range m.shards {
+		s.Lock()
+		now := m.clock.Now()
+		for _, e := range s.rules {
+			for ; e != nil; e = e.next {
+				e.rule.Refill(now)
+				all[e.key] = e.resetStats()
+			}
+		}
+		s.Unlock()
+	}
+	return all
+}
+
+// resetStats returns the usage of an entry and zeroes its counts. The entry's shard must be locked.
+func (e *entry[K]) resetStats() RuleStats {
+	stats := e.stats()
+	stats.Allowed, stats.Denied = e.allowed.Swap(0), e.denied.Swap(0)
+	return stats
+}
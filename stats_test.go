@@ -0,0 +1,126 @@
+package main
+
+import (
+	"errors"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestStats(t *testing.T) {
+	m := NewManager()
+
+	user := "user1"
+	m.AddRule(user, NewRule(1, 3*time.Second))
+	m.AddRule("user2", NewRule(1, 1*time.Second))
+	for i := 0; i < 5; i++ {
+		m.UseToken(user)
+	}
+
+	expected := RuleStats{Allowed: 3, Denied: 2, Current: 0, Max: 3}
+	stats, err := m.Stats(user)
+	if err != nil {
+		t.Fatalf("Did not expect an error on valid user, %v", err)
+	}
+	if stats != expected {
+		t.Fatalf("Expected stats %+v but got %+v", expected, stats)
+	}
+
+	all := m.StatsAll()
+	if len(all) != 2 || all[user] != expected {
+		t.Fatalf("Expected stats for both users with %+v for %s but got %+v", expected, user, all)
+	}
+
+	if stats, _ := m.StatsReset(user); stats != expected {
+		t.Fatalf("Expected reset to return stats %+v but got %+v", expected, stats)
+	}
+	expected.Allowed, expected.Denied = 0, 0
+	if stats, _ := m.Stats(user); stats != expected {
+		t.Fatalf("Expected stats %+v after reset but got %+v", expected, stats)
+	}
+
+	if _, err := m.Stats("user3"); !errors.Is(err, ErrRuleDoesNotExist) {
+		t.Fatalf("Expected %v for a missing rule but got %v", ErrRuleDoesNotExist, err)
+	}
+}
+
+func TestStatsResetAll(t *testing.T) {
+	m := NewManager()
+	m.AddRule("user1", NewRule(1, 2*time.Second))
+	m.AddRule("user2", NewRule(1, 2*time.Second))
+	m.UseTokens("user1", 2)
+	m.UseToken("user1")
+	m.UseToken("user2")
+
+	all := m.StatsResetAll()
+	if all["user1"].Allowed != 1 || all["user1"].Denied != 1 || all["user2"].Allowed != 1 {
+		t.Fatalf("Expected the counts since the rules were added but got %+v", all)
+	}
+	m.UseToken("user2")
+	all = m.StatsResetAll()
+	if all["user1"].Allowed != 0 || all["user1"].Denied != 0 || all["user2"].Allowed != 1 {
+		t.Fatalf("Expected only the counts since the last reset but got %+v", all)
+	}
+	if all["user2"].Current != 0 {
+		t.Fatalf("Did not expect tokens to be reset but got %d", all["user2"].Current)
+	}
+}
+
+func TestStatsAllLazy(t *testing.T) {
+	clock := newFakeClock()
+	m := NewManagerLazy(WithClock(clock))
+
+	m.AddRule("user1", NewRule(1, 3*time.Second))
+	m.AddRule("user2", NewRule(1, 3*time.Second, WithRollover(0)))
+	m.UseTokens("user1", 3)
+	m.UseTokens("user2", 3)
+
+	clock.Advance(3 * time.Second)
+	all := m.StatsAll()
+	if all["user1"].Current != 3 || all["user2"].Current != 3 {
+		t.Fatalf("Expected rules to be refilled before their stats are read but got %+v", all)
+	}
+}
+
+func BenchmarkStatsAllMillionKeys(b *testing.B) {
+	m := NewManager()
+
+	for i := 0; i < 1000000; i++ {
+		m.AddRule(strconv.Itoa(i), NewRule(1, 30*time.Second))
+	}
+	b.ResetTimer()
+	for n := 0; n < b.N; n++ {
+		m.StatsAll()
+	}
+}
+
+// BenchmarkQuotaUseDuringStatsAll measures token uses while StatsAll scans a million keys in a loop
+func BenchmarkQuotaUseDuringStatsAll(b *testing.B) {
+	m := NewManager()
+
+	numKeys := 1000000
+	for i := 0; i < numKeys; i++ {
+		m.AddRule(strconv.Itoa(i), NewRule(1, 30*time.Second))
+	}
+	done := make(chan struct{})
+	exited := make(chan struct{})
+	go func() {
+		defer close(exited)
+		for {
+			select {
+			case <-done:
+				return
+			default:
+				m.StatsAll()
+			}
+		}
+	}()
+
+	b.ResetTimer()
+	for n := 0; n < b.N; n++ {
+		m.UseToken(strconv.Itoa(n % numKeys))
+	}
+	b.StopTimer()
+	close(done)
+	<-exited
+}
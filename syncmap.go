@@ -0,0 +1,246 @@
+package main
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// SyncMapManager is an alternative to Manager which stores its rules in a sync.Map rather than in
+// sharded maps, which may suit read heavy workloads whose set of keys rarely changes. Looking up a key
+// takes no lock at all, and a *Rule which is up to date is used with a read lock of its own entry and
+// an atomic count, so token uses never contend across keys. In exchange adding and removing keys costs
+// more than in a sharded map, and every refill ranges over all of the rules since sync.Map cannot track
+// which ones are full, so refilling a million mostly idle rules costs far more than Manager's sweep of
+// only the rules in use. It provides the core methods of Manager's API for string keys and only honors
+// the WithUpdateRate, WithClock and WithKeyFunc options. The SyncMap benchmarks compare the two side
+// by side and should be run on the target hardware before choosing it.
+type SyncMapManager struct {
+	sync.Mutex
+	rules      sync.Map // string keys to *syncMapEntry
+	updateRate time.Duration
+	clock      Clock
+	keyFunc    func(string) string
+	done       chan struct{} // non-nil while the refill goroutine is running
+	exited     chan struct{} // closed once the refill goroutine has returned
+	nextRefill time.Time     // zero while the refill goroutine is not running
+}
+
+// syncMapEntry pairs a rule with its usage. Token uses of an up to date *Rule only need the read lock.
+type syncMapEntry struct {
+	sync.RWMutex
+	rule    Limiter
+	allowed atomic.Uint64
+	denied  atomic.Uint64
+}
+
+// NewSyncMapManager returns a new quota manager backed by a sync.Map
+func NewSyncMapManager(opts ...Option) *SyncMapManager {
+	cfg := config{updateRate: UpdateRate, clock: realClock{}}
+	for _, opt := range opts {
+		opt(&cfg)
+	}
+	return &SyncMapManager{updateRate: cfg.updateRate, clock: cfg.clock, keyFunc: cfg.keyFunc}
+}
+
+// normalize returns the key the manager looks up and stores for key
+func (m *SyncMapManager) normalize(key string) string {
+	if m.keyFunc == nil {
+		return key
+	}
+	return m.keyFunc(key)
+}
+
+// AddRule adds a new quota rule for a specified key, replacing any existing rule for the key
+func (m *SyncMapManager) AddRule(key string, r Limiter) {
+	key = m.normalize(key)
+	m.Lock()
+	r.SetSchedule(Schedule{Now: m.clock.Now(), UpdateRate: m.updateRate, NextRefill: m.nextRefill})
+	m.Unlock()
+	m.rules.Store(key, &syncMapEntry{rule: r})
+}
+
+// GetRule looks up the current rule for a specified key
+func (m *SyncMapManager) GetRule(key string) (Limiter, error) {
+	key = m.normalize(key)
+	e, ok := m.entry(key)
+	if !ok {
+		return nil, &RuleNotFoundError{Key: key}
+	}
+	return e.rule, nil
+}
+
+// RemoveRule deletes the quota rule for a specified key
+func (m *SyncMapManager) RemoveRule(key string) error {
+	key = m.normalize(key)
+	if _, loaded := m.rules.LoadAndDelete(key); !loaded {
+		return &RuleNotFoundError{Key: key}
+	}
+	return nil
+}
+
+// entry looks up the entry for a key
+func (m *SyncMapManager) entry(key string) (*syncMapEntry, bool) {
+	v, ok := m.rules.Load(key)
+	if !ok {
+		return nil, false
+	}
+	return v.(*syncMapEntry), true
+}
+
+// Remaining returns the number of tokens currently available for a specified key without using any
+// of them
+func (m *SyncMapManager) Remaining(key string) (int, error) {
+	key = m.normalize(key)
+	e, ok := m.entry(key)
+	if !ok {
+		return 0, &RuleNotFoundError{Key: key}
+	}
+	if upToDate(e.rule) {
+		e.RLock()
+		remaining := e.rule.Remaining()
+		e.RUnlock()
+		return remaining, nil
+	}
+	e.Lock()
+	e.rule.Refill(m.clock.Now())
+	remaining := e.rule.Remaining()
+	e.Unlock()
+	return remaining, nil
+}
+
+// UseToken tries to use a token for a given key and returns nil if used. Rules with a cost set by
+// WithCost use that many tokens instead.
+func (m *SyncMapManager) UseToken(key string) error {
+	return m.useTokens(key, 0)
+}
+
+// UseTokens tries to use n tokens for a given key and returns nil if used. Either all n tokens are
+// used or none are.
+func (m *SyncMapManager) UseTokens(key string, n int) error {
+	if n <= 0 {
+		return ErrInvalidTokenCount
+	}
+	return m.useTokens(key, n)
+}
+
+// useTokens uses n tokens for a key, or the rule's cost if n is zero
+func (m *SyncMapManager) useTokens(key string, n int) error {
+	key = m.normalize(key)
+	e, ok := m.entry(key)
+	if !ok {
+		return &RuleNotFoundError{Key: key}
+	}
+	if n == 0 {
+		n = cost(e.rule)
+	}
+	if upToDate(e.rule) {
+		e.RLock()
+		used := e.rule.UseTokens(n)
+		e.RUnlock()
+		if used {
+			e.allowed.Add(1)
+			return nil
+		}
+	}
+
+	e.Lock()
+	now := m.clock.Now()
+	e.rule.Refill(now)
+	if e.rule.UseTokens(n) {
+		e.Unlock()
+		e.allowed.Add(1)
+		return nil
+	}
+	m.Lock()
+	sch := Schedule{Now: now, UpdateRate: m.updateRate, NextRefill: m.nextRefill}
+	m.Unlock()
+	retryAfter, binding, _ := e.rule.RetryAfter(n, sch)
+	e.Unlock()
+	e.denied.Add(1)
+	return &QuotaExceededError{Key: key, Rule: binding, RetryAfter: retryAfter}
+}
+
+// Stats returns the usage of the rule for a specified key
+func (m *SyncMapManager) Stats(key string) (RuleStats, error) {
+	key = m.normalize(key)
+	e, ok := m.entry(key)
+	if !ok {
+		return RuleStats{}, &RuleNotFoundError{Key: key}
+	}
+	e.Lock()
+	e.rule.Refill(m.clock.Now())
+	stats := RuleStats{
+		Allowed: e.allowed.Load(),
+		Denied:  e.denied.Load(),
+		Current: e.rule.Remaining(),
+		Max:     e.rule.Max(),
+	}
+	e.Unlock()
+	return stats, nil
+}
+
+// Run starts the quota manager periodically refilling every rule. Calling Run on a manager that is
+// already running is a no-op.
+func (m *SyncMapManager) Run() {
+	m.Lock()
+	if m.done != nil {
+		m.Unlock()
+		return
+	}
+	done, exited := make(chan struct{}), make(chan struct{})
+	m.done, m.exited = done, exited
+	m.nextRefill = m.clock.Now().Add(m.updateRate)
+	m.Unlock()
+
+	ticker := m.clock.NewTicker(m.updateRate)
+	go func() {
+		defer func() {
+			ticker.Stop()
+			close(exited)
+		}()
+		for {
+			select {
+			case <-ticker.C():
+				m.addTokens()
+			case <-done:
+				return
+			}
+		}
+	}()
+}
+
+// Stop halts the periodic token refill started by Run, waiting for it to finish. Calling Stop more
+// than once is safe.
+func (m *SyncMapManager) Stop() {
+	m.Lock()
+	exited := m.exited
+	if m.done != nil {
+		close(m.done)
+		m.done, m.exited = nil, nil
+		m.nextRefill = time.Time{}
+	}
+	m.Unlock()
+	if exited != nil {
+		<-exited
+	}
+}
+
+// addTokens ranges over every rule and adds tokens to those which are not full, locking one entry at
+// a time
+func (m *SyncMapManager) addTokens() {
+	m.rules.Range(func(_, v any) bool {
+		e := v.(*syncMapEntry)
+		e.Lock()
+		if !full(e.rule) {
+			e.rule.AddToken()
+		}
+		e.Unlock()
+		return true
+	})
+	m.Lock()
+	if m.done != nil {
+		m.nextRefill = m.clock.Now().Add(m.updateRate)
+	}
+	m.Unlock()
+}
@@ -0,0 +1,176 @@
+package main
+
+import (
+	"errors"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestSyncMapManager(t *testing.T) {
+	clock := newFakeClock()
+	m := NewSyncMapManager(WithClock(clock))
+	m.Run()
+	defer m.Stop()
+
+	user := "user1"
+	m.AddRule(user, NewRule(1, 3*time.Second))
+	for i := 0; i < 3; i++ {
+		if err := m.UseToken(user); err != nil {
+			t.Fatalf("Did not expect an error on valid user, %v", err)
+		}
+	}
+	err := m.UseToken(user)
+	var qerr *QuotaExceededError
+	if !errors.As(err, &qerr) || qerr.RetryAfter <= 0 || qerr.RetryAfter > UpdateRate {
+		t.Fatalf("Expected %v retrying after the next refill but got %v", ErrQuotaExceeded, err)
+	}
+
+	m.addTokens()
+	if remaining, _ := m.Remaining(user); remaining != 1 {
+		t.Fatalf("Expected a refill to add a token but got %d", remaining)
+	}
+	if stats, _ := m.Stats(user); stats.Allowed != 3 || stats.Denied != 1 {
+		t.Fatalf("Expected 3 allowed and 1 denied but got %+v", stats)
+	}
+
+	if err := m.RemoveRule(user); err != nil {
+		t.Fatalf("Did not expect an error removing a valid user, %v", err)
+	}
+	if err := m.UseToken(user); !errors.Is(err, ErrRuleDoesNotExist) {
+		t.Fatalf("Expected %v for a removed rule but got %v", ErrRuleDoesNotExist, err)
+	}
+}
+
+func TestSyncMapManagerConcurrentUseSingleKey(t *testing.T) {
+	m := NewSyncMapManager(WithClock(newFakeClock()))
+
+	m.AddRule("user1", NewRule(100, 10*time.Second))
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	var allowed int
+	for g := 0; g < 50; g++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < 100; i++ {
+				if m.UseToken("user1") == nil {
+					mu.Lock()
+					allowed++
+					mu.Unlock()
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	if allowed != 1000 {
+		t.Fatalf("Expected exactly 1000 tokens to be used but got %d", allowed)
+	}
+}
+
+// quotaManager is the part of the API shared by Manager and SyncMapManager which the side by side
+// benchmarks use
+type quotaManager interface {
+	AddRule(key string, r Limiter)
+	GetRule(key string) (Limiter, error)
+	Remaining(key string) (int, error)
+	UseToken(key string) error
+	Run()
+	Stop()
+}
+
+// managers returns a fresh manager of each kind for the side by side benchmarks
+func managers() map[string]func() (quotaManager, func()) {
+	return map[string]func() (quotaManager, func()){
+		"Sharded": func() (quotaManager, func()) {
+			m := NewManager()
+			return m, m.addTokens
+		},
+		"SyncMap": func() (quotaManager, func()) {
+			m := NewSyncMapManager()
+			return m, m.addTokens
+		},
+	}
+}
+
+func BenchmarkSyncMapReadHeavy(b *testing.B) {
+	for name, newManager := range managers() {
+		b.Run(name, func(b *testing.B) {
+			m, _ := newManager()
+			m.Run()
+			defer m.Stop()
+
+			numKeys := 1000000
+			keys := make([]string, numKeys)
+			for i := range keys {
+				keys[i] = strconv.Itoa(i)
+				m.AddRule(keys[i], NewRule(1000, 5*time.Second))
+			}
+
+			b.ResetTimer()
+			b.RunParallel(func(pb *testing.PB) {
+				var i int
+				for pb.Next() {
+					key := keys[(i*7919)%numKeys]
+					switch i % 10 {
+					case 0:
+						m.UseToken(key)
+					case 1, 2, 3:
+						m.GetRule(key)
+					default:
+						m.Remaining(key)
+					}
+					i++
+				}
+			})
+		})
+	}
+}
+
+func BenchmarkSyncMapUseMillionKeys(b *testing.B) {
+	for name, newManager := range managers() {
+		b.Run(name, func(b *testing.B) {
+			m, _ := newManager()
+			numKeys := 1000000
+			keys := make([]string, numKeys)
+			for i := range keys {
+				keys[i] = strconv.Itoa(i)
+				m.AddRule(keys[i], NewRule(1, 5*time.Second))
+			}
+
+			b.ResetTimer()
+			b.RunParallel(func(pb *testing.PB) {
+				var i int
+				for pb.Next() {
+					m.UseToken(keys[(i*7919)%numKeys])
+					i++
+				}
+			})
+		})
+	}
+}
+
+func BenchmarkSyncMapUpdateMillionKeysMostlyIdle(b *testing.B) {
+	for name, newManager := range managers() {
+		b.Run(name, func(b *testing.B) {
+			m, addTokens := newManager()
+			numKeys := 1000000
+			for i := 0; i < numKeys; i++ {
+				m.AddRule(strconv.Itoa(i), NewRule(1, 30*time.Second))
+			}
+			addTokens()
+
+			// 1% of keys are in use each refill
+			b.ResetTimer()
+			for n := 0; n < b.N; n++ {
+				b.StopTimer()
+				for i := 0; i < numKeys/100; i++ {
+					m.UseToken(strconv.Itoa((n*numKeys/100 + i) % numKeys))
+				}
+				b.StartTimer()
+				addTokens()
+			}
+		})
+	}
+}
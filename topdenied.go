@@ -0,0 +1,79 @@
+package main
+
+import (
+	"container/heap"
+	"sort"
+)
+
+// KeyedCount pairs a key with a count of its token uses
+type KeyedCount[K comparable] struct {
+	Key   K
+	Count uint64
+}
+
+// KeyCount is a KeyedCount for a Manager keyed by strings
+type KeyCount = KeyedCount[string]
+
+// TopDenied returns up to n keys with the most denied token uses, most denied first, to find the keys
+// hitting their limits hardest. The counts are exact as of when each shard is read, one shard at a
+// time under its read lock, and are those since the rule was added or its stats were last reset. Keys
+// which have never been denied are left out and ties are in no particular order. Only n keys are
+// held while scanning, so it costs one pass over the rules however many there are.
+func (m *KeyedManager[K]) TopDenied(n int) []KeyedCount[K] {
+	if n <= 0 {
+		return nil
+	}
+	top := make(countHeap[K], 0, n)
+	for _, s := range m.shards {
+		s.RLock()
+		for _, e := range s.rules {
+			for ; e != nil; e = e.next {
+				if denied := e.denied.Load(); denied > 0 {
+					top.offer(KeyedCount[K]{Key: e.key, Count: denied})
+				}
+			}
+		}
+		s.RUnlock()
+	}
+	sort.Slice(top, func(i, j int) bool {
+		return top[i].Count > top[j].Count
+	})
+	return top
+}
+
+// countHeap is a min-heap of counts, keeping the least of the top counts at its root
+type countHeap[K comparable] []KeyedCount[K]
+
+// offer adds c to the heap if it has room, as set by its capacity, or if c exceeds the least count held
+func (h *countHeap[K]) offer(c KeyedCount[K]) {
+	switch {
+	case len(*h) < cap(*h):
+		heap.Push(h, c)
+	case len(*h) > 0 && c.Count > (*h)[0].Count:
+		(*h)[0] = c
+		heap.Fix(h, 0)
+	}
+}
+
+func (h countHeap[K]) Len() int {
+	return len(h)
+}
+
+func (h countHeap[K]) Less(i, j int) bool {
+	return h[i].Count < h[j].Count
+}
+
+func (h countHeap[K]) Swap(i, j int) {
+	h[i], h[j] = h[j], h[i]
+}
+
+func (h *countHeap[K]) Push(x interface{}) {
+	*h = append(*h, x.(KeyedCount[K]))
+}
+
+func (h *countHeap[K]) Pop() interface{} {
+	last := len(*h) - 1
+	c := (*h)[last]
+	*h = (*h)[:last]
+	return c
+}
@@ -0,0 +1,36 @@
+package main
+
+import (
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestTopDenied(t *testing.T) {
+	m := NewManager()
+	for i := 0; i < 10; i++ {
+		key := "user" + strconv.Itoa(i)
+		m.AddRule(key, NewRule(1, 1*time.Second))
+		for j := 0; j <= i; j++ {
+			m.UseToken(key)
+		}
+	}
+
+	top := m.TopDenied(3)
+	expected := []KeyCount{{"user9", 9}, {"user8", 8}, {"user7", 7}}
+	if len(top) != len(expected) {
+		t.Fatalf("Expected %v but got %v", expected, top)
+	}
+	for i := range expected {
+		if top[i] != expected[i] {
+			t.Fatalf("Expected %v but got %v", expected, top)
+		}
+	}
+
+	if top := m.TopDenied(20); len(top) != 9 {
+		t.Fatalf("Expected only the 9 keys which were denied but got %v", top)
+	}
+	if top := m.TopDenied(0); len(top) != 0 {
+		t.Fatalf("Did not expect any keys but got %v", top)
+	}
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"time"
+)
+
+// WithWaitPolling caps how long WaitToken and WaitTokenTimeout sleep between attempts at step, rather
+// than sleeping until a token is expected or the next refill, such as to notice tokens returned or
+// added in the meantime. With backoff the step doubles after each attempt by the same call, up to the
+// update interval. A non-positive step waits for refills alone.
+func WithWaitPolling(step time.Duration, backoff bool) Option {
+	return func(c *config) {
+		c.pollStep = max(step, 0)
+		c.pollBackoff = backoff
+	}
+}
+
+// WaitTokenTimeout blocks until a token can be used for a given key as WaitToken does, returning
+// ErrWaitTimeout if none could be used within timeout, such as for a rule which is perpetually
+// saturated. The timeout is measured against the manager's clock, and ErrWaitTimeout is returned
+// without waiting once a token is not expected before it expires.
+func (m *KeyedManager[K]) WaitTokenTimeout(key K, timeout time.Duration) error {
+	return m.wait(context.Background(), key, m.clock.Now().Add(timeout))
+}
+
+// wait retries using a token for a key until it is used, ctx is done or the deadline on the manager's
+// clock passes, which never happens if it is zero
+func (m *KeyedManager[K]) wait(ctx context.Context, key K, deadline time.Time) error {
+	key = m.normalize(key)
+	step := m.pollStep
+	for {
+		err := m.UseToken(key)
+		var qe *KeyedQuotaExceededError[K]
+		if !errors.As(err, &qe) {
+			return err
+		}
+		if l, ok := m.listed(key); ok && l == denied {
+			return err
+		}
+
+		wait := qe.RetryAfter
+		if wait <= 0 {
+			wait = m.untilRefill()
+		}
+		if step > 0 && step < wait {
+			wait = step
+		}
+		if m.pollBackoff && step > 0 {
+			step = min(2*step, max(m.updateRate, m.pollStep))
+		}
+		if !deadline.IsZero() {
+			left := deadline.Sub(m.clock.Now())
+			if left <= 0 || qe.RetryAfter > left {
+				return ErrWaitTimeout
+			}
+			wait = min(wait, left)
+		}
+
+		// context deadlines are always measured against the system clock
+		if deadline, ok := ctx.Deadline(); ok && deadline.Before(time.Now().Add(wait)) {
+			return context.DeadlineExceeded
+		}
+
+		timer := m.clock.NewTimer(wait)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return ctx.Err()
+		case <-timer.C():
+		}
+	}
+}
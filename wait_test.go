@@ -0,0 +1,70 @@
+package main
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+// nextTimer waits for a single timer to be set on the clock and returns how long until it fires
+func nextTimer(t *testing.T, clock *FakeClock) time.Duration {
+	deadline := time.Now().Add(time.Second)
+	for clock.Waiters() != 1 {
+		if time.Now().After(deadline) {
+			t.Fatalf("Expected a timer to be set but got %d waiters", clock.Waiters())
+		}
+		time.Sleep(time.Millisecond)
+	}
+	clock.Lock()
+	wait := clock.waiters[0].when.Sub(clock.now)
+	clock.Unlock()
+	return wait
+}
+
+func TestWaitTokenTimeout(t *testing.T) {
+	clock := newFakeClock()
+	m := NewManager(WithClock(clock), WithWaitPolling(100*time.Millisecond, true))
+	m.AddRule("user1", NewRule(1, 1*time.Second))
+	m.UseToken("user1")
+
+	errc := make(chan error, 1)
+	go func() {
+		errc <- m.WaitTokenTimeout("user1", 500*time.Millisecond)
+	}()
+	for _, expected := range []time.Duration{100 * time.Millisecond, 200 * time.Millisecond, 200 * time.Millisecond} {
+		if wait := nextTimer(t, clock); wait != expected {
+			t.Fatalf("Expected to poll again in %v but got %v", expected, wait)
+		}
+		clock.Advance(expected)
+	}
+	if err := <-errc; err != ErrWaitTimeout {
+		t.Fatalf("Expected %v for a saturated rule but got %v", ErrWaitTimeout, err)
+	}
+
+	go func() {
+		errc <- m.WaitTokenTimeout("user1", time.Second)
+	}()
+	nextTimer(t, clock)
+	m.ReturnToken("user1")
+	clock.Advance(100 * time.Millisecond)
+	if err := <-errc; err != nil {
+		t.Fatalf("Did not expect an error once a token was returned, %v", err)
+	}
+}
+
+func TestWaitTokenTimeoutLazy(t *testing.T) {
+	clock := newFakeClock()
+	m := NewManagerLazy(WithClock(clock))
+	m.AddRule("user1", NewRule(1, 1*time.Second))
+	m.UseToken("user1")
+
+	if err := m.WaitTokenTimeout("user1", 500*time.Millisecond); err != ErrWaitTimeout {
+		t.Fatalf("Expected %v without waiting for a token due after the timeout but got %v", ErrWaitTimeout, err)
+	}
+	if clock.Waiters() != 0 {
+		t.Fatalf("Did not expect a timer to be set but got %d waiters", clock.Waiters())
+	}
+	if err := m.WaitTokenTimeout("user2", time.Second); !errors.Is(err, ErrRuleDoesNotExist) {
+		t.Fatalf("Expected %v for a missing rule but got %v", ErrRuleDoesNotExist, err)
+	}
+}
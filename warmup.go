@@ -0,0 +1,34 @@
+package main
+
+import (
+	"time"
+)
+
+// WithWarmup makes a new rule start with no tokens and ramp up to its burst over d, such as to protect
+// a cold backend from a thundering herd when a tenant is first added. While warming up the rule is
+// refilled at its burst spread over d in place of its qps, so a warmup shorter than the window ramps
+// up faster than the qps and a longer one ramps up slower, and the qps applies once the warmup ends.
+// The warmup starts as the rule is added to a manager and ends early once the rule is full. Rules
+// which roll over start their first window empty and are reset to their burst at its end. Non-positive
+// durations are ignored.
+func WithWarmup(d time.Duration) RuleOption {
+	return func(r *Rule) {
+		if d > 0 {
+			r.warmup, r.warmupLeft = d, d
+			r.setCount(0)
+		}
+	}
+}
+
+// earned returns the tokens a rule earns over elapsed, at its warmup rate for whatever part of it is
+// left of the warmup and at its qps after
+func (r *Rule) earned(elapsed time.Duration) float64 {
+	var tokens float64
+	if r.warmupLeft > 0 {
+		warming := min(elapsed, r.warmupLeft)
+		r.warmupLeft -= warming
+		elapsed -= warming
+		tokens = warming.Seconds() / r.warmup.Seconds() * float64(r.burst)
+	}
+	return tokens + elapsed.Seconds()*r.rate
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestWarmup(t *testing.T) {
+	m := NewManager(WithUpdateRate(time.Second))
+
+	user := "user1"
+	m.AddRule(user, NewRule(1, 10*time.Second, WithWarmup(5*time.Second)))
+	if err := m.UseToken(user); err == nil {
+		t.Fatalf("Did not expect a warming up rule to start with tokens")
+	}
+
+	m.addTokens()
+	if remaining, _ := m.Remaining(user); remaining != 2 {
+		t.Fatalf("Expected a fifth of the burst after a second of warmup but got %d", remaining)
+	}
+	for i := 0; i < 4; i++ {
+		m.addTokens()
+	}
+	if remaining, _ := m.Remaining(user); remaining != 10 {
+		t.Fatalf("Expected the full burst once warmed up but got %d", remaining)
+	}
+
+	m.UseTokens(user, 10)
+	m.addTokens()
+	if remaining, _ := m.Remaining(user); remaining != 1 {
+		t.Fatalf("Expected the rule to refill at its qps after warming up but got %d", remaining)
+	}
+}
+
+func TestWarmupLazy(t *testing.T) {
+	clock := newFakeClock()
+	m := NewManagerLazy(WithClock(clock))
+
+	user := "user1"
+	m.AddRule(user, NewRule(2, 5*time.Second, WithWarmup(20*time.Second)))
+
+	clock.Advance(4 * time.Second)
+	if remaining, _ := m.Remaining(user); remaining != 2 {
+		t.Fatalf("Expected 2 tokens after 4s of a 20s warmup but got %d", remaining)
+	}
+	clock.Advance(14 * time.Second)
+	if remaining, _ := m.Remaining(user); remaining != 9 {
+		t.Fatalf("Expected the warmup to ramp slower than the qps but got %d", remaining)
+	}
+
+	m.UseTokens(user, 9)
+	clock.Advance(4 * time.Second)
+	if remaining, _ := m.Remaining(user); remaining != 5 {
+		t.Fatalf("Expected 1 token warming up and 4 at the qps after but got %d", remaining)
+	}
+}
+
+func TestWarmupBurst(t *testing.T) {
+	m := NewManager(WithUpdateRate(time.Second))
+
+	m.SetDefaultRule(NewRuleWithBurst(1, 10*time.Second, 4, WithWarmup(2*time.Second)))
+	if err := m.UseToken("user1"); err == nil {
+		t.Fatalf("Did not expect a rule from a warming up template to start with tokens")
+	}
+	m.addTokens()
+	if remaining, _ := m.Remaining("user1"); remaining != 2 {
+		t.Fatalf("Expected the warmup to ramp up to the burst but got %d", remaining)
+	}
+}
@@ -0,0 +1,44 @@
+package main
+
+import (
+	"runtime"
+	"sync"
+	"sync/atomic"
+)
+
+// WithRefillWorkers has a running manager refill its shards on n goroutines in parallel, such as for
+// very large rule sets where a single goroutine cannot keep up with the update interval. Each worker
+// takes whole shards and locks only the shard it is refilling. A non-positive n uses GOMAXPROCS
+// workers, and shards are refilled serially by default.
+func WithRefillWorkers(n int) Option {
+	return func(c *config) {
+		if n <= 0 {
+			n = runtime.GOMAXPROCS(0)
+		}
+		c.workers = n
+	}
+}
+
+// eachShard calls fn with the index of every shard and the shard, from the manager's refill workers if
+// it has more than one and returning once all calls have. fn must lock the shard itself.
+func (m *KeyedManager[K]) eachShard(fn func(i int, s *shard[K])) {
+	workers := min(m.workers, len(m.shards))
+	if workers <= 1 {
+		for i, s := range m.shards {
+			fn(i, s)
+		}
+		return
+	}
+	var next atomic.Int64
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := int(next.Add(1) - 1); i < len(m.shards); i = int(next.Add(1) - 1) {
+				fn(i, m.shards[i])
+			}
+		}()
+	}
+	wg.Wait()
+}
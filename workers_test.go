@@ -0,0 +1,66 @@
+package main
+
+import (
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestRefillWorkers(t *testing.T) {
+	m := NewManager(WithRefillWorkers(4))
+
+	for i := 0; i < 1000; i++ {
+		key := strconv.Itoa(i)
+		m.AddRule(key, NewRule(1, 20*time.Second))
+		m.UseTokens(key, 1+i%20)
+	}
+	m.addTokens()
+	for i := 0; i < 1000; i++ {
+		expected := min(20, 20-(1+i%20)+1)
+		if remaining, _ := m.Remaining(strconv.Itoa(i)); remaining != expected {
+			t.Fatalf("Expected rule %d to be refilled to %d tokens but got %d", i, expected, remaining)
+		}
+	}
+}
+
+func TestRefillWorkersQueued(t *testing.T) {
+	clock := newFakeClock()
+	m := NewManager(WithClock(clock), WithRefillQueue(), WithRefillWorkers(0))
+
+	for i := 0; i < 100; i++ {
+		key := strconv.Itoa(i)
+		m.AddRule(key, NewRule(10, 2*time.Second))
+		m.UseToken(key)
+	}
+	clock.Advance(UpdateRate)
+	if wait := m.refillQueued(); wait != UpdateRate {
+		t.Fatalf("Expected the next refill to be due in an interval but got %v", wait)
+	}
+	for i := 0; i < 100; i++ {
+		if remaining, _ := m.Remaining(strconv.Itoa(i)); remaining != 20 {
+			t.Fatalf("Expected rule %d to be refilled but got %d tokens", i, remaining)
+		}
+	}
+}
+
+func benchmarkRefillMillionKeys(b *testing.B, opts ...Option) {
+	m := NewManager(opts...)
+
+	for i := 0; i < 1000000; i++ {
+		key := strconv.Itoa(i)
+		m.AddRule(key, NewRuleRate(0.01, 100*time.Hour))
+		m.UseTokens(key, 3600)
+	}
+	b.ResetTimer()
+	for n := 0; n < b.N; n++ {
+		m.addTokens()
+	}
+}
+
+func BenchmarkRefillMillionKeysSerial(b *testing.B) {
+	benchmarkRefillMillionKeys(b)
+}
+
+func BenchmarkRefillMillionKeysWorkers(b *testing.B) {
+	benchmarkRefillMillionKeys(b, WithRefillWorkers(0))
+}